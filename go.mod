@@ -3,13 +3,16 @@ module github.com/seboghpub/goyammer
 go 1.12
 
 require (
+	github.com/BurntSushi/toml v0.3.1
 	github.com/StackExchange/wmi v0.0.0-20190523213315-cbe66965904d // indirect
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/getlantern/systray v0.0.0-20200518005515-1e7b8346e907
 	github.com/go-ole/go-ole v1.2.4 // indirect
+	github.com/godbus/dbus/v5 v5.0.3
 	github.com/mattn/go-gtk v0.0.0-20191030024613-af2e013261f5 // indirect
 	github.com/mqu/go-notify v0.0.0-20130719194048-ef6f6f49d093
 	github.com/phayes/freeport v0.0.0-20180830031419-95f893ade6f2
 	github.com/rs/zerolog v1.18.0
 	github.com/shirou/gopsutil v2.20.5+incompatible
+	golang.org/x/crypto v0.0.0-20200604202706-70a84ac30bf9
 )
@@ -1,21 +1,44 @@
 package internal
 
 import (
+	"bufio"
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"github.com/phayes/freeport"
 	"github.com/rs/zerolog/log"
+	"html/template"
 	"io"
+	"io/ioutil"
+	"net"
 	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
 	"time"
 )
 
 const YammerOAuthUrl = "https://www.yammer.com/dialog/oauth"
+const YammerTokenUrl = "https://www.yammer.com/oauth2/access_token"
 
-// "Controller HTML" to orchestrate the passing of an access token to the server.
+// DefaultLoginTimeout is the default time to wait for the user to authorize.
+const DefaultLoginTimeout = 5 * time.Minute
+
+// OAuth 2.0 flows.
+const (
+	FlowCode     = "code"
+	FlowImplicit = "implicit"
+)
+
+// "Controller HTML" to orchestrate the passing of an access token to the server (implicit flow).
 //
-// This HTML executes Javascript that extracts the access token from the URI fragment (set by the authentication server)
-// and then sends the extracted access token via URL parameter of an Ajax-Fetch request to the server (SYN). As a
+// This HTML executes Javascript that extracts the access token and the state from the URI fragment (set by the
+// authentication server) and then sends them via URL parameters of an Ajax-Fetch request to the server (SYN). As a
 // response for the fetch request, the server acknowledges the token (SYN-ACK). The client, finally fetches a second
 // endpoint and thereby signals the server that it may shot down now (ACK).
 const fragmentExtractHtml = `
@@ -23,107 +46,520 @@ const fragmentExtractHtml = `
 <html lang="en">
 <head>
     <meta charset="UTF-8">
+    <title>goyammer</title>
+    <style>body { font-family: sans-serif; text-align: center; margin-top: 4em; }</style>
+</head>
+<body>
+    <h1>goyammer</h1>
+    <p id="result">logging in...</p>
     <script>
-        if(window.location.hash) {
-            const fragment=window.location.hash.substr(1);
-            const token=fragment.split('=')[1]
-            fetch('/token?token='+token)
+        const result = document.getElementById("result");
+        const fragment = new URLSearchParams(window.location.hash.substr(1));
+        if (fragment.has("access_token")) {
+            fetch('/token?token=' + encodeURIComponent(fragment.get("access_token")) +
+                  '&state=' + encodeURIComponent(fragment.get("state") || ""))
                 .then((response) => {
                     response.text().then(function(text) {
-                        document.getElementById("result").innerHTML = text;
+                        result.textContent = text;
                         fetch('/done')
                     })
                 })
         } else {
-            document.getElementById("result").innerHTML = "something went wrong";
+            result.textContent = "Login failed: " + (fragment.get("error_description") || fragment.get("error") || "no access token received");
         }
     </script>
+</body>
+</html>
+`
+
+// The page shown to the user at the end of the code flow.
+var resultPage = template.Must(template.New("result").Parse(`
+<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <title>goyammer</title>
+    <style>body { font-family: sans-serif; text-align: center; margin-top: 4em; }</style>
 </head>
 <body>
-    <span id="result"></span>
+    <h1>{{.Title}}</h1>
+    <p>{{.Message}}</p>
 </body>
 </html>
-`
+`))
+
+// writePage writes the result page with the given status.
+func writePage(w http.ResponseWriter, status int, title string, message string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	errPage := resultPage.Execute(w, struct{ Title, Message string }{title, message})
+	if errPage != nil {
+		log.Warn().Err(errPage).Msg("failed to send result page")
+	}
+}
+
+// callback is the data structure to represent the state of the login callback server. It accepts exactly one callback
+// carrying the expected state and rejects forged or repeated ones (without giving up on the login).
+type callback struct {
+	state string
+
+	mutex sync.Mutex
+	done  bool
+
+	// channels for communicating with the handlers
+	tokenChannel  chan string
+	codeChannel   chan string
+	errChannel    chan error
+	resultChannel chan bool
+}
+
+// newCallback returns a callback expecting the given state.
+func newCallback(state string) *callback {
+	return &callback{
+		state:         state,
+		tokenChannel:  make(chan string, 1),
+		codeChannel:   make(chan string, 1),
+		errChannel:    make(chan error, 3),
+		resultChannel: make(chan bool, 1),
+	}
+}
+
+// accept marks the callback as done and returns false if it has been done already.
+func (cb *callback) accept() bool {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+	if cb.done {
+		return false
+	}
+	cb.done = true
+	return true
+}
 
-// The redirect handler simply returns the "Controller HTML".
-func redirectHandler(w http.ResponseWriter, _ *http.Request) {
+// checkState returns whether the given state is the expected one (logging a warning if not).
+func (cb *callback) checkState(r *http.Request, state string) bool {
+	if subtle.ConstantTimeCompare([]byte(state), []byte(cb.state)) == 1 {
+		return true
+	}
+	log.Warn().Msg(fmt.Sprintf("rejected login callback with invalid state from %s", r.RemoteAddr))
+	return false
+}
+
+// The redirect handler simply returns the "Controller HTML" (implicit flow).
+func (cb *callback) handleRedirect(w http.ResponseWriter, _ *http.Request) {
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	_, err := io.WriteString(w, fragmentExtractHtml)
 	if err != nil {
-		log.Fatal().Err(err).Msg("failed to send controller HTML")
+		log.Warn().Err(err).Msg("failed to send controller HTML")
 	}
 }
 
-// The handler to receive the access token via URL parameter (SYN) and to acknowledge the token (SYN-ACK)
-func makeTokenHandler(tokenChannel chan string) func(http.ResponseWriter, *http.Request) {
-	return func(w http.ResponseWriter, r *http.Request) {
-		values := r.URL.Query()["token"]
-		if len(values) < 1 {
-			log.Fatal().Msg("missing token in client request")
-		}
-		// send the token to the parent
-		tokenChannel <- values[0]
-		close(tokenChannel)
-		_, _ = fmt.Fprintf(w, "success")
+// The handler to receive the access token via URL parameter (SYN) and to acknowledge the token (SYN-ACK).
+func (cb *callback) handleToken(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	query := r.URL.Query()
+	token := query.Get("token")
+	if token == "" || !cb.checkState(r, query.Get("state")) {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = fmt.Fprint(w, "Login failed: invalid request.")
+		return
 	}
+	if !cb.accept() {
+		w.WriteHeader(http.StatusConflict)
+		_, _ = fmt.Fprint(w, "Login has already been completed.")
+		return
+	}
+
+	// send the token to the parent
+	cb.tokenChannel <- token
+	_, _ = fmt.Fprint(w, "Login successful, you may close this window.")
 }
 
 // The handler to receive the final call indicating that the server may shot down now (ACK).
-func makeDoneHandler(c chan bool) func(http.ResponseWriter, *http.Request) {
-	return func(res http.ResponseWriter, req *http.Request) {
-		c <- true
+func (cb *callback) handleDone(_ http.ResponseWriter, _ *http.Request) {
+	select {
+	case cb.resultChannel <- true:
+	default:
+	}
+}
+
+// The handler to receive the authorization code via redirect (code flow).
+func (cb *callback) handleCode(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if !cb.checkState(r, query.Get("state")) {
+		writePage(w, http.StatusBadRequest, "Login failed", "The login request is invalid or has expired.")
+		return
+	}
+	if !cb.accept() {
+		writePage(w, http.StatusConflict, "Login completed", "Login has already been completed, you may close this window.")
+		return
+	}
+	if errCode := query.Get("error"); errCode != "" {
+		writePage(w, http.StatusOK, "Login failed", fmt.Sprintf("Yammer denied the authorization: %s", errCode))
+		cb.errChannel <- fmt.Errorf("authorization failed: %s %s", errCode, query.Get("error_description"))
+		return
+	}
+	code := query.Get("code")
+	if code == "" {
+		writePage(w, http.StatusBadRequest, "Login failed", "No authorization code has been received.")
+		cb.errChannel <- fmt.Errorf("authorization response without code")
+		return
+	}
+	writePage(w, http.StatusOK, "Login successful", "goyammer has been authorized, you may close this window.")
+	cb.codeChannel <- code
+}
+
+// Authenticator is the data structure to configure how a user is authenticated.
+type Authenticator struct {
+	ClientID string
+
+	// ClientSecret is only sent if set (the code flow with PKCE does not require it).
+	ClientSecret string
+
+	// Flow is either "code" (authorization code with PKCE) or "implicit".
+	Flow string
+
+	// AuthURL is the authorization endpoint and TokenURL the token endpoint (code flow only).
+	AuthURL  string
+	TokenURL string
+
+	// Browse is called with the URL the user has to open to authorize (prints the URL by default).
+	Browse func(authUrl string)
+
+	// Timeout is how long to wait for the user to authorize (0 for no limit).
+	Timeout time.Duration
+
+	// NoCallback disables the callback server: the user pastes the URL the browser has been redirected to (or just the
+	// code or token) instead, which is read from Input (stdin by default, not to be changed after the first read).
+	NoCallback bool
+	Input      io.Reader
+
+	httpClient *http.Client
+
+	// the reader of Input and the line being read from it (see readLine)
+	input   *bufio.Reader
+	pending chan pastedLine
+}
+
+// pastedLine is a line read from the input of an Authenticator.
+type pastedLine struct {
+	line string
+	err  error
+}
+
+// NewAuthenticator returns an Authenticator for the given client using the code flow against Yammer.
+func NewAuthenticator(clientId string) *Authenticator {
+	return &Authenticator{
+		ClientID: clientId,
+		Flow:     FlowCode,
+		AuthURL:  YammerOAuthUrl,
+		TokenURL: YammerTokenUrl,
+		Browse: func(authUrl string) {
+			fmt.Printf("please authorize at: %s\n", authUrl)
+		},
+		Timeout:    DefaultLoginTimeout,
+		Input:      os.Stdin,
+		httpClient: &http.Client{Timeout: DefaultTimeout},
+	}
+}
+
+// randomString returns a URL-safe string of the given number of random bytes.
+func randomString(size int) (string, error) {
+	data := make([]byte, size)
+	_, errRead := rand.Read(data)
+	if errRead != nil {
+		return "", fmt.Errorf("failed to read random bytes: %v", errRead)
 	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// pkceChallenge returns the S256 code challenge for the given code verifier.
+func pkceChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
 }
 
-// Authenticate authenticate a user via OAUTH2 Implicit Flow and returns the access token.
-func Authenticate(clientId string) string {
+// Authenticate authenticates a user via OAuth 2.0 (either authorization code flow with PKCE or implicit flow) and
+// returns the access token.
+func (auth *Authenticator) Authenticate() (string, error) {
 
-	// create channels for communicating with the handlers
-	tokenChannel := make(chan string, 1)
-	resultChannel := make(chan bool, 1)
+	// random values protecting the flows
+	state, errState := randomString(16)
+	if errState != nil {
+		return "", errState
+	}
+	verifier, errVerifier := randomString(32)
+	if errVerifier != nil {
+		return "", errVerifier
+	}
+	if auth.NoCallback {
+		return auth.authenticatePasted(state, verifier)
+	}
+	cb := newCallback(state)
 
 	// create handler
 	mux := http.NewServeMux()
-	mux.HandleFunc("/oauth/redirect", redirectHandler)
-	mux.HandleFunc("/token", makeTokenHandler(tokenChannel))
-	mux.HandleFunc("/done", makeDoneHandler(resultChannel))
+	switch auth.Flow {
+	case FlowCode:
+		mux.HandleFunc("/oauth/redirect", cb.handleCode)
+	case FlowImplicit:
+		mux.HandleFunc("/oauth/redirect", cb.handleRedirect)
+		mux.HandleFunc("/token", cb.handleToken)
+		mux.HandleFunc("/done", cb.handleDone)
+	default:
+		return "", fmt.Errorf("unknown flow '%s', expected code or implicit", auth.Flow)
+	}
 
 	// get a free port
 	port, err := freeport.GetFreePort()
 	if err != nil {
-		log.Fatal().Err(err).Msg("failed to get a free TCP port")
+		return "", fmt.Errorf("failed to get a free TCP port: %v", err)
 	}
 
 	// configure server
 	server := http.Server{
-		Addr:    fmt.Sprintf(":%d", +port),
-		Handler: mux,
+		Handler:      mux,
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 10 * time.Second,
 	}
 
-	// start the server in a goroutine
-	go func() {
-		if err := server.ListenAndServe(); err != http.ErrServerClosed {
-			log.Fatal().Err(err).Msg("failed to start server")
-		}
-	}()
+	// listen on the loopback interfaces only (IPv6 is optional) and right away (so that the redirect cannot come too
+	// early), serve in goroutines
+	listener, errListen := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if errListen != nil {
+		return "", fmt.Errorf("failed to start server: %v", errListen)
+	}
+	listeners := []net.Listener{listener}
+	if listener6, errListen6 := net.Listen("tcp", fmt.Sprintf("[::1]:%d", port)); errListen6 == nil {
+		listeners = append(listeners, listener6)
+	}
+	for _, l := range listeners {
+		go func(l net.Listener) {
+			if err := server.Serve(l); err != http.ErrServerClosed {
+				cb.errChannel <- fmt.Errorf("failed to serve: %v", err)
+			}
+		}(l)
+	}
 
 	// tell the user which URL to open in his browser
 	redirectURI := fmt.Sprintf("http://localhost:%d/oauth/redirect", port)
-	authUrl := fmt.Sprintf("%s?client_id=%s&redirect_uri=%s&response_type=token", YammerOAuthUrl, clientId, redirectURI)
-	fmt.Printf("please authorize at: %s\n", authUrl)
+	auth.Browse(auth.authorizationURL(redirectURI, state, verifier))
+
+	// give up after the timeout
+	timeout, stop := auth.timeout()
+	defer stop()
 
-	// wait for the token (SYN)
-	token := <-tokenChannel
+	// wait for the token (implicit flow, SYN) or the code (code flow)
+	var token string
+	select {
+	case token = <-cb.tokenChannel:
+
+		// wait for the signal to shutdown the server (ACK), but not forever
+		select {
+		case <-cb.resultChannel:
+		case <-time.After(5 * time.Second):
+		}
 
-	// wait for the signal to shutdown the server (ACK)
-	<-resultChannel
+	case code := <-cb.codeChannel:
+		token, err = auth.exchange(code, redirectURI, verifier)
+	case err = <-cb.errChannel:
+	case <-timeout:
+		err = auth.timeoutError()
+	}
 
 	// shutdown server (in a timeout context).
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
-	if err := server.Shutdown(ctx); err != nil {
-		log.Fatal().Err(err).Msg("failed to shutdown server")
+	if errShutdown := server.Shutdown(ctx); errShutdown != nil {
+		log.Warn().Err(errShutdown).Msg("failed to shutdown server")
+	}
+
+	return token, err
+}
+
+// authorizationURL returns the URL the user has to open to authorize.
+func (auth *Authenticator) authorizationURL(redirectURI string, state string, verifier string) string {
+	params := url.Values{}
+	params.Set("client_id", auth.ClientID)
+	params.Set("redirect_uri", redirectURI)
+	params.Set("state", state)
+	if auth.Flow == FlowCode {
+		params.Set("response_type", "code")
+		params.Set("code_challenge", pkceChallenge(verifier))
+		params.Set("code_challenge_method", "S256")
+	} else {
+		params.Set("response_type", "token")
+	}
+	return fmt.Sprintf("%s?%s", auth.AuthURL, params.Encode())
+}
+
+// timeout returns a channel signaling the timeout (nil if there is none) and a function to release its timer.
+func (auth *Authenticator) timeout() (<-chan time.Time, func()) {
+	if auth.Timeout <= 0 {
+		return nil, func() {}
+	}
+	timer := time.NewTimer(auth.Timeout)
+	return timer.C, func() { timer.Stop() }
+}
+
+// timeoutError returns the error to report if the user did not authorize in time.
+func (auth *Authenticator) timeoutError() error {
+	return fmt.Errorf("timed out after %s waiting for authorization", auth.Timeout.String())
+}
+
+// authenticatePasted authenticates a user without callback server: the browser is redirected to a page which does
+// (most likely) not exist and the user pastes its URL.
+func (auth *Authenticator) authenticatePasted(state string, verifier string) (string, error) {
+	redirectURI := "http://localhost/oauth/redirect"
+	auth.Browse(auth.authorizationURL(redirectURI, state, verifier))
+	fmt.Print("after authorizing, your browser is redirected to a page which most likely fails to load, paste its URL (from the address bar) here: ")
+
+	timeout, stop := auth.timeout()
+	defer stop()
+	line, errLine := auth.readLine(timeout)
+	if errLine != nil {
+		return "", errLine
+	}
+
+	code, token, errPasted := parsePasted(line, state, auth.Flow)
+	if errPasted != nil {
+		return "", errPasted
+	}
+	if token != "" {
+		return token, nil
+	}
+	return auth.exchange(code, redirectURI, verifier)
+}
+
+// readLine reads a line from Input unless the given timeout fires first. Reading cannot be interrupted, so a read which
+// timed out goes on in the background and its line is returned by the next call (rather than being swallowed).
+func (auth *Authenticator) readLine(timeout <-chan time.Time) (string, error) {
+	if auth.pending == nil {
+		if auth.input == nil {
+			auth.input = bufio.NewReader(auth.Input)
+		}
+		pending := make(chan pastedLine, 1)
+		go func(input *bufio.Reader) {
+			line, errLine := input.ReadString('\n')
+			pending <- pastedLine{line: line, err: errLine}
+		}(auth.input)
+		auth.pending = pending
+	}
+
+	select {
+	case read := <-auth.pending:
+		auth.pending = nil
+		if read.err != nil && (read.err != io.EOF || read.line == "") {
+			return "", fmt.Errorf("failed to read the redirect URL: %v", read.err)
+		}
+		return read.line, nil
+	case <-timeout:
+		return "", auth.timeoutError()
+	}
+}
+
+// parsePasted extracts the code (code flow) or token (implicit flow) from what the user pasted: either the URL the
+// browser has been redirected to (whose state has to match) or just the code or token.
+func parsePasted(pasted string, state string, flow string) (code string, token string, err error) {
+	pasted = strings.TrimSpace(pasted)
+	if pasted == "" {
+		return "", "", fmt.Errorf("nothing has been pasted")
+	}
+
+	// just the code or token
+	if !strings.Contains(pasted, "://") {
+		if flow == FlowImplicit {
+			return "", pasted, nil
+		}
+		return pasted, "", nil
+	}
+
+	// the redirect URL, with the parameters in the query (code flow) or the fragment (implicit flow)
+	parsed, errParse := url.Parse(pasted)
+	if errParse != nil {
+		return "", "", fmt.Errorf("failed to parse the pasted URL: %v", errParse)
+	}
+	params := parsed.Query()
+	if parsed.Fragment != "" {
+		fragment, errFragment := url.ParseQuery(parsed.Fragment)
+		if errFragment != nil {
+			return "", "", fmt.Errorf("failed to parse the fragment of the pasted URL: %v", errFragment)
+		}
+		for key, values := range fragment {
+			params[key] = values
+		}
+	}
+	if subtle.ConstantTimeCompare([]byte(params.Get("state")), []byte(state)) != 1 {
+		return "", "", fmt.Errorf("the pasted URL does not belong to this login (state mismatch)")
+	}
+	if errCode := params.Get("error"); errCode != "" {
+		return "", "", fmt.Errorf("authorization failed: %s %s", errCode, params.Get("error_description"))
+	}
+	if flow == FlowImplicit {
+		token = params.Get("access_token")
+	} else {
+		code = params.Get("code")
+	}
+	if code == "" && token == "" {
+		return "", "", fmt.Errorf("the pasted URL contains neither a code nor an access token")
+	}
+	return code, token, nil
+}
+
+// exchange exchanges the authorization code for an access token at the token endpoint.
+func (auth *Authenticator) exchange(code string, redirectURI string, verifier string) (string, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", redirectURI)
+	form.Set("client_id", auth.ClientID)
+	form.Set("code_verifier", verifier)
+	if auth.ClientSecret != "" {
+		form.Set("client_secret", auth.ClientSecret)
+	}
+
+	req, errReq := http.NewRequest(http.MethodPost, auth.TokenURL, strings.NewReader(form.Encode()))
+	if errReq != nil {
+		return "", fmt.Errorf("failed to construct token request: %v", errReq)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, errDo := auth.httpClient.Do(req)
+	if errDo != nil {
+		return "", fmt.Errorf("failed to do token request: %v", errDo)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	body, errRead := ioutil.ReadAll(resp.Body)
+	if errRead != nil {
+		return "", fmt.Errorf("failed to read token response: %v", errRead)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token request response status %d: %s", resp.StatusCode, body)
+	}
+
+	// the access token is either a string (standard) or an object containing the token (Yammer)
+	var response struct {
+		AccessToken json.RawMessage `json:"access_token"`
+	}
+	errJson := json.Unmarshal(body, &response)
+	if errJson != nil {
+		return "", fmt.Errorf("failed to parse token response: %v", errJson)
+	}
+	var token string
+	if errString := json.Unmarshal(response.AccessToken, &token); errString != nil {
+		var yammerToken struct {
+			Token string `json:"token"`
+		}
+		if errObject := json.Unmarshal(response.AccessToken, &yammerToken); errObject != nil {
+			return "", fmt.Errorf("failed to parse access token: %v", errObject)
+		}
+		token = yammerToken.Token
+	}
+	if token == "" {
+		return "", fmt.Errorf("token response without access token")
 	}
 
-	return token
+	return token, nil
 }
@@ -0,0 +1,276 @@
+package internal
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newFakeAuthServer returns a fake authorization server which immediately authorizes (redirecting with the code
+// "the-code") and exchanges the code for the token "the-token" if the PKCE verifier matches.
+func newFakeAuthServer(t *testing.T) *httptest.Server {
+	var challenge string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/authorize", func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if query.Get("response_type") != "code" || query.Get("code_challenge_method") != "S256" || query.Get("client_id") != "client" {
+			t.Errorf("unexpected authorization request %s", r.URL)
+		}
+		challenge = query.Get("code_challenge")
+		redirect, _ := url.Parse(query.Get("redirect_uri"))
+		redirect.RawQuery = url.Values{"code": {"the-code"}, "state": {query.Get("state")}}.Encode()
+		http.Redirect(w, r, redirect.String(), http.StatusFound)
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.FormValue("grant_type") != "authorization_code" || r.FormValue("code") != "the-code" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if pkceChallenge(r.FormValue("code_verifier")) != challenge {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = fmt.Fprint(w, `{"access_token": {"token": "the-token"}}`)
+	})
+	return httptest.NewServer(mux)
+}
+
+func TestAuthenticator_Authenticate_code(t *testing.T) {
+	server := newFakeAuthServer(t)
+	defer server.Close()
+
+	auth := NewAuthenticator("client")
+	auth.AuthURL = server.URL + "/authorize"
+	auth.TokenURL = server.URL + "/token"
+	auth.Browse = func(authUrl string) {
+		go func() {
+			resp, err := http.Get(authUrl)
+			if err != nil {
+				t.Errorf("browsing failed: %v", err)
+				return
+			}
+			_ = resp.Body.Close()
+		}()
+	}
+
+	token, err := auth.Authenticate()
+	if err != nil || token != "the-token" {
+		t.Errorf("Authenticate() = %s, %v, want the-token", token, err)
+	}
+}
+
+func TestAuthenticator_Authenticate_state(t *testing.T) {
+	server := newFakeAuthServer(t)
+	defer server.Close()
+
+	auth := NewAuthenticator("client")
+	auth.AuthURL = server.URL + "/authorize"
+	auth.TokenURL = server.URL + "/token"
+	auth.Browse = func(authUrl string) {
+		go func() {
+
+			// redirect with a forged state first (which must be rejected without aborting the login)
+			parsed, _ := url.Parse(authUrl)
+			redirect := parsed.Query().Get("redirect_uri") + "?code=the-code&state=forged"
+			resp, err := http.Get(redirect)
+			if err != nil {
+				t.Errorf("browsing failed: %v", err)
+				return
+			}
+			_ = resp.Body.Close()
+			if resp.StatusCode != http.StatusBadRequest {
+				t.Errorf("forged callback got status %d, want %d", resp.StatusCode, http.StatusBadRequest)
+			}
+
+			// then authorize for real
+			resp, err = http.Get(authUrl)
+			if err != nil {
+				t.Errorf("browsing failed: %v", err)
+				return
+			}
+			_ = resp.Body.Close()
+		}()
+	}
+
+	token, err := auth.Authenticate()
+	if err != nil || token != "the-token" {
+		t.Errorf("Authenticate() = %s, %v, want the-token", token, err)
+	}
+}
+
+func TestAuthenticator_exchange_timeout(t *testing.T) {
+	hung := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-hung
+	}))
+	defer server.Close()
+	defer close(hung)
+
+	auth := NewAuthenticator("client")
+	auth.TokenURL = server.URL + "/token"
+	auth.httpClient.Timeout = 100 * time.Millisecond
+	if token, err := auth.exchange("the-code", "http://localhost/oauth/redirect", "verifier"); err == nil {
+		t.Errorf("exchange() with a hung token endpoint = %s, want error", token)
+	}
+}
+
+func TestAuthenticator_Authenticate_timeout(t *testing.T) {
+	auth := NewAuthenticator("client")
+	auth.Timeout = 100 * time.Millisecond
+	auth.Browse = func(authUrl string) {
+		go func() {
+
+			// only send a forged callback
+			parsed, _ := url.Parse(authUrl)
+			redirect := parsed.Query().Get("redirect_uri") + "?code=the-code&state=forged"
+			resp, err := http.Get(redirect)
+			if err != nil {
+				t.Errorf("browsing failed: %v", err)
+				return
+			}
+			_ = resp.Body.Close()
+		}()
+	}
+
+	if token, err := auth.Authenticate(); err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("Authenticate() = %s, %v, want timeout error", token, err)
+	}
+}
+
+func TestAuthenticator_Authenticate_implicit(t *testing.T) {
+	auth := NewAuthenticator("client")
+	auth.Flow = FlowImplicit
+	auth.Browse = func(authUrl string) {
+		go func() {
+
+			// do what the controller HTML does in the browser
+			parsed, _ := url.Parse(authUrl)
+			if parsed.Query().Get("response_type") != "token" {
+				t.Errorf("unexpected authorization URL %s", authUrl)
+			}
+			redirect, _ := url.Parse(parsed.Query().Get("redirect_uri"))
+			if redirect.Hostname() != "localhost" {
+				t.Errorf("unexpected redirect URI %s", redirect)
+			}
+			state := url.QueryEscape(parsed.Query().Get("state"))
+			tests := []struct {
+				path   string
+				status int
+			}{
+				{"/oauth/redirect", http.StatusOK},
+				{"/token?token=forged&state=forged", http.StatusBadRequest},
+				{"/token?token=the-token&state=" + state, http.StatusOK},
+				{"/token?token=again&state=" + state, http.StatusConflict},
+				{"/done", http.StatusOK},
+			}
+			for _, test := range tests {
+				resp, err := http.Get(fmt.Sprintf("http://127.0.0.1:%s%s", redirect.Port(), test.path))
+				if err != nil {
+					t.Errorf("browsing failed: %v", err)
+					return
+				}
+				_, _ = ioutil.ReadAll(resp.Body)
+				_ = resp.Body.Close()
+				if resp.StatusCode != test.status {
+					t.Errorf("GET %s got status %d, want %d", test.path, resp.StatusCode, test.status)
+				}
+			}
+		}()
+	}
+
+	token, err := auth.Authenticate()
+	if err != nil || token != "the-token" {
+		t.Errorf("Authenticate() = %s, %v, want the-token", token, err)
+	}
+}
+
+func TestParsePasted(t *testing.T) {
+	tests := []struct {
+		pasted string
+		flow   string
+		code   string
+		token  string
+		err    bool
+	}{
+		{"http://localhost/oauth/redirect?code=the-code&state=the-state\n", FlowCode, "the-code", "", false},
+		{"http://localhost/oauth/redirect#access_token=the-token&state=the-state", FlowImplicit, "", "the-token", false},
+		{"  the-code  ", FlowCode, "the-code", "", false},
+		{"the-token", FlowImplicit, "", "the-token", false},
+		{"http://localhost/oauth/redirect?code=the-code&state=forged", FlowCode, "", "", true},
+		{"http://localhost/oauth/redirect?code=the-code", FlowCode, "", "", true},
+		{"http://localhost/oauth/redirect?error=access_denied&state=the-state", FlowCode, "", "", true},
+		{"http://localhost/oauth/redirect?state=the-state", FlowCode, "", "", true},
+		{"", FlowCode, "", "", true},
+	}
+	for _, test := range tests {
+		code, token, err := parsePasted(test.pasted, "the-state", test.flow)
+		if code != test.code || token != test.token || (err != nil) != test.err {
+			t.Errorf("parsePasted(%q) = %s, %s, %v, want %s, %s, error %t", test.pasted, code, token, err, test.code, test.token, test.err)
+		}
+	}
+}
+
+func TestAuthenticator_Authenticate_pasted(t *testing.T) {
+	server := newFakeAuthServer(t)
+	defer server.Close()
+
+	input, paste := io.Pipe()
+	auth := NewAuthenticator("client")
+	auth.AuthURL = server.URL + "/authorize"
+	auth.TokenURL = server.URL + "/token"
+	auth.NoCallback = true
+	auth.Input = input
+	auth.Browse = func(authUrl string) {
+		go func() {
+
+			// authorize without following the redirect, then paste the URL redirected to
+			client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			}}
+			resp, err := client.Get(authUrl)
+			if err != nil {
+				t.Errorf("browsing failed: %v", err)
+				return
+			}
+			_ = resp.Body.Close()
+			_, _ = fmt.Fprintln(paste, resp.Header.Get("Location"))
+		}()
+	}
+
+	token, err := auth.Authenticate()
+	if err != nil || token != "the-token" {
+		t.Errorf("Authenticate() = %s, %v, want the-token", token, err)
+	}
+}
+
+func TestAuthenticator_readLine(t *testing.T) {
+	input, paste := io.Pipe()
+	auth := NewAuthenticator("client")
+	auth.Input = input
+
+	// a read which timed out is continued by the next one
+	timeout := make(chan time.Time, 1)
+	timeout <- time.Now()
+	if line, err := auth.readLine(timeout); err == nil {
+		t.Errorf("readLine() after timeout = %s, want error", line)
+	}
+	go func() {
+		_, _ = fmt.Fprint(paste, "first\nsecond\n")
+	}()
+	for _, want := range []string{"first\n", "second\n"} {
+		if line, err := auth.readLine(nil); err != nil || line != want {
+			t.Errorf("readLine() = %q, %v, want %q", line, err, want)
+		}
+	}
+
+	_ = paste.Close()
+	if line, err := auth.readLine(nil); err == nil {
+		t.Errorf("readLine() at end of input = %q, want error", line)
+	}
+}
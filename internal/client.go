@@ -2,14 +2,16 @@ package internal
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"github.com/rs/zerolog/log"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"sync"
+	"time"
 )
 
 const YammerApiURL = "https://www.yammer.com/api/v1/"
@@ -64,6 +66,8 @@ type YammerUserResponse struct {
 	BirthDateComplete string `json:"birth_date_complete"`
 	Timezone          string `json:"timezone"`
 	Email             string `json:"email"`
+	NetworkID         int64  `json:"network_id"`
+	NetworkName       string `json:"network_name"`
 }
 
 type YammerGroup struct {
@@ -75,24 +79,100 @@ type YammerGroup struct {
 
 type YammerGroupResponse []YammerGroup
 
+// DefaultMaxRetries is the default number of times a request is retried if the server is busy.
+const DefaultMaxRetries = 5
+
+// DefaultTimeout is the default time a single request (including reading the response) may take.
+const DefaultTimeout = 30 * time.Second
+
+// StatusError is returned if a request is answered with an unexpected response status.
+type StatusError struct {
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("response status %d", e.StatusCode)
+}
+
+// AuthError is returned if a request is rejected because the token is invalid (e.g. revoked or expired). Once a
+// request has been rejected, all further requests fail with the same error (without being sent) until the token is
+// replaced.
+type AuthError struct {
+	StatusCode int
+}
+
+func (e *AuthError) Error() string {
+	return fmt.Sprintf("response status %d, the token is invalid or expired", e.StatusCode)
+}
+
 type Client struct {
 	httpClient *http.Client
-	Token      string
-	BaseURL    *url.URL
-	UserAgent  string
+	limiter    *tokenBucket
+
+	// guards the token, the authentication error, the limiter and the timeout
+	mutex   sync.Mutex
+	Token   string
+	authErr *AuthError
+	timeout time.Duration
+
+	BaseURL   *url.URL
+	UserAgent string
+
+	// MaxRetries is the number of times a request is retried after a 429 or 5xx response.
+	MaxRetries int
 }
 
 func NewClient(token string) *Client {
 	baseUrl, _ := url.Parse(YammerApiURL)
 	return &Client{
-		httpClient: http.DefaultClient,
+		httpClient: &http.Client{},
+		limiter:    newTokenBucket(DefaultRateRequests, DefaultRatePeriod),
 		Token:      token,
+		timeout:    DefaultTimeout,
 		BaseURL:    baseUrl,
 		UserAgent:  "goyammer",
+		MaxRetries: DefaultMaxRetries,
 	}
 }
 
-func (c *Client) newRequest(method, path string, query map[string]string, body interface{}) (*http.Request, error) {
+// SetToken replaces the token (e.g. after logging in again) and thereby clears the authentication error.
+func (c *Client) SetToken(token string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.Token = token
+	c.authErr = nil
+}
+
+// token returns the current token.
+func (c *Client) token() string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.Token
+}
+
+// AuthFailed returns whether a request has been rejected because the token is invalid.
+func (c *Client) AuthFailed() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.authErr != nil
+}
+
+// SetRateLimit limits the requests of all users of the client to the given number per period (still waiting for any
+// pause requested by the server).
+func (c *Client) SetRateLimit(requests int, period time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.limiter.setRate(requests, period)
+}
+
+// SetTimeout limits the time a single request (i.e. each attempt, including reading the response) may take.
+func (c *Client) SetTimeout(timeout time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.timeout = timeout
+}
+
+func (c *Client) newRequest(ctx context.Context, method, path string, query map[string]string, body interface{}) (*http.Request, error) {
 	rel := &url.URL{Path: path}
 	u := c.BaseURL.ResolveReference(rel)
 
@@ -108,6 +188,7 @@ func (c *Client) newRequest(method, path string, query map[string]string, body i
 	if err != nil {
 		return nil, err
 	}
+	req = req.WithContext(ctx)
 	if body != nil {
 		req.Header.Set("Content-Type", "application/json")
 	}
@@ -124,21 +205,113 @@ func (c *Client) newRequest(method, path string, query map[string]string, body i
 
 	req.Header.Set("Accept", "application/json")
 	req.Header.Set("User-Agent", c.UserAgent)
+	c.mutex.Lock()
 	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.Token))
+	c.mutex.Unlock()
 
 	log.Debug().Msg(fmt.Sprintf("url: %s", req.URL.String()))
 
 	return req, nil
 }
 
+// send does the request within the request budget and retries it (with backoff) as long as the server responds with
+// 429 (too many requests) or, for idempotent requests, 5xx (which may arrive after the server did the request, so
+// that retrying e.g. a post would duplicate it). A 401 response results in an AuthError, any other response than 2xx
+// in a StatusError. Each attempt is subject to the timeout of the client, all of them to the context of the request.
+func (c *Client) send(req *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+
+		// don't bother the server with a token known to be invalid
+		c.mutex.Lock()
+		authErr := c.authErr
+		limiter := c.limiter
+		timeout := c.timeout
+		c.mutex.Unlock()
+		if authErr != nil {
+			return nil, authErr
+		}
+
+		// wait for our turn
+		errWait := limiter.wait(req.Context())
+		if errWait != nil {
+			return nil, errWait
+		}
+
+		ctx, cancel := context.WithTimeout(req.Context(), timeout)
+		resp, err := c.httpClient.Do(req.WithContext(ctx))
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+			resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+			return resp, nil
+		}
+		_ = resp.Body.Close()
+		cancel()
+
+		// remember a rejected token (unless it has been replaced in the meantime)
+		if resp.StatusCode == http.StatusUnauthorized {
+			errAuth := &AuthError{StatusCode: resp.StatusCode}
+			c.mutex.Lock()
+			if req.Header.Get("Authorization") == fmt.Sprintf("Bearer %s", c.Token) {
+				c.authErr = errAuth
+			}
+			c.mutex.Unlock()
+			return nil, errAuth
+		}
+
+		// give up unless the server is busy and we have retries left
+		retryable := resp.StatusCode == http.StatusTooManyRequests || (resp.StatusCode >= 500 && idempotent(req.Method))
+		if !retryable || attempt >= c.MaxRetries {
+			return nil, &StatusError{StatusCode: resp.StatusCode}
+		}
+
+		// slow down all requests (honoring the delay requested by the server)
+		delay := retryAfter(resp)
+		if delay <= 0 {
+			delay = limiter.backoff(attempt)
+		}
+		log.Warn().Msg(fmt.Sprintf("response status %d for %s, retrying in %s", resp.StatusCode, req.URL.Path, delay.String()))
+		limiter.pause(delay)
+
+		// rewind the request body
+		if req.GetBody != nil {
+			body, errBody := req.GetBody()
+			if errBody != nil {
+				return nil, errBody
+			}
+			req.Body = body
+		}
+	}
+}
+
+// idempotent returns whether doing a request with the given method twice has the same effect as doing it once.
+func idempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodDelete:
+		return true
+	default:
+		return false
+	}
+}
+
+// cancelBody is a response body which cancels the context of its request when closed.
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (body *cancelBody) Close() error {
+	defer body.cancel()
+	return body.ReadCloser.Close()
+}
+
 func (c *Client) do(req *http.Request, v interface{}) (*http.Response, error) {
-	resp, err := c.httpClient.Do(req)
+	resp, err := c.send(req)
 	if err != nil {
 		return nil, err
 	}
-	if resp.StatusCode != 200 {
-		return nil, errors.New(fmt.Sprintf("response status %d", resp.StatusCode))
-	}
 	defer func() {
 		_ = resp.Body.Close()
 	}()
@@ -146,19 +319,40 @@ func (c *Client) do(req *http.Request, v interface{}) (*http.Response, error) {
 	return resp, err
 }
 
-func (c *Client) GetImage(url string) ([]byte, error) {
+// call does the request ignoring the response body.
+func (c *Client) call(req *http.Request) error {
+	resp, err := c.send(req)
+	if err != nil {
+		return err
+	}
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	return resp.Body.Close()
+}
+
+// GetCurrentUser returns the user the token belongs to.
+func (c *Client) GetCurrentUser(ctx context.Context) (*YammerUserResponse, error) {
+	req, errReq := c.newRequest(ctx, "GET", "users/current.json", nil, nil)
+	if errReq != nil {
+		return nil, fmt.Errorf("failed to construct current user request: %v", errReq)
+	}
+	var yur YammerUserResponse
+	_, errDo := c.do(req, &yur)
+	if errDo != nil {
+		return nil, errDo
+	}
+	return &yur, nil
+}
+
+func (c *Client) GetImage(ctx context.Context, url string) ([]byte, error) {
 
 	req, errReq := http.NewRequest(http.MethodGet, url, nil)
 	if errReq != nil {
 		return nil, fmt.Errorf("failed to construct mug shot request: %v", errReq)
 	}
-	resp, errDo := c.httpClient.Do(req)
+	resp, errDo := c.send(req.WithContext(ctx))
 	if errDo != nil {
 		return nil, fmt.Errorf("failed to do mug shot request: %v", errDo)
 	}
-	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("mug shot request response status %d", resp.StatusCode)
-	}
 	defer func() {
 		_ = resp.Body.Close()
 	}()
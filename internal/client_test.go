@@ -0,0 +1,220 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// newTestClient returns a client talking to the given test server.
+func newTestClient(server *httptest.Server) *Client {
+	client := NewClient("secret")
+	client.BaseURL, _ = url.Parse(server.URL + "/")
+	client.SetRateLimit(100, time.Second)
+	return client
+}
+
+func TestClient_retryAfter(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		_, _ = fmt.Fprint(w, `{"id": 42}`)
+	}))
+	defer server.Close()
+
+	client := newTestClient(server)
+	req, _ := client.newRequest(context.Background(), "GET", "users/current.json", nil, nil)
+
+	start := time.Now()
+	var yur YammerUserResponse
+	_, err := client.do(req, &yur)
+	if err != nil {
+		t.Fatalf("do() error = %v", err)
+	}
+	if yur.ID != 42 || calls != 2 {
+		t.Errorf("do() got id %d after %d calls, want 42 after 2 calls", yur.ID, calls)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("do() retried after %s, want at least 1s", elapsed)
+	}
+}
+
+func TestClient_retry(t *testing.T) {
+	tests := []struct {
+		method    string
+		status    int
+		wantCalls int
+		wantErr   bool
+	}{
+		{method: "GET", status: http.StatusServiceUnavailable, wantCalls: 2},
+		{method: "DELETE", status: http.StatusBadGateway, wantCalls: 2},
+		{method: "POST", status: http.StatusTooManyRequests, wantCalls: 2},
+		{method: "POST", status: http.StatusGatewayTimeout, wantCalls: 1, wantErr: true},
+		{method: "POST", status: http.StatusInternalServerError, wantCalls: 1, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s %d", tt.method, tt.status), func(t *testing.T) {
+			calls := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls++
+				if calls == 1 {
+					w.Header().Set("Retry-After", "1")
+					w.WriteHeader(tt.status)
+					return
+				}
+				w.WriteHeader(http.StatusOK)
+			}))
+			defer server.Close()
+
+			client := newTestClient(server)
+			req, _ := client.newRequest(context.Background(), tt.method, "messages.json", nil, nil)
+			err := client.call(req)
+			if (err != nil) != tt.wantErr || calls != tt.wantCalls {
+				t.Errorf("call() = %v after %d calls, want error %t after %d calls", err, calls, tt.wantErr, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestClient_statusError(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	client := newTestClient(server)
+	req, _ := client.newRequest(context.Background(), "GET", "users/current.json", nil, nil)
+
+	var yur YammerUserResponse
+	_, err := client.do(req, &yur)
+	statusErr, ok := err.(*StatusError)
+	if !ok || statusErr.StatusCode != http.StatusNotFound {
+		t.Fatalf("do() error = %v, want status error 404", err)
+	}
+	if calls != 1 {
+		t.Errorf("do() made %d calls, want 1", calls)
+	}
+}
+
+func TestClient_authError(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if r.Header.Get("Authorization") != "Bearer renewed" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = fmt.Fprint(w, `{"id": 42, "network_name": "Example"}`)
+	}))
+	defer server.Close()
+
+	client := newTestClient(server)
+
+	// the rejected token is not sent again
+	for i := 0; i < 3; i++ {
+		_, err := client.GetCurrentUser(context.Background())
+		if _, ok := err.(*AuthError); !ok {
+			t.Fatalf("GetCurrentUser() error = %v, want authentication error", err)
+		}
+	}
+	if calls != 1 || !client.AuthFailed() {
+		t.Errorf("made %d calls (auth failed %t), want 1 call (auth failed)", calls, client.AuthFailed())
+	}
+
+	// until it has been replaced
+	client.SetToken("renewed")
+	user, err := client.GetCurrentUser(context.Background())
+	if err != nil || user.ID != 42 || user.NetworkName != "Example" {
+		t.Errorf("GetCurrentUser() = %v, %v, want user 42", user, err)
+	}
+	if client.AuthFailed() {
+		t.Errorf("AuthFailed() = true after SetToken()")
+	}
+}
+
+func TestClient_timeout(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	client := newTestClient(server)
+	client.SetTimeout(100 * time.Millisecond)
+	start := time.Now()
+	if _, err := client.GetCurrentUser(context.Background()); err == nil {
+		t.Errorf("GetCurrentUser() of a hanging server succeeded, want timeout")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("GetCurrentUser() timed out after %s, want 100ms", elapsed)
+	}
+
+	// cancelling the context stops the request (and waiting for the request budget)
+	client.SetTimeout(time.Minute)
+	client.SetRateLimit(1, time.Hour)
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	for i := 0; i < 2; i++ {
+		if _, err := client.GetCurrentUser(ctx); err == nil {
+			t.Errorf("GetCurrentUser() with cancelled context succeeded, want error")
+		}
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("GetCurrentUser() cancelled after %s, want 100ms", elapsed)
+	}
+}
+
+func Test_tokenBucket(t *testing.T) {
+	bucket := newTokenBucket(2, time.Minute)
+	if bucket.take() != 0 || bucket.take() != 0 {
+		t.Fatal("take() on full bucket must not block")
+	}
+	if delay := bucket.take(); delay <= 0 || delay > 30*time.Second {
+		t.Errorf("take() on empty bucket = %s, want (0s, 30s]", delay)
+	}
+	for attempt := 0; attempt < 20; attempt++ {
+		if delay := bucket.backoff(attempt); delay < minBackoff/2 || delay > maxBackoff {
+			t.Errorf("backoff(%d) = %s out of bounds", attempt, delay)
+		}
+	}
+}
+
+func TestClient_SetRateLimit(t *testing.T) {
+	client := NewClient("token")
+	limiter := client.limiter
+	limiter.pause(time.Minute)
+
+	// changing the rate keeps the pause
+	client.SetRateLimit(100, time.Second)
+	if client.limiter != limiter {
+		t.Errorf("SetRateLimit() replaced the bucket")
+	}
+	if delay := limiter.take(); delay <= 30*time.Second {
+		t.Errorf("take() after SetRateLimit() = %s, want the pause of 1m", delay)
+	}
+
+	// the tokens left are capped at the new capacity
+	limiter.hold = time.Time{}
+	client.SetRateLimit(1, time.Minute)
+	if limiter.take() != 0 {
+		t.Fatal("take() on full bucket must not block")
+	}
+	if delay := limiter.take(); delay <= 30*time.Second {
+		t.Errorf("take() on empty bucket = %s, want about 1m", delay)
+	}
+}
@@ -0,0 +1,396 @@
+package internal
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+const configFile = "config.toml"
+
+// Group priorities (mapped to notification urgencies).
+const (
+	PriorityLow    = "low"
+	PriorityNormal = "normal"
+	PriorityHigh   = "high"
+)
+
+// NotifySettings configure the notification behaviour.
+type NotifySettings struct {
+
+	// Backend is the notifier to use (see NewNotifier).
+	Backend *string `toml:"backend"`
+
+	// Enabled turns desktop notifications on or off (messages are logged in any case).
+	Enabled *bool `toml:"enabled"`
+
+	// Each notifies about every new message instead of once per group and poll.
+	Each *bool `toml:"each"`
+}
+
+// GroupSettings configure a single group.
+type GroupSettings struct {
+
+	// Mute suppresses notifications for the group (messages are still logged).
+	Mute bool `toml:"mute"`
+
+	// Priority is one of "low", "normal" (the default) or "high".
+	Priority string `toml:"priority"`
+
+	// MinInterval and MaxInterval bound the poll interval of the group (in seconds).
+	MinInterval *uint `toml:"min_interval"`
+	MaxInterval *uint `toml:"max_interval"`
+}
+
+// Urgency returns the notification urgency matching the priority of the group.
+func (group GroupSettings) Urgency() Urgency {
+	switch group.Priority {
+	case PriorityLow:
+		return UrgencyLow
+	case PriorityHigh:
+		return UrgencyCritical
+	default:
+		return UrgencyNormal
+	}
+}
+
+// Settings are the settings of a profile. Unset values are nil (or empty).
+type Settings struct {
+	Interval    *uint   `toml:"interval"`
+	MinInterval *uint   `toml:"min_interval"`
+	MaxInterval *uint   `toml:"max_interval"`
+	Concurrency *uint   `toml:"concurrency"`
+	RateLimit   *uint   `toml:"rate_limit"`
+	Timeout     *uint   `toml:"timeout"`
+	Backlog     *uint   `toml:"backlog"`
+	MaxPages    *uint   `toml:"max_pages"`
+	Output      *string `toml:"output"`
+
+	// ClientID is the client ID to log in with (if not given on the command line and when logging in again while
+	// polling).
+	ClientID *string `toml:"client_id"`
+
+	// Accounts are the accounts to poll (see DefaultAccount).
+	Accounts []string `toml:"accounts"`
+
+	// TokenStore is the credential store holding the access token (see NewCredentialStore).
+	TokenStore *string `toml:"token_store"`
+
+	// RefreshGroups is the number of minutes between refreshes of the group membership (0 disables refreshing).
+	RefreshGroups *uint `toml:"refresh_groups"`
+
+	Notify NotifySettings `toml:"notify"`
+
+	// Include and Exclude select the groups to poll by ID, name or regular expression (see NewGroupFilter).
+	Include []string `toml:"include"`
+	Exclude []string `toml:"exclude"`
+
+	// Groups configures groups by ID or name.
+	Groups map[string]GroupSettings `toml:"groups"`
+}
+
+// Config is the data structure to represent the configuration file. The top-level settings apply to all profiles and
+// are overridden by the settings of the selected profile.
+type Config struct {
+	Settings
+	Profiles map[string]Settings `toml:"profiles"`
+
+	// keys in the file which do not match any setting
+	unknown []string
+}
+
+// ConfigDir returns the directory to read the configuration from (following the XDG base directory specification).
+func ConfigDir() string {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, _ := os.UserHomeDir()
+		dir = path.Join(home, ".config")
+	}
+	return path.Join(dir, "goyammer")
+}
+
+// ConfigPath returns the path of the default configuration file.
+func ConfigPath() string {
+	return path.Join(ConfigDir(), configFile)
+}
+
+// LoadConfig reads the configuration from the given file. A missing file results in an empty configuration.
+func LoadConfig(configPath string) (*Config, error) {
+	config := &Config{}
+	if !FileExists(configPath) {
+		return config, nil
+	}
+
+	meta, errDecode := toml.DecodeFile(configPath, config)
+	if errDecode != nil {
+		return nil, fmt.Errorf("failed to read configuration from %s: %v", configPath, errDecode)
+	}
+	for _, key := range meta.Undecoded() {
+		config.unknown = append(config.unknown, key.String())
+	}
+
+	return config, nil
+}
+
+// Unknown returns the keys in the file which do not match any setting.
+func (config *Config) Unknown() []string {
+	return config.unknown
+}
+
+// Profile returns the top-level settings overridden by the settings of the given profile ("" for none).
+func (config *Config) Profile(name string) (Settings, error) {
+	settings := config.Settings
+	if name == "" {
+		return settings, nil
+	}
+	profile, ok := config.Profiles[name]
+	if !ok {
+		return settings, fmt.Errorf("unknown profile '%s'", name)
+	}
+	return settings.merge(profile), nil
+}
+
+// merge returns the settings overridden by all values set in other.
+func (settings Settings) merge(other Settings) Settings {
+	merged := settings
+	if other.Interval != nil {
+		merged.Interval = other.Interval
+	}
+	if other.MinInterval != nil {
+		merged.MinInterval = other.MinInterval
+	}
+	if other.MaxInterval != nil {
+		merged.MaxInterval = other.MaxInterval
+	}
+	if other.Concurrency != nil {
+		merged.Concurrency = other.Concurrency
+	}
+	if other.RateLimit != nil {
+		merged.RateLimit = other.RateLimit
+	}
+	if other.Timeout != nil {
+		merged.Timeout = other.Timeout
+	}
+	if other.Backlog != nil {
+		merged.Backlog = other.Backlog
+	}
+	if other.MaxPages != nil {
+		merged.MaxPages = other.MaxPages
+	}
+	if other.Output != nil {
+		merged.Output = other.Output
+	}
+	if other.ClientID != nil {
+		merged.ClientID = other.ClientID
+	}
+	if other.Accounts != nil {
+		merged.Accounts = other.Accounts
+	}
+	if other.TokenStore != nil {
+		merged.TokenStore = other.TokenStore
+	}
+	if other.RefreshGroups != nil {
+		merged.RefreshGroups = other.RefreshGroups
+	}
+	if other.Notify.Backend != nil {
+		merged.Notify.Backend = other.Notify.Backend
+	}
+	if other.Notify.Enabled != nil {
+		merged.Notify.Enabled = other.Notify.Enabled
+	}
+	if other.Notify.Each != nil {
+		merged.Notify.Each = other.Notify.Each
+	}
+	if other.Include != nil {
+		merged.Include = other.Include
+	}
+	if other.Exclude != nil {
+		merged.Exclude = other.Exclude
+	}
+	if other.Groups != nil {
+		merged.Groups = make(map[string]GroupSettings)
+		for key, group := range settings.Groups {
+			merged.Groups[key] = group
+		}
+		for key, group := range other.Groups {
+			merged.Groups[key] = group
+		}
+	}
+	return merged
+}
+
+// Flags returns the values of all set settings which correspond to a 'poll' flag, by flag name.
+func (settings Settings) Flags() map[string]string {
+	flags := make(map[string]string)
+	uints := map[string]*uint{
+		"interval":       settings.Interval,
+		"min-interval":   settings.MinInterval,
+		"max-interval":   settings.MaxInterval,
+		"concurrency":    settings.Concurrency,
+		"rate-limit":     settings.RateLimit,
+		"timeout":        settings.Timeout,
+		"backlog":        settings.Backlog,
+		"max-pages":      settings.MaxPages,
+		"refresh-groups": settings.RefreshGroups,
+	}
+	for name, value := range uints {
+		if value != nil {
+			flags[name] = strconv.FormatUint(uint64(*value), 10)
+		}
+	}
+	if settings.Output != nil {
+		flags["output"] = *settings.Output
+	}
+	if settings.TokenStore != nil {
+		flags["token-store"] = *settings.TokenStore
+	}
+	if settings.Notify.Backend != nil {
+		flags["notifier"] = *settings.Notify.Backend
+	}
+	return flags
+}
+
+// ApplyFlags sets the 'poll' flags in the given flag set to the values of the settings, unless they were set on the
+// command line.
+func (settings Settings) ApplyFlags(flags *flag.FlagSet) error {
+	explicit := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+	for name, value := range settings.Flags() {
+		if explicit[name] {
+			continue
+		}
+		errSet := flags.Set(name, value)
+		if errSet != nil {
+			return fmt.Errorf("invalid configuration value for '%s': %v", name, errSet)
+		}
+	}
+	return nil
+}
+
+// GroupIntervals returns the poll interval bounds configured for groups by group ID or name, using the given
+// defaults where only one bound is set (and moving them to the configured bound if they would be inverted otherwise).
+func (settings Settings) GroupIntervals(defaults IntervalBounds) map[string]IntervalBounds {
+	intervals := make(map[string]IntervalBounds)
+	for key, group := range settings.Groups {
+		if group.MinInterval == nil && group.MaxInterval == nil {
+			continue
+		}
+		bounds := defaults
+		if group.MinInterval != nil {
+			bounds.Min = time.Duration(*group.MinInterval) * time.Second
+		}
+		if group.MaxInterval != nil {
+			bounds.Max = time.Duration(*group.MaxInterval) * time.Second
+		}
+		if bounds.Min > bounds.Max {
+			if group.MaxInterval == nil {
+				bounds.Max = bounds.Min
+			} else {
+				bounds.Min = bounds.Max
+			}
+		}
+		intervals[key] = bounds
+	}
+	return intervals
+}
+
+// Validate checks the top-level settings and all profiles for bad values.
+func (config *Config) Validate() []error {
+	errs := config.Settings.validate("")
+	names := make([]string, 0, len(config.Profiles))
+	for name := range config.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		errs = append(errs, config.Profiles[name].validate(fmt.Sprintf("profiles.%s.", name))...)
+	}
+	return errs
+}
+
+// validate checks the settings for bad values (prefixing keys in error messages with the given prefix).
+func (settings Settings) validate(prefix string) []error {
+	var errs []error
+
+	positive := map[string]*uint{
+		"interval":     settings.Interval,
+		"min_interval": settings.MinInterval,
+		"max_interval": settings.MaxInterval,
+		"concurrency":  settings.Concurrency,
+		"rate_limit":   settings.RateLimit,
+		"timeout":      settings.Timeout,
+		"max_pages":    settings.MaxPages,
+	}
+	keys := make([]string, 0, len(positive))
+	for key := range positive {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if value := positive[key]; value != nil && *value < 1 {
+			errs = append(errs, fmt.Errorf("%s%s: must be positive", prefix, key))
+		}
+	}
+	if settings.MinInterval != nil && settings.MaxInterval != nil && *settings.MinInterval > *settings.MaxInterval {
+		errs = append(errs, fmt.Errorf("%smin_interval: must not exceed max_interval", prefix))
+	}
+
+	if backend := settings.Notify.Backend; backend != nil {
+		switch *backend {
+		case NotifierLibnotify, NotifierDBus, NotifierJSON, NotifierNone:
+		default:
+			errs = append(errs, fmt.Errorf("%snotify.backend: '%s' is not one of libnotify, dbus, json or none", prefix, *backend))
+		}
+	}
+
+	if store := settings.TokenStore; store != nil {
+		switch *store {
+		case StoreAuto, StoreSecretService, StoreEncryptedFile, StoreFile:
+		default:
+			errs = append(errs, fmt.Errorf("%stoken_store: '%s' is not one of auto, secret-service, encrypted-file or file", prefix, *store))
+		}
+	}
+
+	for _, account := range settings.Accounts {
+		if errAccount := ValidateAccount(account); errAccount != nil {
+			errs = append(errs, fmt.Errorf("%saccounts: %v", prefix, errAccount))
+		}
+	}
+
+	if _, errFilter := NewGroupFilter(settings.Include, settings.Exclude); errFilter != nil {
+		errs = append(errs, fmt.Errorf("%sinclude/exclude: %v", prefix, errFilter))
+	}
+
+	groups := make([]string, 0, len(settings.Groups))
+	for key := range settings.Groups {
+		groups = append(groups, key)
+	}
+	sort.Strings(groups)
+	for _, key := range groups {
+		group := settings.Groups[key]
+		switch group.Priority {
+		case "", PriorityLow, PriorityNormal, PriorityHigh:
+		default:
+			errs = append(errs, fmt.Errorf("%sgroups.%s.priority: '%s' is not one of low, normal or high", prefix, key, group.Priority))
+		}
+		if group.MinInterval != nil && *group.MinInterval < 1 {
+			errs = append(errs, fmt.Errorf("%sgroups.%s.min_interval: must be positive", prefix, key))
+		}
+		if group.MaxInterval != nil && *group.MaxInterval < 1 {
+			errs = append(errs, fmt.Errorf("%sgroups.%s.max_interval: must be positive", prefix, key))
+		}
+		if group.MinInterval != nil && group.MaxInterval != nil && *group.MinInterval > *group.MaxInterval {
+			errs = append(errs, fmt.Errorf("%sgroups.%s.min_interval: must not exceed max_interval", prefix, key))
+		}
+	}
+
+	return errs
+}
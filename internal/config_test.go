@@ -0,0 +1,308 @@
+package internal
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+	"time"
+)
+
+const testConfig = `
+interval = 20
+concurrency = 2
+include = ["/^Team/"]
+
+[notify]
+backend = "json"
+each = true
+
+[groups."Team Alpha"]
+mute = true
+min_interval = 30
+
+[groups."Team Beta"]
+priority = "high"
+
+[profiles.work]
+interval = 60
+accounts = ["work"]
+
+[profiles.work.notify]
+backend = "dbus"
+
+[profiles.work.groups."Team Beta"]
+max_interval = 600
+
+[profiles.work.groups."Team Gamma"]
+priority = "low"
+`
+
+// loadTestConfig loads the given configuration from a temporary file.
+func loadTestConfig(t *testing.T, content string) (*Config, error) {
+	dir, errDir := ioutil.TempDir("", "goyammer-test")
+	if errDir != nil {
+		t.Fatal(errDir)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	configPath := path.Join(dir, configFile)
+	if err := ioutil.WriteFile(configPath, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return LoadConfig(configPath)
+}
+
+func uintPtr(value uint) *uint {
+	return &value
+}
+
+func TestLoadConfig(t *testing.T) {
+	if config, err := LoadConfig(path.Join(os.TempDir(), "goyammer-missing", configFile)); err != nil || !reflect.DeepEqual(config, &Config{}) {
+		t.Errorf("LoadConfig() of missing file = %v, %v, want empty configuration", config, err)
+	}
+
+	if _, err := loadTestConfig(t, "interval = "); err == nil {
+		t.Errorf("LoadConfig() of corrupt file succeeded, want error")
+	}
+	if _, err := loadTestConfig(t, `interval = "ten"`); err == nil {
+		t.Errorf("LoadConfig() of mistyped value succeeded, want error")
+	}
+
+	config, errLoad := loadTestConfig(t, "intervall = 10\n"+testConfig+`
+[profiles.home]
+notify = { bakend = "json" }
+`)
+	if errLoad != nil {
+		t.Fatalf("LoadConfig() error = %v", errLoad)
+	}
+	if want := []string{"intervall", "profiles.home.notify.bakend"}; !reflect.DeepEqual(config.Unknown(), want) {
+		t.Errorf("Unknown() = %v, want %v", config.Unknown(), want)
+	}
+}
+
+func TestConfig_Profile(t *testing.T) {
+	config, errLoad := loadTestConfig(t, testConfig)
+	if errLoad != nil {
+		t.Fatalf("LoadConfig() error = %v", errLoad)
+	}
+
+	tests := []struct {
+		profile      string
+		wantInterval uint
+		wantBackend  string
+		wantAccounts []string
+		wantGroups   map[string]GroupSettings
+		wantErr      bool
+	}{
+		{
+			profile:      "",
+			wantInterval: 20,
+			wantBackend:  NotifierJSON,
+			wantGroups: map[string]GroupSettings{
+				"Team Alpha": {Mute: true, MinInterval: uintPtr(30)},
+				"Team Beta":  {Priority: PriorityHigh},
+			},
+		},
+		{
+			profile:      "work",
+			wantInterval: 60,
+			wantBackend:  NotifierDBus,
+			wantAccounts: []string{"work"},
+			wantGroups: map[string]GroupSettings{
+				"Team Alpha": {Mute: true, MinInterval: uintPtr(30)},
+				"Team Beta":  {MaxInterval: uintPtr(600)},
+				"Team Gamma": {Priority: PriorityLow},
+			},
+		},
+		{profile: "home", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.profile, func(t *testing.T) {
+			settings, err := config.Profile(tt.profile)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Profile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if *settings.Interval != tt.wantInterval {
+				t.Errorf("Profile() interval = %d, want %d", *settings.Interval, tt.wantInterval)
+			}
+			if *settings.Notify.Backend != tt.wantBackend {
+				t.Errorf("Profile() notify.backend = %s, want %s", *settings.Notify.Backend, tt.wantBackend)
+			}
+			if !reflect.DeepEqual(settings.Accounts, tt.wantAccounts) {
+				t.Errorf("Profile() accounts = %v, want %v", settings.Accounts, tt.wantAccounts)
+			}
+			if !reflect.DeepEqual(settings.Groups, tt.wantGroups) {
+				t.Errorf("Profile() groups = %v, want %v", settings.Groups, tt.wantGroups)
+			}
+
+			// settings not overridden by the profile are kept
+			if *settings.Concurrency != 2 || !*settings.Notify.Each || !reflect.DeepEqual(settings.Include, []string{"/^Team/"}) {
+				t.Errorf("Profile() lost top-level settings: %+v", settings)
+			}
+		})
+	}
+
+	// the top-level groups are not changed by merging
+	if len(config.Groups) != 2 {
+		t.Errorf("Profile() changed the top-level groups to %v", config.Groups)
+	}
+}
+
+func TestSettings_ApplyFlags(t *testing.T) {
+	config, errLoad := loadTestConfig(t, testConfig)
+	if errLoad != nil {
+		t.Fatalf("LoadConfig() error = %v", errLoad)
+	}
+
+	tests := []struct {
+		name            string
+		args            []string
+		wantInterval    uint
+		wantConcurrency uint
+		wantNotifier    string
+	}{
+		{name: "file", wantInterval: 20, wantConcurrency: 2, wantNotifier: NotifierJSON},
+		{name: "flags", args: []string{"--interval", "5", "--notifier", NotifierNone}, wantInterval: 5, wantConcurrency: 2, wantNotifier: NotifierNone},
+		{name: "flag set to default", args: []string{"--concurrency", "4"}, wantInterval: 20, wantConcurrency: 4, wantNotifier: NotifierJSON},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			flags := flag.NewFlagSet("poll", flag.ContinueOnError)
+			interval := flags.Uint("interval", 10, "")
+			concurrency := flags.Uint("concurrency", 4, "")
+			notifier := flags.String("notifier", NotifierDBus, "")
+			if err := flags.Parse(tt.args); err != nil {
+				t.Fatal(err)
+			}
+			if err := config.Settings.ApplyFlags(flags); err != nil {
+				t.Fatalf("ApplyFlags() error = %v", err)
+			}
+			if *interval != tt.wantInterval || *concurrency != tt.wantConcurrency || *notifier != tt.wantNotifier {
+				t.Errorf("ApplyFlags() = %d, %d, %s, want %d, %d, %s", *interval, *concurrency, *notifier, tt.wantInterval, tt.wantConcurrency, tt.wantNotifier)
+			}
+		})
+	}
+
+	// values not matching the flag type are rejected
+	flags := flag.NewFlagSet("poll", flag.ContinueOnError)
+	flags.Bool("interval", false, "")
+	if err := config.Settings.ApplyFlags(flags); err == nil {
+		t.Errorf("ApplyFlags() with mistyped flag succeeded, want error")
+	}
+}
+
+func TestConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []string
+	}{
+		{name: "valid", content: testConfig},
+		{name: "empty"},
+		{
+			name:    "not positive",
+			content: "interval = 0\nmax_pages = 0\n[profiles.work]\nrate_limit = 0\n",
+			want:    []string{"interval: must be positive", "max_pages: must be positive", "profiles.work.rate_limit: must be positive"},
+		},
+		{
+			name:    "intervals",
+			content: "min_interval = 60\nmax_interval = 30\n[groups.x]\nmin_interval = 0\n[groups.y]\nmin_interval = 20\nmax_interval = 10\n[groups.z]\nmax_interval = 0\n",
+			want: []string{
+				"min_interval: must not exceed max_interval",
+				"groups.x.min_interval: must be positive",
+				"groups.y.min_interval: must not exceed max_interval",
+				"groups.z.max_interval: must be positive",
+			},
+		},
+		{
+			name:    "choices",
+			content: "token_store = \"disk\"\n[notify]\nbackend = \"growl\"\n[groups.x]\npriority = \"urgent\"\n",
+			want: []string{
+				"notify.backend: 'growl' is not one of libnotify, dbus, json or none",
+				"token_store: 'disk' is not one of auto, secret-service, encrypted-file or file",
+				"groups.x.priority: 'urgent' is not one of low, normal or high",
+			},
+		},
+		{
+			name:    "accounts and filter",
+			content: "[profiles.b]\naccounts = [\"a/b\"]\n[profiles.a]\ninclude = [\"/(/\"]\n",
+			want:    []string{"profiles.a.include/exclude:", "profiles.b.accounts:"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, errLoad := loadTestConfig(t, tt.content)
+			if errLoad != nil {
+				t.Fatalf("LoadConfig() error = %v", errLoad)
+			}
+			errs := config.Validate()
+			if len(errs) != len(tt.want) {
+				t.Fatalf("Validate() = %v, want %v", errs, tt.want)
+			}
+			for i, err := range errs {
+				if got := err.Error(); len(got) < len(tt.want[i]) || got[:len(tt.want[i])] != tt.want[i] {
+					t.Errorf("Validate()[%d] = %s, want %s", i, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestSettings_GroupIntervals(t *testing.T) {
+	config, errLoad := loadTestConfig(t, testConfig)
+	if errLoad != nil {
+		t.Fatalf("LoadConfig() error = %v", errLoad)
+	}
+	settings, errProfile := config.Profile("work")
+	if errProfile != nil {
+		t.Fatalf("Profile() error = %v", errProfile)
+	}
+
+	defaults := IntervalBounds{Min: 10 * time.Second, Max: 300 * time.Second}
+	got := settings.GroupIntervals(defaults)
+	want := map[string]IntervalBounds{
+		"Team Alpha": {Min: 30 * time.Second, Max: 300 * time.Second},
+		"Team Beta":  {Min: 10 * time.Second, Max: 600 * time.Second},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("GroupIntervals() = %v, want %v", got, want)
+	}
+	if got := (Settings{}).GroupIntervals(defaults); len(got) != 0 {
+		t.Errorf("GroupIntervals() without groups = %v, want none", got)
+	}
+
+	// a single bound beyond the other default one moves it
+	tests := []struct {
+		name  string
+		group GroupSettings
+		want  IntervalBounds
+	}{
+		{name: "min above default max", group: GroupSettings{MinInterval: uintPtr(600)}, want: IntervalBounds{Min: 600 * time.Second, Max: 600 * time.Second}},
+		{name: "max below default min", group: GroupSettings{MaxInterval: uintPtr(5)}, want: IntervalBounds{Min: 5 * time.Second, Max: 5 * time.Second}},
+		{name: "within defaults", group: GroupSettings{MinInterval: uintPtr(20)}, want: IntervalBounds{Min: 20 * time.Second, Max: 300 * time.Second}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			settings := Settings{Groups: map[string]GroupSettings{"x": tt.group}}
+			if got := settings.GroupIntervals(defaults)["x"]; got != tt.want {
+				t.Errorf("GroupIntervals() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSettings_Flags(t *testing.T) {
+	output := "/tmp/goyammer.log"
+	settings := Settings{Interval: uintPtr(20), RefreshGroups: uintPtr(0), Output: &output}
+	want := map[string]string{"interval": "20", "refresh-groups": "0", "output": output}
+	if got := settings.Flags(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Flags() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,223 @@
+package internal
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// how long a client waits for the poller to answer
+const controlTimeout = 30 * time.Second
+
+// JSON-RPC error codes
+const (
+	controlParseError     = -32700
+	controlMethodNotFound = -32601
+	controlInvalidParams  = -32602
+	controlServerError    = -32000
+)
+
+// ControlPath returns the path of the control socket of the poller of the given account.
+func ControlPath(account string) string {
+	return path.Join(RuntimeDir(), account+".sock")
+}
+
+// controlRequest is a command sent to the control socket (JSON-RPC 2.0 style, one per line).
+type controlRequest struct {
+	Version string          `json:"jsonrpc"`
+	ID      int64           `json:"id"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+// controlResponse is the answer to a command (one per line).
+type controlResponse struct {
+	Version string          `json:"jsonrpc"`
+	ID      int64           `json:"id"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *ControlError   `json:"error,omitempty"`
+}
+
+// ControlError is the error returned by a command.
+type ControlError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *ControlError) Error() string {
+	return e.Message
+}
+
+// ControlGroup is a polled group as listed by the "groups" command.
+type ControlGroup struct {
+	ID       int64  `json:"id"`
+	Name     string `json:"name"`
+	Latest   int64  `json:"latest"`
+	Interval int64  `json:"interval"`
+	Muted    bool   `json:"muted"`
+}
+
+// ControlMessage is a message as listed by the "messages" command.
+type ControlMessage struct {
+	ID        int64  `json:"id"`
+	Group     string `json:"group"`
+	Sender    string `json:"sender"`
+	Body      string `json:"body"`
+	WebUrl    string `json:"web_url"`
+	CreatedAt string `json:"created_at"`
+}
+
+// ControlHandler executes a command given its (possibly empty) parameters and returns its result.
+type ControlHandler func(params json.RawMessage) (interface{}, error)
+
+// DecodeParams parses the parameters of a command into v (reporting invalid parameters to the client).
+func DecodeParams(params json.RawMessage, v interface{}) error {
+	if len(params) == 0 {
+		return nil
+	}
+	errJson := json.Unmarshal(params, v)
+	if errJson != nil {
+		return &ControlError{Code: controlInvalidParams, Message: fmt.Sprintf("invalid parameters: %v", errJson)}
+	}
+	return nil
+}
+
+// ControlServer serves commands on a Unix domain socket.
+type ControlServer struct {
+	listener net.Listener
+	handlers map[string]ControlHandler
+}
+
+// ListenControl serves the given commands (by method name) on the socket at the given path. An existing socket is
+// replaced, so the caller must make sure that no other poller uses it (e.g. by holding the lock of the account).
+func ListenControl(socketPath string, handlers map[string]ControlHandler) (*ControlServer, error) {
+	errRemove := os.Remove(socketPath)
+	if errRemove != nil && !os.IsNotExist(errRemove) {
+		return nil, fmt.Errorf("failed to remove stale socket %s: %v", socketPath, errRemove)
+	}
+	listener, errListen := net.Listen("unix", socketPath)
+	if errListen != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %v", socketPath, errListen)
+	}
+	errChmod := os.Chmod(socketPath, 0600)
+	if errChmod != nil {
+		_ = listener.Close()
+		return nil, fmt.Errorf("failed to restrict access to %s: %v", socketPath, errChmod)
+	}
+	server := &ControlServer{listener: listener, handlers: handlers}
+	go server.serve()
+	return server, nil
+}
+
+// Close stops serving and removes the socket.
+func (server *ControlServer) Close() error {
+	return server.listener.Close()
+}
+
+func (server *ControlServer) serve() {
+	for {
+		conn, errAccept := server.listener.Accept()
+		if errAccept != nil {
+			return
+		}
+		go server.handle(conn)
+	}
+}
+
+// handle answers the commands received on the given connection until the client closes it.
+func (server *ControlServer) handle(conn net.Conn) {
+	defer func() {
+		_ = conn.Close()
+	}()
+	scanner := bufio.NewScanner(conn)
+	encoder := json.NewEncoder(conn)
+	for scanner.Scan() {
+		response := server.call(scanner.Bytes())
+		errWrite := encoder.Encode(response)
+		if errWrite != nil {
+			log.Debug().Err(errWrite).Msg("failed to answer control command")
+			return
+		}
+	}
+}
+
+// call executes the given command and returns the response.
+func (server *ControlServer) call(line []byte) controlResponse {
+	var request controlRequest
+	errJson := json.Unmarshal(line, &request)
+	if errJson != nil {
+		return controlResponse{Version: "2.0", Error: &ControlError{Code: controlParseError, Message: fmt.Sprintf("invalid request: %v", errJson)}}
+	}
+	response := controlResponse{Version: "2.0", ID: request.ID}
+	handler, ok := server.handlers[request.Method]
+	if !ok {
+		response.Error = &ControlError{Code: controlMethodNotFound, Message: fmt.Sprintf("unknown command '%s'", request.Method)}
+		return response
+	}
+	log.Debug().Msg(fmt.Sprintf("control command '%s'", request.Method))
+	result, errHandler := handler(request.Params)
+	if errHandler != nil {
+		if controlError, ok := errHandler.(*ControlError); ok {
+			response.Error = controlError
+		} else {
+			response.Error = &ControlError{Code: controlServerError, Message: errHandler.Error()}
+		}
+		return response
+	}
+	data, errResult := json.Marshal(result)
+	if errResult != nil {
+		response.Error = &ControlError{Code: controlServerError, Message: fmt.Sprintf("failed to serialize result: %v", errResult)}
+		return response
+	}
+	response.Result = data
+	return response
+}
+
+// CallControl sends the given command to the control socket at the given path and stores its result in result
+// (unless nil).
+func CallControl(socketPath string, method string, params interface{}, result interface{}) error {
+	conn, errDial := net.DialTimeout("unix", socketPath, controlTimeout)
+	if errDial != nil {
+		return fmt.Errorf("failed to connect to %s (is the account polled?): %v", socketPath, errDial)
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	_ = conn.SetDeadline(time.Now().Add(controlTimeout))
+
+	request := controlRequest{Version: "2.0", ID: 1, Method: method}
+	if params != nil {
+		data, errParams := json.Marshal(params)
+		if errParams != nil {
+			return fmt.Errorf("failed to serialize parameters: %v", errParams)
+		}
+		request.Params = data
+	}
+	errWrite := json.NewEncoder(conn).Encode(request)
+	if errWrite != nil {
+		return fmt.Errorf("failed to send command: %v", errWrite)
+	}
+
+	var response controlResponse
+	errRead := json.NewDecoder(conn).Decode(&response)
+	if errRead != nil {
+		return fmt.Errorf("failed to read response: %v", errRead)
+	}
+	if response.Error != nil {
+		return response.Error
+	}
+	if result == nil || len(response.Result) == 0 {
+		return nil
+	}
+	errResult := json.Unmarshal(response.Result, result)
+	if errResult != nil {
+		return fmt.Errorf("failed to parse result: %v", errResult)
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package internal
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestControl(t *testing.T) {
+	dir, errDir := ioutil.TempDir("", "goyammer-test")
+	if errDir != nil {
+		t.Fatal(errDir)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	socketPath := path.Join(dir, "test.sock")
+
+	// a stale socket is replaced
+	if err := ioutil.WriteFile(socketPath, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	server, errListen := ListenControl(socketPath, map[string]ControlHandler{
+		"echo": func(params json.RawMessage) (interface{}, error) {
+			var p struct {
+				Text string `json:"text"`
+			}
+			if err := DecodeParams(params, &p); err != nil {
+				return nil, err
+			}
+			return p.Text, nil
+		},
+		"fail": func(params json.RawMessage) (interface{}, error) {
+			return nil, fmt.Errorf("failed")
+		},
+	})
+	if errListen != nil {
+		t.Fatalf("ListenControl() failed: %v", errListen)
+	}
+
+	tests := []struct {
+		name    string
+		method  string
+		params  interface{}
+		want    string
+		wantErr int
+	}{
+		{name: "result", method: "echo", params: map[string]string{"text": "hello"}, want: "hello"},
+		{name: "no params", method: "echo", want: ""},
+		{name: "invalid params", method: "echo", params: map[string]int{"text": 1}, wantErr: controlInvalidParams},
+		{name: "error", method: "fail", wantErr: controlServerError},
+		{name: "unknown", method: "unknown", wantErr: controlMethodNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			err := CallControl(socketPath, tt.method, tt.params, &got)
+			if tt.wantErr != 0 {
+				if controlError, ok := err.(*ControlError); !ok || controlError.Code != tt.wantErr {
+					t.Errorf("CallControl() = %v, want error %d", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("CallControl() = %s, %v, want %s", got, err, tt.want)
+			}
+		})
+	}
+
+	// several requests per connection, garbage is answered with a parse error
+	conn, errDial := net.Dial("unix", socketPath)
+	if errDial != nil {
+		t.Fatal(errDial)
+	}
+	_, _ = conn.Write([]byte("garbage\n{\"jsonrpc\":\"2.0\",\"id\":7,\"method\":\"echo\",\"params\":{\"text\":\"again\"}}\n"))
+	reader := bufio.NewReader(conn)
+	for _, want := range []string{`"code":-32700`, `"id":7,"result":"again"`} {
+		line, errRead := reader.ReadString('\n')
+		if errRead != nil || !strings.Contains(line, want) {
+			t.Errorf("response = %s, %v, want %s", line, errRead, want)
+		}
+	}
+	_ = conn.Close()
+
+	if err := server.Close(); err != nil {
+		t.Errorf("Close() failed: %v", err)
+	}
+	if FileExists(socketPath) {
+		t.Errorf("Close() left the socket behind")
+	}
+	if err := CallControl(socketPath, "echo", nil, nil); err == nil {
+		t.Errorf("CallControl() after Close() succeeded, want error")
+	}
+}
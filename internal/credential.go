@@ -0,0 +1,163 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+)
+
+// DefaultAccount is the account tokens are stored for unless another one is given.
+const DefaultAccount = "default"
+
+// Credential store backends.
+const (
+	StoreAuto          = "auto"
+	StoreSecretService = "secret-service"
+	StoreEncryptedFile = "encrypted-file"
+	StoreFile          = "file"
+)
+
+// account names are used in file names
+var accountPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// ValidateAccount checks whether the given account name is usable.
+func ValidateAccount(account string) error {
+	if !accountPattern.MatchString(account) {
+		return fmt.Errorf("invalid account name '%s', use letters, digits, '-' and '_' only", account)
+	}
+	return nil
+}
+
+// ErrTokenNotFound is returned by credential stores if no token is stored for an account.
+var ErrTokenNotFound = errors.New("no token stored")
+
+// CredentialStore is the interface of the places access tokens are stored in (by account).
+type CredentialStore interface {
+
+	// Get returns the token of the given account (or ErrTokenNotFound).
+	Get(account string) (string, error)
+
+	// Set stores the token of the given account (replacing an existing one).
+	Set(account string, token string) error
+
+	// Delete removes the token of the given account (or returns ErrTokenNotFound).
+	Delete(account string) error
+
+	// String describes where the tokens are stored.
+	String() string
+}
+
+// NewCredentialStore returns the credential store with the given backend: "secret-service" (the Secret Service via
+// D-Bus), "encrypted-file" (a file encrypted with a passphrase), "file" (plain text files) or "auto" (the Secret Service
+// if available, plain text files otherwise).
+func NewCredentialStore(backend string) (CredentialStore, error) {
+	switch backend {
+	case StoreAuto:
+		secretStore, errSecret := NewSecretServiceStore()
+		if errSecret != nil {
+			return NewFileStore(), nil
+		}
+		return &fallbackStore{primary: secretStore, fallback: NewFileStore()}, nil
+	case StoreSecretService:
+		return NewSecretServiceStore()
+	case StoreEncryptedFile:
+		return NewEncryptedFileStore(path.Join(ConfigDir(), encryptedTokenFile), ReadPassphrase), nil
+	case StoreFile:
+		return NewFileStore(), nil
+	default:
+		return nil, fmt.Errorf("unknown credential store '%s', expected auto, secret-service, encrypted-file or file", backend)
+	}
+}
+
+// fileStore stores tokens as plain text files in the home directory.
+type fileStore struct {
+	dir string
+}
+
+// NewFileStore returns a credential store keeping tokens in plain text files in the home directory (the token of the
+// default account in ~/.goyammer-token).
+func NewFileStore() CredentialStore {
+	home, _ := os.UserHomeDir()
+	return &fileStore{dir: home}
+}
+
+func (store *fileStore) path(account string) string {
+	if account == DefaultAccount {
+		return path.Join(store.dir, tokeFile)
+	}
+	return path.Join(store.dir, fmt.Sprintf("%s-%s", tokeFile, account))
+}
+
+func (store *fileStore) Get(account string) (string, error) {
+	tokenPath := store.path(account)
+	token, errRead := ioutil.ReadFile(tokenPath)
+	if os.IsNotExist(errRead) {
+		return "", ErrTokenNotFound
+	}
+	if errRead != nil {
+		return "", fmt.Errorf("failed to read token from %s: %v", tokenPath, errRead)
+	}
+	return string(token), nil
+}
+
+func (store *fileStore) Set(account string, token string) error {
+	tokenPath := store.path(account)
+	errWrite := ioutil.WriteFile(tokenPath, []byte(token), 0600)
+	if errWrite != nil {
+		return fmt.Errorf("failed to write token to %s: %v", tokenPath, errWrite)
+	}
+	return nil
+}
+
+func (store *fileStore) Delete(account string) error {
+	tokenPath := store.path(account)
+	errRemove := os.Remove(tokenPath)
+	if os.IsNotExist(errRemove) {
+		return ErrTokenNotFound
+	}
+	if errRemove != nil {
+		return fmt.Errorf("failed to remove %s: %v", tokenPath, errRemove)
+	}
+	return nil
+}
+
+func (store *fileStore) String() string {
+	return fmt.Sprintf("plain text file in %s", store.dir)
+}
+
+// fallbackStore stores tokens in the primary store, but still finds tokens only present in the fallback store.
+type fallbackStore struct {
+	primary  CredentialStore
+	fallback CredentialStore
+}
+
+func (store *fallbackStore) Get(account string) (string, error) {
+	token, errGet := store.primary.Get(account)
+	if errGet == ErrTokenNotFound {
+		return store.fallback.Get(account)
+	}
+	return token, errGet
+}
+
+func (store *fallbackStore) Set(account string, token string) error {
+	return store.primary.Set(account, token)
+}
+
+func (store *fallbackStore) Delete(account string) error {
+	errPrimary := store.primary.Delete(account)
+	if errPrimary != nil && errPrimary != ErrTokenNotFound {
+		return errPrimary
+	}
+	errFallback := store.fallback.Delete(account)
+	if errFallback == ErrTokenNotFound {
+		return errPrimary
+	}
+	return errFallback
+}
+
+func (store *fallbackStore) String() string {
+	return store.primary.String()
+}
@@ -0,0 +1,291 @@
+package internal
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"sync"
+
+	"golang.org/x/crypto/scrypt"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+const encryptedTokenFile = "tokens.enc"
+
+// PassphraseEnv is the environment variable the passphrase of the encrypted credential store is read from (before
+// asking for it on the terminal).
+const PassphraseEnv = "GOYAMMER_PASSPHRASE"
+
+// PassphraseFdEnv is the environment variable naming an inherited file descriptor the passphrase is read from (e.g. by
+// the detached poller, see PassphraseFile).
+const PassphraseFdEnv = "GOYAMMER_PASSPHRASE_FD"
+
+// the passphrase read from the environment or an inherited file descriptor (kept as both are cleared once read)
+var inherited struct {
+	sync.Mutex
+	passphrase []byte
+}
+
+// scrypt parameters (as recommended for interactive logins)
+const (
+	scryptN      = 1 << 15
+	scryptR      = 8
+	scryptP      = 1
+	scryptKeyLen = 32
+	scryptSalt   = 16
+)
+
+// encryptedToken is a token encrypted with AES-GCM using a key derived from the passphrase and the salt by scrypt.
+type encryptedToken struct {
+	Salt  []byte `json:"salt"`
+	Nonce []byte `json:"nonce"`
+	Data  []byte `json:"data"`
+}
+
+// encryptedFileStore stores tokens encrypted in a single JSON file.
+type encryptedFileStore struct {
+	path string
+
+	// passphrase is called (once) when the passphrase is needed
+	passphrase func() ([]byte, error)
+
+	mutex  sync.Mutex
+	secret []byte
+}
+
+// NewEncryptedFileStore returns a credential store keeping tokens in the given file, encrypted with the passphrase
+// returned by the given function.
+func NewEncryptedFileStore(path string, passphrase func() ([]byte, error)) CredentialStore {
+	return &encryptedFileStore{path: path, passphrase: passphrase}
+}
+
+// ReadPassphrase returns the passphrase of the encrypted credential store from the environment (or an inherited file
+// descriptor) or, if not given, asks for it on the terminal.
+func ReadPassphrase() ([]byte, error) {
+	passphrase, errInherited := inheritedPassphrase()
+	if errInherited != nil {
+		return nil, errInherited
+	}
+	if passphrase != nil {
+		return passphrase, nil
+	}
+	fd := int(os.Stdin.Fd())
+	if !terminal.IsTerminal(fd) {
+		return nil, fmt.Errorf("no passphrase for the encrypted token store, set %s", PassphraseEnv)
+	}
+	_, _ = fmt.Fprint(os.Stderr, "passphrase of the token store: ")
+	passphrase, errRead := terminal.ReadPassword(fd)
+	_, _ = fmt.Fprintln(os.Stderr)
+	if errRead != nil {
+		return nil, fmt.Errorf("failed to read passphrase: %v", errRead)
+	}
+	if len(passphrase) == 0 {
+		return nil, fmt.Errorf("empty passphrase")
+	}
+	return passphrase, nil
+}
+
+// inheritedPassphrase returns the passphrase given in the environment or through an inherited file descriptor (nil if
+// none). Both are removed from the environment when read first, so that child processes (e.g. xdg-open) do not get the
+// passphrase.
+func inheritedPassphrase() ([]byte, error) {
+	inherited.Lock()
+	defer inherited.Unlock()
+	if inherited.passphrase != nil {
+		return inherited.passphrase, nil
+	}
+
+	if passphrase := os.Getenv(PassphraseEnv); passphrase != "" {
+		_ = os.Unsetenv(PassphraseEnv)
+		inherited.passphrase = []byte(passphrase)
+	}
+
+	value := os.Getenv(PassphraseFdEnv)
+	if value == "" {
+		return inherited.passphrase, nil
+	}
+	_ = os.Unsetenv(PassphraseFdEnv)
+	fd, errFd := strconv.Atoi(value)
+	if errFd != nil {
+		return nil, fmt.Errorf("invalid %s '%s': %v", PassphraseFdEnv, value, errFd)
+	}
+	file := os.NewFile(uintptr(fd), "passphrase")
+	defer func() {
+		_ = file.Close()
+	}()
+	passphrase, errRead := ioutil.ReadAll(file)
+	if errRead != nil {
+		return nil, fmt.Errorf("failed to read passphrase from file descriptor %d: %v", fd, errRead)
+	}
+	if len(passphrase) == 0 {
+		return nil, fmt.Errorf("empty passphrase")
+	}
+	inherited.passphrase = passphrase
+	return passphrase, nil
+}
+
+// PassphraseFile returns the read end of a pipe holding the given passphrase, to be inherited by a child process which
+// finds its file descriptor in PassphraseFdEnv.
+func PassphraseFile(passphrase []byte) (*os.File, error) {
+	reader, writer, errPipe := os.Pipe()
+	if errPipe != nil {
+		return nil, fmt.Errorf("failed to create pipe: %v", errPipe)
+	}
+
+	// the passphrase fits into the buffer of the pipe
+	_, errWrite := writer.Write(passphrase)
+	errClose := writer.Close()
+	if errWrite == nil {
+		errWrite = errClose
+	}
+	if errWrite != nil {
+		_ = reader.Close()
+		return nil, fmt.Errorf("failed to write passphrase to pipe: %v", errWrite)
+	}
+	return reader, nil
+}
+
+// getPassphrase returns the passphrase (asking for it only once).
+func (store *encryptedFileStore) getPassphrase() ([]byte, error) {
+	if store.secret != nil {
+		return store.secret, nil
+	}
+	passphrase, errPassphrase := store.passphrase()
+	if errPassphrase != nil {
+		return nil, errPassphrase
+	}
+	store.secret = passphrase
+	return passphrase, nil
+}
+
+// load reads all encrypted tokens by account (a missing file results in none).
+func (store *encryptedFileStore) load() (map[string]encryptedToken, error) {
+	tokens := make(map[string]encryptedToken)
+	data, errRead := ioutil.ReadFile(store.path)
+	if os.IsNotExist(errRead) {
+		return tokens, nil
+	}
+	if errRead != nil {
+		return nil, fmt.Errorf("failed to read tokens from %s: %v", store.path, errRead)
+	}
+	errJson := json.Unmarshal(data, &tokens)
+	if errJson != nil {
+		return nil, fmt.Errorf("failed to parse tokens in %s: %v", store.path, errJson)
+	}
+	return tokens, nil
+}
+
+// save writes all encrypted tokens (atomically, readable by the user only).
+func (store *encryptedFileStore) save(tokens map[string]encryptedToken) error {
+	data, errJson := json.MarshalIndent(tokens, "", "  ")
+	if errJson != nil {
+		return fmt.Errorf("failed to serialize tokens: %v", errJson)
+	}
+	errDir := os.MkdirAll(path.Dir(store.path), 0700)
+	if errDir != nil {
+		return fmt.Errorf("failed to create %s: %v", path.Dir(store.path), errDir)
+	}
+	tmp := store.path + ".tmp"
+	errWrite := ioutil.WriteFile(tmp, data, 0600)
+	if errWrite != nil {
+		return fmt.Errorf("failed to write tokens to %s: %v", tmp, errWrite)
+	}
+	errRename := os.Rename(tmp, store.path)
+	if errRename != nil {
+		return fmt.Errorf("failed to rename %s to %s: %v", tmp, store.path, errRename)
+	}
+	return nil
+}
+
+// gcm returns the AES-GCM cipher keyed by the passphrase and the given salt.
+func (store *encryptedFileStore) gcm(salt []byte) (cipher.AEAD, error) {
+	passphrase, errPassphrase := store.getPassphrase()
+	if errPassphrase != nil {
+		return nil, errPassphrase
+	}
+	key, errKey := scrypt.Key(passphrase, salt, scryptN, scryptR, scryptP, scryptKeyLen)
+	if errKey != nil {
+		return nil, fmt.Errorf("failed to derive key: %v", errKey)
+	}
+	block, errBlock := aes.NewCipher(key)
+	if errBlock != nil {
+		return nil, fmt.Errorf("failed to create cipher: %v", errBlock)
+	}
+	return cipher.NewGCM(block)
+}
+
+func (store *encryptedFileStore) Get(account string) (string, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	tokens, errLoad := store.load()
+	if errLoad != nil {
+		return "", errLoad
+	}
+	encrypted, ok := tokens[account]
+	if !ok {
+		return "", ErrTokenNotFound
+	}
+	gcm, errGcm := store.gcm(encrypted.Salt)
+	if errGcm != nil {
+		return "", errGcm
+	}
+	token, errOpen := gcm.Open(nil, encrypted.Nonce, encrypted.Data, []byte(account))
+	if errOpen != nil {
+		return "", fmt.Errorf("failed to decrypt token (wrong passphrase?): %v", errOpen)
+	}
+	return string(token), nil
+}
+
+func (store *encryptedFileStore) Set(account string, token string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	tokens, errLoad := store.load()
+	if errLoad != nil {
+		return errLoad
+	}
+	salt := make([]byte, scryptSalt)
+	if _, errSalt := rand.Read(salt); errSalt != nil {
+		return fmt.Errorf("failed to read random bytes: %v", errSalt)
+	}
+	gcm, errGcm := store.gcm(salt)
+	if errGcm != nil {
+		return errGcm
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, errNonce := rand.Read(nonce); errNonce != nil {
+		return fmt.Errorf("failed to read random bytes: %v", errNonce)
+	}
+	tokens[account] = encryptedToken{
+		Salt:  salt,
+		Nonce: nonce,
+		Data:  gcm.Seal(nil, nonce, []byte(token), []byte(account)),
+	}
+	return store.save(tokens)
+}
+
+func (store *encryptedFileStore) Delete(account string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	tokens, errLoad := store.load()
+	if errLoad != nil {
+		return errLoad
+	}
+	if _, ok := tokens[account]; !ok {
+		return ErrTokenNotFound
+	}
+	delete(tokens, account)
+	return store.save(tokens)
+}
+
+func (store *encryptedFileStore) String() string {
+	return fmt.Sprintf("encrypted file %s", store.path)
+}
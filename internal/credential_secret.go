@@ -0,0 +1,229 @@
+package internal
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+const (
+	secretsName              = "org.freedesktop.secrets"
+	secretsPath              = "/org/freedesktop/secrets"
+	secretsDefaultCollection = "/org/freedesktop/secrets/aliases/default"
+	secretsServiceInterface  = "org.freedesktop.Secret.Service"
+	secretsItemInterface     = "org.freedesktop.Secret.Item"
+	secretsPromptInterface   = "org.freedesktop.Secret.Prompt"
+
+	// the path returned instead of a prompt if no prompt is needed
+	secretsNoPrompt = dbus.ObjectPath("/")
+
+	// how long to wait for the user to answer a prompt (e.g. to unlock the keyring)
+	secretsPromptTimeout = 2 * time.Minute
+)
+
+// secretService is the part of org.freedesktop.secrets used by the store (replaced by a stand-in in tests).
+type secretService interface {
+
+	// search returns the (unlocked) items with the given attributes.
+	search(attributes map[string]string) ([]dbus.ObjectPath, error)
+
+	// secret returns the secret of the given item.
+	secret(item dbus.ObjectPath) ([]byte, error)
+
+	// create creates (or replaces) the item with the given attributes in the default collection.
+	create(label string, attributes map[string]string, secret []byte) error
+
+	// delete deletes the given item.
+	delete(item dbus.ObjectPath) error
+}
+
+// dbusSecret is the (oayays) structure of a secret.
+type dbusSecret struct {
+	Session     dbus.ObjectPath
+	Parameters  []byte
+	Value       []byte
+	ContentType string
+}
+
+// sessionSecretService is org.freedesktop.secrets on the session bus (using a plain session, which is fine as the
+// session bus is local).
+type sessionSecretService struct {
+	conn    *dbus.Conn
+	session dbus.ObjectPath
+}
+
+func (service *sessionSecretService) object(path dbus.ObjectPath) dbus.BusObject {
+	return service.conn.Object(secretsName, path)
+}
+
+// prompt shows the given prompt (unless none is needed) and waits for the user to complete it.
+func (service *sessionSecretService) prompt(prompt dbus.ObjectPath) error {
+	if prompt == secretsNoPrompt {
+		return nil
+	}
+	errMatch := service.conn.AddMatchSignal(
+		dbus.WithMatchObjectPath(prompt),
+		dbus.WithMatchInterface(secretsPromptInterface),
+		dbus.WithMatchMember("Completed"),
+	)
+	if errMatch != nil {
+		return fmt.Errorf("failed to subscribe to prompt: %v", errMatch)
+	}
+	signals := make(chan *dbus.Signal, 10)
+	service.conn.Signal(signals)
+	defer service.conn.RemoveSignal(signals)
+
+	errPrompt := service.object(prompt).Call(secretsPromptInterface+".Prompt", 0, "").Store()
+	if errPrompt != nil {
+		return fmt.Errorf("failed to prompt: %v", errPrompt)
+	}
+	timeout := time.After(secretsPromptTimeout)
+	for {
+		select {
+		case signal := <-signals:
+			if signal.Path != prompt || signal.Name != secretsPromptInterface+".Completed" || len(signal.Body) < 1 {
+				continue
+			}
+			if dismissed, _ := signal.Body[0].(bool); dismissed {
+				return fmt.Errorf("prompt dismissed")
+			}
+			return nil
+		case <-timeout:
+			return fmt.Errorf("timed out waiting for prompt")
+		}
+	}
+}
+
+func (service *sessionSecretService) search(attributes map[string]string) ([]dbus.ObjectPath, error) {
+	var unlocked, locked []dbus.ObjectPath
+	errSearch := service.object(secretsPath).Call(secretsServiceInterface+".SearchItems", 0, attributes).Store(&unlocked, &locked)
+	if errSearch != nil {
+		return nil, fmt.Errorf("failed to search items: %v", errSearch)
+	}
+	if len(locked) == 0 {
+		return unlocked, nil
+	}
+
+	// unlock locked items (which may prompt the user for the keyring password)
+	var justUnlocked []dbus.ObjectPath
+	var prompt dbus.ObjectPath
+	errUnlock := service.object(secretsPath).Call(secretsServiceInterface+".Unlock", 0, locked).Store(&justUnlocked, &prompt)
+	if errUnlock != nil {
+		return nil, fmt.Errorf("failed to unlock items: %v", errUnlock)
+	}
+	if errPrompt := service.prompt(prompt); errPrompt != nil {
+		return nil, fmt.Errorf("failed to unlock items: %v", errPrompt)
+	}
+	if prompt != secretsNoPrompt {
+		justUnlocked = locked
+	}
+	return append(unlocked, justUnlocked...), nil
+}
+
+func (service *sessionSecretService) secret(item dbus.ObjectPath) ([]byte, error) {
+	var secret dbusSecret
+	errGet := service.object(item).Call(secretsItemInterface+".GetSecret", 0, service.session).Store(&secret)
+	if errGet != nil {
+		return nil, fmt.Errorf("failed to get secret: %v", errGet)
+	}
+	return secret.Value, nil
+}
+
+func (service *sessionSecretService) create(label string, attributes map[string]string, secret []byte) error {
+	properties := map[string]dbus.Variant{
+		secretsItemInterface + ".Label":      dbus.MakeVariant(label),
+		secretsItemInterface + ".Attributes": dbus.MakeVariant(attributes),
+	}
+	value := dbusSecret{Session: service.session, Parameters: []byte{}, Value: secret, ContentType: "text/plain"}
+	var item, prompt dbus.ObjectPath
+	errCreate := service.object(secretsDefaultCollection).Call("org.freedesktop.Secret.Collection.CreateItem", 0,
+		properties, value, true).Store(&item, &prompt)
+	if errCreate != nil {
+		return fmt.Errorf("failed to create item: %v", errCreate)
+	}
+	if errPrompt := service.prompt(prompt); errPrompt != nil {
+		return fmt.Errorf("failed to create item: %v", errPrompt)
+	}
+	return nil
+}
+
+func (service *sessionSecretService) delete(item dbus.ObjectPath) error {
+	var prompt dbus.ObjectPath
+	errDelete := service.object(item).Call(secretsItemInterface+".Delete", 0).Store(&prompt)
+	if errDelete != nil {
+		return fmt.Errorf("failed to delete item: %v", errDelete)
+	}
+	if errPrompt := service.prompt(prompt); errPrompt != nil {
+		return fmt.Errorf("failed to delete item: %v", errPrompt)
+	}
+	return nil
+}
+
+// secretServiceStore stores tokens in the Secret Service (e.g. GNOME Keyring or KWallet).
+type secretServiceStore struct {
+	service secretService
+}
+
+// NewSecretServiceStore returns a credential store keeping tokens in the Secret Service on the session bus.
+func NewSecretServiceStore() (CredentialStore, error) {
+	conn, errConn := dbus.SessionBus()
+	if errConn != nil {
+		return nil, fmt.Errorf("failed to connect to session bus: %v", errConn)
+	}
+	var output dbus.Variant
+	var session dbus.ObjectPath
+	errOpen := conn.Object(secretsName, secretsPath).Call(secretsServiceInterface+".OpenSession", 0,
+		"plain", dbus.MakeVariant("")).Store(&output, &session)
+	if errOpen != nil {
+		return nil, fmt.Errorf("failed to open secret service session: %v", errOpen)
+	}
+	return &secretServiceStore{service: &sessionSecretService{conn: conn, session: session}}, nil
+}
+
+// attributes returns the attributes identifying the token of the given account.
+func (store *secretServiceStore) attributes(account string) map[string]string {
+	return map[string]string{
+		"application": "goyammer",
+		"account":     account,
+	}
+}
+
+func (store *secretServiceStore) Get(account string) (string, error) {
+	items, errSearch := store.service.search(store.attributes(account))
+	if errSearch != nil {
+		return "", errSearch
+	}
+	if len(items) == 0 {
+		return "", ErrTokenNotFound
+	}
+	token, errSecret := store.service.secret(items[0])
+	if errSecret != nil {
+		return "", errSecret
+	}
+	return string(token), nil
+}
+
+func (store *secretServiceStore) Set(account string, token string) error {
+	return store.service.create(fmt.Sprintf("goyammer access token (%s)", account), store.attributes(account), []byte(token))
+}
+
+func (store *secretServiceStore) Delete(account string) error {
+	items, errSearch := store.service.search(store.attributes(account))
+	if errSearch != nil {
+		return errSearch
+	}
+	if len(items) == 0 {
+		return ErrTokenNotFound
+	}
+	for _, item := range items {
+		if errDelete := store.service.delete(item); errDelete != nil {
+			return errDelete
+		}
+	}
+	return nil
+}
+
+func (store *secretServiceStore) String() string {
+	return "Secret Service"
+}
@@ -0,0 +1,101 @@
+package internal
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// fakeSecretItem is an item of the fake secret service.
+type fakeSecretItem struct {
+	attributes map[string]string
+	secret     []byte
+	locked     bool
+}
+
+// fakeSecretService is a stand-in for org.freedesktop.secrets.
+type fakeSecretService struct {
+	items  map[dbus.ObjectPath]*fakeSecretItem
+	lastId int
+}
+
+func newFakeSecretService() *fakeSecretService {
+	return &fakeSecretService{items: make(map[dbus.ObjectPath]*fakeSecretItem)}
+}
+
+func (service *fakeSecretService) matches(item *fakeSecretItem, attributes map[string]string) bool {
+	for key, value := range attributes {
+		if item.attributes[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+func (service *fakeSecretService) search(attributes map[string]string) ([]dbus.ObjectPath, error) {
+	var paths []dbus.ObjectPath
+	for path, item := range service.items {
+		if service.matches(item, attributes) {
+			item.locked = false
+			paths = append(paths, path)
+		}
+	}
+	return paths, nil
+}
+
+func (service *fakeSecretService) secret(path dbus.ObjectPath) ([]byte, error) {
+	item, ok := service.items[path]
+	if !ok || item.locked {
+		return nil, fmt.Errorf("no such unlocked item %s", path)
+	}
+	return item.secret, nil
+}
+
+func (service *fakeSecretService) create(label string, attributes map[string]string, secret []byte) error {
+	for _, item := range service.items {
+		if service.matches(item, attributes) {
+			item.secret = secret
+			return nil
+		}
+	}
+	service.lastId++
+	path := dbus.ObjectPath(fmt.Sprintf("/org/freedesktop/secrets/collection/login/%d", service.lastId))
+	service.items[path] = &fakeSecretItem{attributes: attributes, secret: secret}
+	return nil
+}
+
+func (service *fakeSecretService) delete(path dbus.ObjectPath) error {
+	if _, ok := service.items[path]; !ok {
+		return fmt.Errorf("no such item %s", path)
+	}
+	delete(service.items, path)
+	return nil
+}
+
+func TestSecretServiceStore(t *testing.T) {
+	service := newFakeSecretService()
+	testCredentialStore(t, &secretServiceStore{service: service})
+
+	// tokens are identified by application and account
+	for _, item := range service.items {
+		if item.attributes["application"] != "goyammer" || item.attributes["account"] != "partner" {
+			t.Errorf("unexpected attributes %v", item.attributes)
+		}
+	}
+	if len(service.items) != 1 {
+		t.Errorf("%d items left, want 1", len(service.items))
+	}
+}
+
+func TestSecretServiceStore_locked(t *testing.T) {
+	service := newFakeSecretService()
+	store := &secretServiceStore{service: service}
+	_ = store.Set(DefaultAccount, "the-token")
+	for _, item := range service.items {
+		item.locked = true
+	}
+	if token, err := store.Get(DefaultAccount); err != nil || token != "the-token" {
+		t.Errorf("Get() = %s, %v, want the-token", token, err)
+	}
+}
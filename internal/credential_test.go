@@ -0,0 +1,204 @@
+package internal
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"testing"
+)
+
+// testCredentialStore checks the round trip of storing, reading and deleting tokens.
+func testCredentialStore(t *testing.T, store CredentialStore) {
+	if _, err := store.Get(DefaultAccount); err != ErrTokenNotFound {
+		t.Errorf("Get() of a missing token returned %v, want ErrTokenNotFound", err)
+	}
+	for account, token := range map[string]string{DefaultAccount: "the-token", "partner": "other-token"} {
+		if err := store.Set(account, token); err != nil {
+			t.Fatalf("Set(%s) failed: %v", account, err)
+		}
+	}
+	if err := store.Set(DefaultAccount, "new-token"); err != nil {
+		t.Fatalf("Set() failed to replace token: %v", err)
+	}
+	for account, want := range map[string]string{DefaultAccount: "new-token", "partner": "other-token"} {
+		if token, err := store.Get(account); err != nil || token != want {
+			t.Errorf("Get(%s) = %s, %v, want %s", account, token, err, want)
+		}
+	}
+	if err := store.Delete(DefaultAccount); err != nil {
+		t.Errorf("Delete() failed: %v", err)
+	}
+	if _, err := store.Get(DefaultAccount); err != ErrTokenNotFound {
+		t.Errorf("Get() of a deleted token returned %v, want ErrTokenNotFound", err)
+	}
+	if err := store.Delete(DefaultAccount); err != ErrTokenNotFound {
+		t.Errorf("Delete() of a deleted token returned %v, want ErrTokenNotFound", err)
+	}
+}
+
+func TestFileStore(t *testing.T) {
+	dir, errDir := ioutil.TempDir("", "goyammer-test")
+	if errDir != nil {
+		t.Fatal(errDir)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	testCredentialStore(t, &fileStore{dir: dir})
+}
+
+func TestEncryptedFileStore(t *testing.T) {
+	dir, errDir := ioutil.TempDir("", "goyammer-test")
+	if errDir != nil {
+		t.Fatal(errDir)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	file := path.Join(dir, "tokens.enc")
+
+	prompts := 0
+	store := NewEncryptedFileStore(file, func() ([]byte, error) {
+		prompts++
+		return []byte("secret"), nil
+	})
+	testCredentialStore(t, store)
+	if prompts != 1 {
+		t.Errorf("asked for the passphrase %d times, want 1", prompts)
+	}
+
+	// the token must not be readable without the right passphrase
+	data, _ := ioutil.ReadFile(file)
+	if len(data) == 0 || bytes.Contains(data, []byte("other-token")) {
+		t.Errorf("tokens are not encrypted: %s", data)
+	}
+	wrong := NewEncryptedFileStore(file, func() ([]byte, error) {
+		return []byte("wrong"), nil
+	})
+	if token, err := wrong.Get("partner"); err == nil {
+		t.Errorf("Get() with the wrong passphrase = %s, want error", token)
+	}
+}
+
+func TestFallbackStore(t *testing.T) {
+	dir, errDir := ioutil.TempDir("", "goyammer-test")
+	if errDir != nil {
+		t.Fatal(errDir)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	// tokens only in the fallback store are found (and deleted)
+	fallback := &fileStore{dir: dir}
+	_ = fallback.Set(DefaultAccount, "old-token")
+	store := &fallbackStore{primary: &secretServiceStore{service: newFakeSecretService()}, fallback: fallback}
+	if token, err := store.Get(DefaultAccount); err != nil || token != "old-token" {
+		t.Errorf("Get() = %s, %v, want old-token", token, err)
+	}
+	_ = store.Set(DefaultAccount, "new-token")
+	if token, err := store.Get(DefaultAccount); err != nil || token != "new-token" {
+		t.Errorf("Get() = %s, %v, want new-token", token, err)
+	}
+	if err := store.Delete(DefaultAccount); err != nil {
+		t.Errorf("Delete() failed: %v", err)
+	}
+	if _, err := fallback.Get(DefaultAccount); err != ErrTokenNotFound {
+		t.Errorf("Delete() kept the token in the fallback store")
+	}
+}
+
+func TestValidateAccount(t *testing.T) {
+	tests := []struct {
+		account string
+		valid   bool
+	}{
+		{DefaultAccount, true},
+		{"partner-network_2", true},
+		{"", false},
+		{"../evil", false},
+		{"with space", false},
+	}
+	for _, test := range tests {
+		if err := ValidateAccount(test.account); (err == nil) != test.valid {
+			t.Errorf("ValidateAccount(%q) = %v, want valid %t", test.account, err, test.valid)
+		}
+	}
+}
+
+func TestDeleteToken(t *testing.T) {
+	dir, errDir := ioutil.TempDir("", "goyammer-test")
+	if errDir != nil {
+		t.Fatal(errDir)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	secrets := &secretServiceStore{service: newFakeSecretService()}
+	files := &fileStore{dir: dir}
+	stores := []CredentialStore{secrets, files}
+	_ = secrets.Set("partner", "secret-token")
+	_ = files.Set("partner", "file-token")
+	_ = files.Set(DefaultAccount, "other-token")
+
+	deleted, err := DeleteToken(stores, "partner")
+	if err != nil || len(deleted) != 2 {
+		t.Errorf("DeleteToken() deleted from %d stores (%v), want 2", len(deleted), err)
+	}
+	for _, store := range stores {
+		if _, err := store.Get("partner"); err != ErrTokenNotFound {
+			t.Errorf("Get() from %s after DeleteToken() returned %v, want ErrTokenNotFound", store.String(), err)
+		}
+	}
+	if token, err := files.Get(DefaultAccount); err != nil || token != "other-token" {
+		t.Errorf("DeleteToken() touched other accounts: %s, %v", token, err)
+	}
+}
+
+func TestReadPassphrase_inherited(t *testing.T) {
+	piped, errPipe := PassphraseFile([]byte("piped"))
+	if errPipe != nil {
+		t.Fatalf("PassphraseFile() failed: %v", errPipe)
+	}
+
+	tests := []struct {
+		name string
+		env  map[string]string
+		want string
+	}{
+		{name: "environment", env: map[string]string{PassphraseEnv: "secret", PassphraseFdEnv: ""}, want: "secret"},
+		{name: "file descriptor", env: map[string]string{PassphraseEnv: "", PassphraseFdEnv: strconv.Itoa(int(piped.Fd()))}, want: "piped"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inherited.passphrase = nil
+			defer func() {
+				inherited.passphrase = nil
+			}()
+			withEnv(tt.env, func() {
+				passphrase, err := ReadPassphrase()
+				if err != nil || string(passphrase) != tt.want {
+					t.Fatalf("ReadPassphrase() = %s, %v, want %s", passphrase, err, tt.want)
+				}
+
+				// the passphrase is not passed on to child processes, but can be read again
+				if os.Getenv(PassphraseEnv) != "" || os.Getenv(PassphraseFdEnv) != "" {
+					t.Errorf("ReadPassphrase() kept the passphrase in the environment")
+				}
+				if again, err := ReadPassphrase(); err != nil || string(again) != tt.want {
+					t.Errorf("ReadPassphrase() again = %s, %v, want %s", again, err, tt.want)
+				}
+			})
+		})
+	}
+
+	withEnv(map[string]string{PassphraseEnv: "", PassphraseFdEnv: "stdin"}, func() {
+		if _, err := inheritedPassphrase(); err == nil {
+			t.Errorf("inheritedPassphrase() with invalid file descriptor succeeded, want error")
+		}
+	})
+}
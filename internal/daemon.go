@@ -0,0 +1,305 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// RuntimeDir returns the directory to keep pid and status files of pollers in (following the XDG base directory
+// specification).
+func RuntimeDir() string {
+	dir := os.Getenv("XDG_RUNTIME_DIR")
+	if dir == "" {
+		return path.Join(os.TempDir(), fmt.Sprintf("goyammer-%d", os.Getuid()))
+	}
+	return path.Join(dir, "goyammer")
+}
+
+// checkRuntimeDir makes sure that the given runtime directory is private to the user. Otherwise, another user could
+// control the pid files and sockets in it (e.g. by creating the fallback directory in /tmp first).
+func checkRuntimeDir(dir string) error {
+	info, errStat := os.Lstat(dir)
+	if errStat != nil {
+		return fmt.Errorf("failed to check %s: %v", dir, errStat)
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !info.IsDir() || !ok || int(stat.Uid) != os.Getuid() || info.Mode().Perm() != 0700 {
+		return fmt.Errorf("refusing to use %s, it must be a directory owned by uid %d with mode 0700", dir, os.Getuid())
+	}
+	return nil
+}
+
+// PidPath returns the path of the pid file of the poller of the given account.
+func PidPath(account string) string {
+	return path.Join(RuntimeDir(), account+".pid")
+}
+
+// StatusPath returns the path of the status file of the poller of the given account.
+func StatusPath(account string) string {
+	return path.Join(RuntimeDir(), account+".status.json")
+}
+
+// RunningError is returned when locking an account which is already polled by another process.
+type RunningError struct {
+	Account string
+	Pid     int
+}
+
+func (e *RunningError) Error() string {
+	return fmt.Sprintf("account '%s' is already polled by process %d", e.Account, e.Pid)
+}
+
+// PidLock is the lock of an account held while polling it: the pid file of the account, locked with flock (so that
+// the lock is released even if the poller dies) and containing the process ID.
+type PidLock struct {
+	path string
+	file *os.File
+}
+
+// LockAccount acquires the lock of the given account (or returns a RunningError if another process holds it).
+func LockAccount(account string) (*PidLock, error) {
+	errDir := os.MkdirAll(RuntimeDir(), 0700)
+	if errDir != nil {
+		return nil, fmt.Errorf("failed to create %s: %v", RuntimeDir(), errDir)
+	}
+	errCheck := checkRuntimeDir(RuntimeDir())
+	if errCheck != nil {
+		return nil, errCheck
+	}
+	pidPath := PidPath(account)
+	for {
+		file, errOpen := os.OpenFile(pidPath, os.O_RDWR|os.O_CREATE, 0600)
+		if errOpen != nil {
+			return nil, fmt.Errorf("failed to open %s: %v", pidPath, errOpen)
+		}
+		errLock := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if errLock == syscall.EWOULDBLOCK {
+			pid, _ := lockedPid(file)
+			_ = file.Close()
+			return nil, &RunningError{Account: account, Pid: pid}
+		}
+		if errLock != nil {
+			_ = file.Close()
+			return nil, fmt.Errorf("failed to lock %s: %v", pidPath, errLock)
+		}
+
+		// the previous holder may have removed the file between opening and locking it
+		if !samePath(file, pidPath) {
+			_ = file.Close()
+			continue
+		}
+
+		errTruncate := file.Truncate(0)
+		if errTruncate == nil {
+			_, errTruncate = file.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+		}
+		if errTruncate != nil {
+			_ = file.Close()
+			return nil, fmt.Errorf("failed to write %s: %v", pidPath, errTruncate)
+		}
+		return &PidLock{path: pidPath, file: file}, nil
+	}
+}
+
+// Release removes the pid file and releases the lock.
+func (lock *PidLock) Release() error {
+	errRemove := os.Remove(lock.path)
+	errClose := lock.file.Close()
+	if errRemove != nil && !os.IsNotExist(errRemove) {
+		return fmt.Errorf("failed to remove %s: %v", lock.path, errRemove)
+	}
+	return errClose
+}
+
+// samePath returns whether the given open file is (still) the file at the given path.
+func samePath(file *os.File, filePath string) bool {
+	openInfo, errOpen := file.Stat()
+	pathInfo, errPath := os.Stat(filePath)
+	return errOpen == nil && errPath == nil && os.SameFile(openInfo, pathInfo)
+}
+
+// StartingError is returned when the poller of an account has locked the account but not yet written its process ID.
+type StartingError struct {
+	Account string
+}
+
+func (e *StartingError) Error() string {
+	return fmt.Sprintf("the poller of account '%s' is starting", e.Account)
+}
+
+// readPid reads the process ID from the given pid file (0 if the file is empty).
+func readPid(file *os.File) (int, error) {
+	data, errRead := ioutil.ReadAll(io.NewSectionReader(file, 0, 64))
+	if errRead != nil {
+		return 0, errRead
+	}
+	text := strings.TrimSpace(string(data))
+	if text == "" {
+		return 0, nil
+	}
+	return strconv.Atoi(text)
+}
+
+// lockedPid reads the process ID from the given pid file locked by another process, giving the process a moment to
+// write it after locking the file (0 if it did not).
+func lockedPid(file *os.File) (int, error) {
+	for i := 0; ; i++ {
+		pid, errPid := readPid(file)
+		if errPid != nil || pid != 0 || i == 10 {
+			return pid, errPid
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// PollerPid returns the process ID of the poller of the given account (or 0 if the account is not polled).
+// A poller which has locked the account but not yet written its process ID results in a StartingError.
+func PollerPid(account string) (int, error) {
+	if _, errStat := os.Lstat(RuntimeDir()); os.IsNotExist(errStat) {
+		return 0, nil
+	}
+	errCheck := checkRuntimeDir(RuntimeDir())
+	if errCheck != nil {
+		return 0, errCheck
+	}
+	pidPath := PidPath(account)
+	file, errOpen := os.Open(pidPath)
+	if os.IsNotExist(errOpen) {
+		return 0, nil
+	}
+	if errOpen != nil {
+		return 0, fmt.Errorf("failed to open %s: %v", pidPath, errOpen)
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	// a pid file which is not locked has been left behind by a poller which died
+	errLock := syscall.Flock(int(file.Fd()), syscall.LOCK_SH|syscall.LOCK_NB)
+	if errLock == nil {
+		return 0, nil
+	}
+	if errLock != syscall.EWOULDBLOCK {
+		return 0, fmt.Errorf("failed to check lock of %s: %v", pidPath, errLock)
+	}
+	pid, errPid := lockedPid(file)
+	if errPid != nil {
+		return 0, fmt.Errorf("failed to read %s: %v", pidPath, errPid)
+	}
+	if pid == 0 {
+		return 0, &StartingError{Account: account}
+	}
+	return pid, nil
+}
+
+// PolledAccounts returns the accounts which are currently polled (sorted by name).
+func PolledAccounts() ([]string, error) {
+	pidPaths, _ := filepath.Glob(path.Join(RuntimeDir(), "*.pid"))
+	var accounts []string
+	for _, pidPath := range pidPaths {
+		account := strings.TrimSuffix(path.Base(pidPath), ".pid")
+		pid, errPid := PollerPid(account)
+		if _, starting := errPid.(*StartingError); errPid != nil && !starting {
+			return nil, errPid
+		}
+		if pid != 0 || errPid != nil {
+			accounts = append(accounts, account)
+		}
+	}
+	sort.Strings(accounts)
+	return accounts, nil
+}
+
+// Status is what the poller of an account reports about itself (for 'goyammer status').
+type Status struct {
+	path string
+
+	Pid           int        `json:"pid"`
+	Started       time.Time  `json:"started"`
+	Network       string     `json:"network,omitempty"`
+	Groups        []string   `json:"groups"`
+	LoginRequired bool       `json:"login_required,omitempty"`
+	Paused        bool       `json:"paused,omitempty"`
+	LastPoll      *time.Time `json:"last_poll,omitempty"`
+	LastError     string     `json:"last_error,omitempty"`
+	LastErrorTime *time.Time `json:"last_error_time,omitempty"`
+}
+
+// NewStatus returns the status of the poller of the given account started now.
+func NewStatus(account string) *Status {
+	return &Status{
+		path:    StatusPath(account),
+		Pid:     os.Getpid(),
+		Started: time.Now(),
+		Groups:  []string{},
+	}
+}
+
+// ReadStatus reads the status the poller of the given account reported last.
+func ReadStatus(account string) (*Status, error) {
+	statusPath := StatusPath(account)
+	data, errRead := ioutil.ReadFile(statusPath)
+	if errRead != nil {
+		return nil, fmt.Errorf("failed to read status from %s: %v", statusPath, errRead)
+	}
+	status := &Status{path: statusPath}
+	errJson := json.Unmarshal(data, status)
+	if errJson != nil {
+		return nil, fmt.Errorf("failed to parse status from %s: %v", statusPath, errJson)
+	}
+	return status, nil
+}
+
+// Polled records a poll (and its error, if any).
+func (status *Status) Polled(errPoll error) {
+	now := time.Now()
+	status.LastPoll = &now
+	if errPoll != nil {
+		status.Failed(errPoll)
+	}
+}
+
+// Failed records an error.
+func (status *Status) Failed(err error) {
+	now := time.Now()
+	status.LastError = err.Error()
+	status.LastErrorTime = &now
+}
+
+// Save writes the status to its file (atomically, by writing to a temp file first and renaming it).
+func (status *Status) Save() error {
+	data, errJson := json.Marshal(status)
+	if errJson != nil {
+		return fmt.Errorf("failed to serialize status: %v", errJson)
+	}
+	tmp := status.path + ".tmp"
+	errWrite := ioutil.WriteFile(tmp, data, 0600)
+	if errWrite != nil {
+		return fmt.Errorf("failed to write status to %s: %v", tmp, errWrite)
+	}
+	errRename := os.Rename(tmp, status.path)
+	if errRename != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("failed to move status to %s: %v", status.path, errRename)
+	}
+	return nil
+}
+
+// Remove removes the status file.
+func (status *Status) Remove() error {
+	errRemove := os.Remove(status.path)
+	if errRemove != nil && !os.IsNotExist(errRemove) {
+		return fmt.Errorf("failed to remove %s: %v", status.path, errRemove)
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+package internal
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"syscall"
+	"testing"
+)
+
+// withRuntimeDir runs the given test with a temporary runtime directory.
+func withRuntimeDir(t *testing.T, test func()) {
+	dir, errDir := ioutil.TempDir("", "goyammer-test")
+	if errDir != nil {
+		t.Fatal(errDir)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	withEnv(map[string]string{"XDG_RUNTIME_DIR": dir}, test)
+}
+
+func TestLockAccount(t *testing.T) {
+	withRuntimeDir(t, func() {
+		if pid, err := PollerPid(DefaultAccount); err != nil || pid != 0 {
+			t.Errorf("PollerPid() without poller = %d, %v, want 0", pid, err)
+		}
+
+		lock, errLock := LockAccount(DefaultAccount)
+		if errLock != nil {
+			t.Fatalf("LockAccount() failed: %v", errLock)
+		}
+		if pid, err := PollerPid(DefaultAccount); err != nil || pid != os.Getpid() {
+			t.Errorf("PollerPid() = %d, %v, want %d", pid, err, os.Getpid())
+		}
+		if accounts, err := PolledAccounts(); err != nil || !reflect.DeepEqual(accounts, []string{DefaultAccount}) {
+			t.Errorf("PolledAccounts() = %v, %v, want [%s]", accounts, err, DefaultAccount)
+		}
+
+		// a second poller of the account is refused, other accounts are fine
+		_, errSecond := LockAccount(DefaultAccount)
+		if running, ok := errSecond.(*RunningError); !ok || running.Pid != os.Getpid() {
+			t.Errorf("LockAccount() of a locked account returned %v, want RunningError", errSecond)
+		}
+		other, errOther := LockAccount("partner")
+		if errOther != nil {
+			t.Fatalf("LockAccount() of another account failed: %v", errOther)
+		}
+		_ = other.Release()
+
+		if err := lock.Release(); err != nil {
+			t.Errorf("Release() failed: %v", err)
+		}
+		if pid, err := PollerPid(DefaultAccount); err != nil || pid != 0 {
+			t.Errorf("PollerPid() after Release() = %d, %v, want 0", pid, err)
+		}
+	})
+}
+
+func TestPollerPid_stale(t *testing.T) {
+	withRuntimeDir(t, func() {
+
+		// the pid file of a poller which died is not locked
+		_ = os.MkdirAll(RuntimeDir(), 0700)
+		if err := ioutil.WriteFile(PidPath(DefaultAccount), []byte("1\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if pid, err := PollerPid(DefaultAccount); err != nil || pid != 0 {
+			t.Errorf("PollerPid() of a stale pid file = %d, %v, want 0", pid, err)
+		}
+		lock, errLock := LockAccount(DefaultAccount)
+		if errLock != nil {
+			t.Fatalf("LockAccount() with a stale pid file failed: %v", errLock)
+		}
+		_ = lock.Release()
+	})
+}
+
+func TestPollerPid_starting(t *testing.T) {
+	withRuntimeDir(t, func() {
+
+		// a poller which has locked the pid file but not yet written it
+		_ = os.MkdirAll(RuntimeDir(), 0700)
+		file, errOpen := os.OpenFile(PidPath(DefaultAccount), os.O_RDWR|os.O_CREATE, 0600)
+		if errOpen != nil {
+			t.Fatal(errOpen)
+		}
+		defer func() {
+			_ = file.Close()
+		}()
+		if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := PollerPid(DefaultAccount); err == nil {
+			t.Errorf("PollerPid() of a starting poller succeeded, want StartingError")
+		} else if _, ok := err.(*StartingError); !ok {
+			t.Errorf("PollerPid() of a starting poller returned %v, want StartingError", err)
+		}
+		if accounts, err := PolledAccounts(); err != nil || !reflect.DeepEqual(accounts, []string{DefaultAccount}) {
+			t.Errorf("PolledAccounts() = %v, %v, want [%s]", accounts, err, DefaultAccount)
+		}
+	})
+}
+
+func TestLockAccount_runtimeDir(t *testing.T) {
+	tests := []struct {
+		name    string
+		setup   func(dir string) error
+		wantErr bool
+	}{
+		{name: "created", setup: func(dir string) error { return nil }},
+		{name: "private", setup: func(dir string) error { return os.Mkdir(dir, 0700) }},
+		{name: "readable by others", setup: func(dir string) error { return os.Mkdir(dir, 0755) }, wantErr: true},
+		{name: "symlink", setup: func(dir string) error { return os.Symlink(os.TempDir(), dir) }, wantErr: true},
+		{name: "file", setup: func(dir string) error { return ioutil.WriteFile(dir, nil, 0600) }, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withRuntimeDir(t, func() {
+				if err := tt.setup(RuntimeDir()); err != nil {
+					t.Fatal(err)
+				}
+				lock, err := LockAccount(DefaultAccount)
+				if (err != nil) != tt.wantErr {
+					t.Fatalf("LockAccount() error = %v, wantErr %v", err, tt.wantErr)
+				}
+				if err == nil {
+					_ = lock.Release()
+					return
+				}
+				if _, errPid := PollerPid(DefaultAccount); errPid == nil {
+					t.Errorf("PollerPid() in an insecure directory succeeded, want error")
+				}
+			})
+		})
+	}
+}
+
+func TestStatus(t *testing.T) {
+	withRuntimeDir(t, func() {
+		_ = os.MkdirAll(RuntimeDir(), 0700)
+		status := NewStatus(DefaultAccount)
+		status.Network = "network"
+		status.Groups = []string{"a", "b"}
+		status.Polled(nil)
+		status.Polled(errors.New("failed"))
+		if err := status.Save(); err != nil {
+			t.Fatalf("Save() failed: %v", err)
+		}
+
+		read, errRead := ReadStatus(DefaultAccount)
+		if errRead != nil {
+			t.Fatalf("ReadStatus() failed: %v", errRead)
+		}
+		if read.Pid != os.Getpid() || read.Network != "network" || !reflect.DeepEqual(read.Groups, status.Groups) ||
+			read.LastPoll == nil || read.LastError != "failed" || read.LastErrorTime == nil {
+			t.Errorf("ReadStatus() = %+v, want %+v", read, status)
+		}
+
+		if err := status.Remove(); err != nil {
+			t.Errorf("Remove() failed: %v", err)
+		}
+		if _, err := ReadStatus(DefaultAccount); err == nil {
+			t.Errorf("ReadStatus() after Remove() succeeded, want error")
+		}
+	})
+}
@@ -0,0 +1,92 @@
+package internal
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// groupPattern matches groups by ID, exact name or (if enclosed in slashes) regular expression on the name.
+type groupPattern struct {
+	spec  string
+	id    *int64
+	regex *regexp.Regexp
+}
+
+// parseGroupPattern parses a group pattern: an ID (e.g. "42"; -1 is the private group), a regular expression
+// enclosed in slashes (e.g. "/^Team/") or an exact name.
+func parseGroupPattern(spec string) (groupPattern, error) {
+	pattern := groupPattern{spec: spec}
+	if id, errId := strconv.ParseInt(spec, 10, 64); errId == nil {
+		pattern.id = &id
+	} else if len(spec) > 1 && strings.HasPrefix(spec, "/") && strings.HasSuffix(spec, "/") {
+		regex, errRegex := regexp.Compile(spec[1 : len(spec)-1])
+		if errRegex != nil {
+			return pattern, fmt.Errorf("invalid group pattern '%s': %v", spec, errRegex)
+		}
+		pattern.regex = regex
+	}
+	return pattern, nil
+}
+
+// matches returns whether the pattern matches the given group.
+func (pattern groupPattern) matches(group YammerGroup) bool {
+	switch {
+	case pattern.id != nil:
+		return *pattern.id == group.ID
+	case pattern.regex != nil:
+		return pattern.regex.MatchString(group.FullName)
+	default:
+		return pattern.spec == group.FullName
+	}
+}
+
+// GroupFilter selects the groups to poll: a group is selected if it matches any include pattern (or there are
+// none) and does not match any exclude pattern.
+type GroupFilter struct {
+	include []groupPattern
+	exclude []groupPattern
+}
+
+// NewGroupFilter returns a filter for the given include and exclude patterns (see parseGroupPattern).
+func NewGroupFilter(include []string, exclude []string) (*GroupFilter, error) {
+	filter := &GroupFilter{}
+	for _, spec := range include {
+		pattern, errPattern := parseGroupPattern(spec)
+		if errPattern != nil {
+			return nil, errPattern
+		}
+		filter.include = append(filter.include, pattern)
+	}
+	for _, spec := range exclude {
+		pattern, errPattern := parseGroupPattern(spec)
+		if errPattern != nil {
+			return nil, errPattern
+		}
+		filter.exclude = append(filter.exclude, pattern)
+	}
+	return filter, nil
+}
+
+// Select returns whether the given group is selected and, if not, the reason why.
+func (filter *GroupFilter) Select(group YammerGroup) (bool, string) {
+	if len(filter.include) > 0 {
+		included := false
+		for _, pattern := range filter.include {
+			if pattern.matches(group) {
+				included = true
+				break
+			}
+		}
+		if !included {
+			return false, "not included"
+		}
+	}
+	for _, pattern := range filter.exclude {
+		if pattern.matches(group) {
+			return false, fmt.Sprintf("excluded by '%s'", pattern.spec)
+		}
+	}
+	return true, ""
+}
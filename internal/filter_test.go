@@ -0,0 +1,50 @@
+package internal
+
+import "testing"
+
+func TestGroupFilter_Select(t *testing.T) {
+	groups := []YammerGroup{
+		{ID: 1, FullName: "All Company"},
+		{ID: 2, FullName: "Team Alpha"},
+		{ID: 3, FullName: "Team Beta"},
+		{ID: -1, FullName: "Private"},
+	}
+	tests := []struct {
+		name    string
+		include []string
+		exclude []string
+		want    []int64
+	}{
+		{name: "all", want: []int64{1, 2, 3, -1}},
+		{name: "exclude by name", exclude: []string{"All Company"}, want: []int64{2, 3, -1}},
+		{name: "exclude by id", exclude: []string{"-1", "2"}, want: []int64{1, 3}},
+		{name: "include by regex", include: []string{"/^Team/"}, want: []int64{2, 3}},
+		{name: "include and exclude", include: []string{"/^Team/", "Private"}, exclude: []string{"/Beta$/"}, want: []int64{2, -1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter, err := NewGroupFilter(tt.include, tt.exclude)
+			if err != nil {
+				t.Fatalf("NewGroupFilter() error = %v", err)
+			}
+			var got []int64
+			for _, group := range groups {
+				if selected, _ := filter.Select(group); selected {
+					got = append(got, group.ID)
+				}
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("Select() selected %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("Select() selected %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+
+	if _, err := NewGroupFilter([]string{"/[/"}, nil); err == nil {
+		t.Error("NewGroupFilter() expected error for invalid regular expression")
+	}
+}
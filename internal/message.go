@@ -1,19 +1,32 @@
 package internal
 
 import (
+	"context"
 	"fmt"
+	"github.com/rs/zerolog/log"
 	"strconv"
+	"sync"
+	"time"
 )
 
+// DefaultMaxPages is the default maximum number of pages fetched per group and poll.
+const DefaultMaxPages = 10
+
 // Message is the data structure to represent a set of messages.
 type Message struct {
 	YammerMessage
 }
 
-// Messages is the data structure to represent all messages.
+// Messages is the data structure to represent all messages. It is safe for concurrent use.
 type Messages struct {
 	client *Client
 
+	// guards the maps below
+	mutex sync.Mutex
+
+	// serializes fetching new messages by group id
+	groupLocks map[int64]*sync.Mutex
+
 	// all messages by message id
 	cache map[int64]*Message
 
@@ -22,20 +35,89 @@ type Messages struct {
 
 	// id of the latest message by group id (-1 indicates private messages)
 	latest map[int64]int64
+
+	// poll interval requested by the server by group id (-1 indicates private messages)
+	requested map[int64]time.Duration
+
+	// MaxPages is the maximum number of pages fetched per group and poll (older new messages are dropped). Use
+	// SetMaxPages while polling.
+	MaxPages int
 }
 
 // NewMessages returns a new Messages object.
 func NewMessages(client *Client) *Messages {
 	return &Messages{
 		client:       client,
+		groupLocks:   make(map[int64]*sync.Mutex),
 		cache:        make(map[int64]*Message),
 		messageLists: make(map[int64][]*Message),
 		latest:       make(map[int64]int64),
+		requested:    make(map[int64]time.Duration),
+		MaxPages:     DefaultMaxPages,
+	}
+}
+
+// GetLatest returns the id of the latest message seen for the given group and whether there is one.
+func (messages *Messages) GetLatest(groupId int64) (int64, bool) {
+	messages.mutex.Lock()
+	defer messages.mutex.Unlock()
+	latest, ok := messages.latest[groupId]
+	return latest, ok
+}
+
+// SetLatest sets the id of the latest message seen for the given group (e.g. to resume from a persisted state).
+func (messages *Messages) SetLatest(groupId int64, messageId int64) {
+	messages.mutex.Lock()
+	defer messages.mutex.Unlock()
+	messages.latest[groupId] = messageId
+}
+
+// Forget drops everything known about the given group (e.g. after the user left it).
+func (messages *Messages) Forget(groupId int64) {
+	messages.mutex.Lock()
+	defer messages.mutex.Unlock()
+	for _, message := range messages.messageLists[groupId] {
+		delete(messages.cache, message.ID)
+	}
+	delete(messages.messageLists, groupId)
+	delete(messages.latest, groupId)
+	delete(messages.requested, groupId)
+}
+
+// GetRequestedPollInterval returns the poll interval the server requested with the last response for the given group
+// (0 if none).
+func (messages *Messages) GetRequestedPollInterval(groupId int64) time.Duration {
+	messages.mutex.Lock()
+	defer messages.mutex.Unlock()
+	return messages.requested[groupId]
+}
+
+// SetMaxPages changes the maximum number of pages fetched per group and poll.
+func (messages *Messages) SetMaxPages(maxPages int) {
+	messages.mutex.Lock()
+	defer messages.mutex.Unlock()
+	messages.MaxPages = maxPages
+}
+
+// groupLock returns the lock serializing fetches for the given group.
+func (messages *Messages) groupLock(groupId int64) *sync.Mutex {
+	messages.mutex.Lock()
+	defer messages.mutex.Unlock()
+	lock, ok := messages.groupLocks[groupId]
+	if !ok {
+		lock = &sync.Mutex{}
+		messages.groupLocks[groupId] = lock
 	}
+	return lock
 }
 
 // GetNewMessages returns new messages for the given group (in chronological order).
-func (messages *Messages) GetNewMessages(groupId int64) ([]*Message, error) {
+func (messages *Messages) GetNewMessages(ctx context.Context, groupId int64) ([]*Message, error) {
+
+	// only one fetch per group at a time (while different groups may be fetched concurrently)
+	lock := messages.groupLock(groupId)
+	lock.Lock()
+	defer lock.Unlock()
 
 	// construct path (private by default, for a particular group if groupId is !-1)
 	path := "messages/private.json"
@@ -44,13 +126,14 @@ func (messages *Messages) GetNewMessages(groupId int64) ([]*Message, error) {
 	}
 
 	// if we don't have a latest id, get one and return
-	if _, ok := messages.latest[groupId]; !ok {
+	latest, ok := messages.GetLatest(groupId)
+	if !ok {
 
 		// construct parameters
 		params := map[string]string{"limit": "1"}
 
 		// construct request
-		req, errReq := messages.client.newRequest("GET", path, params, nil)
+		req, errReq := messages.client.newRequest(ctx, "GET", path, params, nil)
 		if errReq != nil {
 			return nil, fmt.Errorf("failed to construct latest request for group %d: %v", groupId, errReq)
 		}
@@ -62,39 +145,85 @@ func (messages *Messages) GetNewMessages(groupId int64) ([]*Message, error) {
 			return nil, fmt.Errorf("failed to do latest request for group %d: %v", groupId, errDo)
 		}
 
-		// set the latest
-		messages.latest[groupId] = ymr.Messages[0].ID
+		// set the latest (unless the group has no messages at all)
+		messages.mutex.Lock()
+		messages.requested[groupId] = time.Duration(ymr.Meta.RequestedPollInterval) * time.Second
+		if len(ymr.Messages) > 0 {
+			messages.latest[groupId] = ymr.Messages[0].ID
+		}
+		messages.mutex.Unlock()
 		return []*Message{}, nil
 	}
 
-	// construct parameters
-	params := map[string]string{"newer_than": strconv.FormatInt(messages.latest[groupId], 10)}
+	// walk the pages from newest to oldest until reaching the latest message (or the maximum number of pages)
+	messages.mutex.Lock()
+	maxPages := messages.MaxPages
+	messages.mutex.Unlock()
+	var yammerMessages []YammerMessage
+	var olderThan int64
+	for page := 0; ; page++ {
+
+		// stop if the page limit has been reached
+		if page >= maxPages {
+			log.Warn().Msg(fmt.Sprintf("stopped fetching messages for group %d after %d pages, older new messages were dropped", groupId, page))
+			break
+		}
 
-	// construct request
-	req, errReq := messages.client.newRequest("GET", path, params, nil)
-	if errReq != nil {
-		return nil, fmt.Errorf("failed to construct messages request for group %d: %v", groupId, errReq)
-	}
+		// construct parameters
+		params := map[string]string{"newer_than": strconv.FormatInt(latest, 10)}
+		if olderThan != 0 {
+			params["older_than"] = strconv.FormatInt(olderThan, 10)
+		}
+
+		// construct request
+		req, errReq := messages.client.newRequest(ctx, "GET", path, params, nil)
+		if errReq != nil {
+			return nil, fmt.Errorf("failed to construct messages request for group %d: %v", groupId, errReq)
+		}
 
-	// do request and parse response
-	var ymr YammerMessageResponse
-	_, errDo := messages.client.do(req, &ymr)
-	if errDo != nil {
-		return nil, fmt.Errorf("failed to do latest request for group %d: %v", groupId, errDo)
+		// do request and parse response
+		var ymr YammerMessageResponse
+		_, errDo := messages.client.do(req, &ymr)
+		if errDo != nil {
+			return nil, fmt.Errorf("failed to do messages request for group %d: %v", groupId, errDo)
+		}
+		requested := time.Duration(ymr.Meta.RequestedPollInterval) * time.Second
+		messages.mutex.Lock()
+		messages.requested[groupId] = requested
+		messages.mutex.Unlock()
+
+		// collect messages newer than the latest one
+		reached := !ymr.Meta.OlderAvailable || len(ymr.Messages) < 1
+		for _, yammerMessage := range ymr.Messages {
+			if yammerMessage.ID <= latest {
+				reached = true
+				break
+			}
+			yammerMessages = append(yammerMessages, yammerMessage)
+		}
+		if reached {
+			break
+		}
+
+		// continue with messages older than the oldest one of this page
+		olderThan = ymr.Messages[len(ymr.Messages)-1].ID
 	}
 
 	// count messages
-	messageCount := len(ymr.Messages)
+	messageCount := len(yammerMessages)
 
 	// return if no new messages
-	if len(ymr.Messages) < 1 {
+	if messageCount < 1 {
 		return []*Message{}, nil
 	}
 
+	messages.mutex.Lock()
+	defer messages.mutex.Unlock()
+
 	// extract messages ids and cache messages
 	var newMessages []*Message = make([]*Message, int(messageCount))
 	for i := 0; i < messageCount; i++ {
-		yammerMessage := ymr.Messages[i]
+		yammerMessage := yammerMessages[i]
 
 		message := &Message{yammerMessage}
 
@@ -106,7 +235,7 @@ func (messages *Messages) GetNewMessages(groupId int64) ([]*Message, error) {
 	}
 
 	// update latest id
-	messages.latest[groupId] = ymr.Messages[0].ID
+	messages.latest[groupId] = yammerMessages[0].ID
 
 	// update message lists
 	if messageList, ok := messages.messageLists[groupId]; ok {
@@ -0,0 +1,114 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// newMessageServer returns a fake API serving the messages with ids 1 to count in every group, newest first and in
+// pages of pageSize.
+func newMessageServer(count int64, pageSize int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		limit := pageSize
+		if query.Get("limit") != "" {
+			limit, _ = strconv.Atoi(query.Get("limit"))
+		}
+		newerThan, _ := strconv.ParseInt(query.Get("newer_than"), 10, 64)
+		olderThan := count + 1
+		if query.Get("older_than") != "" {
+			olderThan, _ = strconv.ParseInt(query.Get("older_than"), 10, 64)
+		}
+
+		var ymr YammerMessageResponse
+		for id := olderThan - 1; id > newerThan; id-- {
+			if len(ymr.Messages) == limit {
+				ymr.Meta.OlderAvailable = true
+				break
+			}
+			ymr.Messages = append(ymr.Messages, YammerMessage{ID: id, Body: YammerMessageBody{Plain: fmt.Sprint(id)}})
+		}
+		ymr.Meta.RequestedPollInterval = 30
+		_ = json.NewEncoder(w).Encode(ymr)
+	}))
+}
+
+func TestMessages_GetNewMessages(t *testing.T) {
+	tests := []struct {
+		name     string
+		latest   int64
+		maxPages int
+		want     []int64
+	}{
+		{name: "single page", latest: 8, maxPages: 10, want: []int64{9, 10}},
+		{name: "multiple pages", latest: 2, maxPages: 10, want: []int64{3, 4, 5, 6, 7, 8, 9, 10}},
+		{name: "truncated", latest: 2, maxPages: 2, want: []int64{5, 6, 7, 8, 9, 10}},
+		{name: "nothing new", latest: 10, maxPages: 10, want: []int64{}},
+	}
+	server := newMessageServer(10, 3)
+	defer server.Close()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			messages := NewMessages(newTestClient(server))
+			messages.MaxPages = tt.maxPages
+			messages.SetLatest(42, tt.latest)
+
+			got, err := messages.GetNewMessages(context.Background(), 42)
+			if err != nil {
+				t.Fatalf("GetNewMessages() error = %v", err)
+			}
+			ids := make([]int64, len(got))
+			for i, message := range got {
+				ids[i] = message.ID
+			}
+			if fmt.Sprint(ids) != fmt.Sprint(tt.want) {
+				t.Errorf("GetNewMessages() = %v, want %v", ids, tt.want)
+			}
+			if latest, _ := messages.GetLatest(42); latest != 10 {
+				t.Errorf("GetLatest() = %d, want 10", latest)
+			}
+		})
+	}
+}
+
+func TestMessages_GetNewMessages_concurrent(t *testing.T) {
+	server := newMessageServer(10, 20)
+	defer server.Close()
+
+	messages := NewMessages(newTestClient(server))
+	for gid := int64(0); gid < 8; gid++ {
+		messages.SetLatest(gid, 5)
+	}
+
+	var wg sync.WaitGroup
+	counts := make([]int, 16)
+	for i := range counts {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			newMessages, err := messages.GetNewMessages(context.Background(), int64(i%8))
+			if err != nil {
+				t.Errorf("GetNewMessages() error = %v", err)
+			}
+			counts[i] = len(newMessages)
+		}(i)
+	}
+	wg.Wait()
+
+	// per group, one of the two concurrent fetches got the new messages and the other one none
+	for gid := 0; gid < 8; gid++ {
+		if counts[gid]+counts[gid+8] != 5 {
+			t.Errorf("group %d got %d + %d new messages, want 5 in total", gid, counts[gid], counts[gid+8])
+		}
+		if requested := messages.GetRequestedPollInterval(int64(gid)); requested.Seconds() != 30 {
+			t.Errorf("GetRequestedPollInterval() = %s, want 30s", requested)
+		}
+	}
+}
@@ -3,6 +3,9 @@ package internal
 import (
 	"fmt"
 	"os"
+	"os/exec"
+
+	"github.com/rs/zerolog/log"
 )
 
 func ElipseMe(s string, length int, pad bool) string {
@@ -26,3 +29,11 @@ func FileExists(path string) bool {
 	}
 	return true
 }
+
+// OpenBrowser opens the given URL in the browser.
+func OpenBrowser(url string) {
+	errOpen := exec.Command("xdg-open", url).Start()
+	if errOpen != nil {
+		log.Warn().Err(errOpen).Msg(fmt.Sprintf("failed to open %s", url))
+	}
+}
@@ -1,11 +1,159 @@
 package internal
 
 import (
-	"github.com/mqu/go-notify"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
 )
 
-func Notify(summary, message, icon string) {
-	n := notify.NotificationNew(summary, message, icon)
-	//n.SetUrgency(notify.NOTIFY_URGENCY_CRITICAL)
-	n.Show()
+// Notifier backends.
+const (
+	NotifierLibnotify = "libnotify"
+	NotifierDBus      = "dbus"
+	NotifierJSON      = "json"
+	NotifierNone      = "none"
+)
+
+// Urgency is the urgency level of a notification.
+type Urgency int
+
+const (
+	UrgencyLow      Urgency = 0
+	UrgencyNormal   Urgency = 1
+	UrgencyCritical Urgency = 2
+)
+
+func (urgency Urgency) String() string {
+	switch urgency {
+	case UrgencyLow:
+		return "low"
+	case UrgencyCritical:
+		return "critical"
+	default:
+		return "normal"
+	}
+}
+
+// Action is an action offered to the user with a notification (the key "default" is invoked by clicking the
+// notification itself).
+type Action struct {
+	Key   string
+	Label string
+}
+
+// Notification is the data structure to represent a desktop notification. Backends may ignore the fields they do not
+// support.
+type Notification struct {
+	Summary string
+	Body    string
+
+	// Icon is an icon file or name.
+	Icon string
+
+	// Image is raw (JPEG or PNG) image data to show instead of the icon.
+	Image []byte
+
+	Urgency Urgency
+
+	// Tag identifies notifications replacing each other (e.g. all of one group), empty for none.
+	Tag string
+
+	// Actions are the actions offered to the user and OnAction is called with the key of the action invoked.
+	Actions  []Action
+	OnAction func(key string)
+
+	// OnClosed is called when the notification has been closed.
+	OnClosed func()
+}
+
+// Notifier sends notifications.
+type Notifier interface {
+	Notify(notification Notification) error
+}
+
+// Capabilities are the optional features of a notifier.
+type Capabilities struct {
+
+	// Images tells whether raw image data is shown (so that images need not be written to files).
+	Images bool
+
+	// Actions tells whether actions are offered and OnAction and OnClosed are called.
+	Actions bool
+
+	// Tags tells whether notifications with the same tag replace each other.
+	Tags bool
+}
+
+// capableNotifier is implemented by notifiers supporting optional features.
+type capableNotifier interface {
+	capabilities() Capabilities
+}
+
+// CapabilitiesOf returns the optional features supported by the given notifier.
+func CapabilitiesOf(notifier Notifier) Capabilities {
+	if capable, ok := notifier.(capableNotifier); ok {
+		return capable.capabilities()
+	}
+	return Capabilities{}
+}
+
+// NewNotifier returns the notifier for the given backend (one of "libnotify", "dbus", "json" or "none").
+func NewNotifier(backend string) (Notifier, error) {
+	switch backend {
+	case NotifierLibnotify:
+		return newLibnotifyNotifier()
+	case NotifierDBus:
+		return NewDBusNotifier()
+	case NotifierJSON:
+		return NewJSONNotifier(os.Stdout), nil
+	case NotifierNone:
+		return noneNotifier{}, nil
+	default:
+		return nil, fmt.Errorf("unknown notifier '%s', expected one of libnotify, dbus, json or none", backend)
+	}
+}
+
+// noneNotifier discards all notifications.
+type noneNotifier struct{}
+
+func (noneNotifier) Notify(Notification) error {
+	return nil
+}
+
+// jsonNotifier writes notifications as JSON objects (one per line).
+type jsonNotifier struct {
+	mutex  sync.Mutex
+	writer io.Writer
+}
+
+// NewJSONNotifier returns a notifier writing notifications to the given writer as JSON objects (one per line).
+func NewJSONNotifier(writer io.Writer) Notifier {
+	return &jsonNotifier{writer: writer}
+}
+
+func (notifier *jsonNotifier) Notify(notification Notification) error {
+	data, errJson := json.Marshal(struct {
+		Time    time.Time `json:"time"`
+		Summary string    `json:"summary"`
+		Body    string    `json:"body"`
+		Icon    string    `json:"icon,omitempty"`
+		Urgency string    `json:"urgency"`
+	}{
+		Time:    time.Now(),
+		Summary: notification.Summary,
+		Body:    notification.Body,
+		Icon:    notification.Icon,
+		Urgency: notification.Urgency.String(),
+	})
+	if errJson != nil {
+		return fmt.Errorf("failed to serialize notification: %v", errJson)
+	}
+
+	notifier.mutex.Lock()
+	defer notifier.mutex.Unlock()
+	_, errWrite := notifier.writer.Write(append(data, '\n'))
+	return errWrite
 }
@@ -0,0 +1,230 @@
+package internal
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/draw"
+	_ "image/jpeg" // mug shots are JPEGs
+	_ "image/png"  // the logo is a PNG
+	"sync"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	notificationsName = "org.freedesktop.Notifications"
+	notificationsPath = "/org/freedesktop/Notifications"
+
+	// the key of the action invoked when clicking the notification itself
+	defaultActionKey = "default"
+)
+
+// notificationBus is the part of org.freedesktop.Notifications used by the notifier (replaced by a stand-in in tests).
+type notificationBus interface {
+
+	// notify sends a notification (replacing the one with the given id unless 0) and returns its id.
+	notify(replacesId uint32, icon, summary, body string, actions []string, hints map[string]dbus.Variant) (uint32, error)
+
+	// signals returns the channel receiving the ActionInvoked and NotificationClosed signals.
+	signals() <-chan *dbus.Signal
+}
+
+// sessionNotificationBus is org.freedesktop.Notifications on the session bus.
+type sessionNotificationBus struct {
+	object  dbus.BusObject
+	signalC chan *dbus.Signal
+}
+
+func (bus *sessionNotificationBus) notify(replacesId uint32, icon, summary, body string, actions []string, hints map[string]dbus.Variant) (uint32, error) {
+	call := bus.object.Call(notificationsName+".Notify", 0,
+		"goyammer", replacesId, icon, summary, body, actions, hints, int32(-1))
+	var id uint32
+	errCall := call.Store(&id)
+	return id, errCall
+}
+
+func (bus *sessionNotificationBus) signals() <-chan *dbus.Signal {
+	return bus.signalC
+}
+
+// dbusImage is the (iiibiiay) structure of the image-data hint.
+type dbusImage struct {
+	Width         int32
+	Height        int32
+	RowStride     int32
+	HasAlpha      bool
+	BitsPerSample int32
+	Channels      int32
+	Data          []byte
+}
+
+// dbusNotifier sends notifications to the notification daemon via D-Bus. Notifications with the same tag replace
+// each other and actions invoked by the user are dispatched to the OnAction callback of the notification.
+type dbusNotifier struct {
+	bus notificationBus
+
+	// serializes sending notifications (so that notifications with the same tag replace each other in turn rather than
+	// the same previous one)
+	sending sync.Mutex
+
+	// guards the maps below
+	mutex sync.Mutex
+
+	// the notifications shown by id
+	shown map[uint32]Notification
+
+	// the id of the notification shown by tag
+	tags map[string]uint32
+}
+
+// NewDBusNotifier returns a notifier talking to org.freedesktop.Notifications on the session bus.
+func NewDBusNotifier() (Notifier, error) {
+	conn, errConn := dbus.SessionBus()
+	if errConn != nil {
+		return nil, fmt.Errorf("failed to connect to session bus: %v", errConn)
+	}
+
+	// subscribe to the signals of the notification daemon
+	errMatch := conn.AddMatchSignal(
+		dbus.WithMatchInterface(notificationsName),
+		dbus.WithMatchObjectPath(notificationsPath))
+	if errMatch != nil {
+		return nil, fmt.Errorf("failed to subscribe to notification signals: %v", errMatch)
+	}
+	signals := make(chan *dbus.Signal, 16)
+	conn.Signal(signals)
+
+	bus := &sessionNotificationBus{
+		object:  conn.Object(notificationsName, notificationsPath),
+		signalC: signals,
+	}
+	return newDBusNotifier(bus), nil
+}
+
+// newDBusNotifier returns a notifier using the given bus and starts dispatching its signals.
+func newDBusNotifier(bus notificationBus) *dbusNotifier {
+	notifier := &dbusNotifier{
+		bus:   bus,
+		shown: make(map[uint32]Notification),
+		tags:  make(map[string]uint32),
+	}
+	go notifier.dispatch()
+	return notifier
+}
+
+func (notifier *dbusNotifier) capabilities() Capabilities {
+	return Capabilities{Images: true, Actions: true, Tags: true}
+}
+
+func (notifier *dbusNotifier) Notify(notification Notification) error {
+
+	// actions are given as a flat list of key and label pairs
+	var actions []string
+	for _, action := range notification.Actions {
+		actions = append(actions, action.Key, action.Label)
+	}
+
+	hints := map[string]dbus.Variant{
+		"urgency": dbus.MakeVariant(byte(notification.Urgency)),
+	}
+	icon := notification.Icon
+	if len(notification.Image) > 0 {
+		img, errImage := toDBusImage(notification.Image)
+		if errImage != nil {
+			log.Debug().Err(errImage).Msg("failed to convert notification image")
+		} else {
+			hints["image-data"] = dbus.MakeVariant(img)
+			icon = ""
+		}
+	}
+
+	// replace the notification with the same tag (if still shown)
+	notifier.sending.Lock()
+	defer notifier.sending.Unlock()
+	notifier.mutex.Lock()
+	replacesId := uint32(0)
+	if notification.Tag != "" {
+		replacesId = notifier.tags[notification.Tag]
+	}
+	notifier.mutex.Unlock()
+
+	id, errNotify := notifier.bus.notify(replacesId, icon, notification.Summary, notification.Body, actions, hints)
+	if errNotify != nil {
+		return fmt.Errorf("failed to send notification: %v", errNotify)
+	}
+
+	notifier.mutex.Lock()
+	defer notifier.mutex.Unlock()
+	if replacesId != 0 && replacesId != id {
+		delete(notifier.shown, replacesId)
+	}
+	notifier.shown[id] = notification
+	if notification.Tag != "" {
+		notifier.tags[notification.Tag] = id
+	}
+
+	return nil
+}
+
+// dispatch handles the ActionInvoked and NotificationClosed signals of the notification daemon.
+func (notifier *dbusNotifier) dispatch() {
+	for signal := range notifier.bus.signals() {
+		switch signal.Name {
+		case notificationsName + ".ActionInvoked":
+			if len(signal.Body) < 2 {
+				continue
+			}
+			id, okId := signal.Body[0].(uint32)
+			key, okKey := signal.Body[1].(string)
+			if !okId || !okKey {
+				continue
+			}
+			notifier.mutex.Lock()
+			notification, ok := notifier.shown[id]
+			notifier.mutex.Unlock()
+			if ok && notification.OnAction != nil {
+				notification.OnAction(key)
+			}
+		case notificationsName + ".NotificationClosed":
+			if len(signal.Body) < 1 {
+				continue
+			}
+			id, okId := signal.Body[0].(uint32)
+			if !okId {
+				continue
+			}
+			notifier.mutex.Lock()
+			notification, ok := notifier.shown[id]
+			delete(notifier.shown, id)
+			if ok && notification.Tag != "" && notifier.tags[notification.Tag] == id {
+				delete(notifier.tags, notification.Tag)
+			}
+			notifier.mutex.Unlock()
+			if ok && notification.OnClosed != nil {
+				notification.OnClosed()
+			}
+		}
+	}
+}
+
+// toDBusImage decodes the given (JPEG or PNG) image into the structure of the image-data hint.
+func toDBusImage(data []byte) (dbusImage, error) {
+	img, _, errDecode := image.Decode(bytes.NewReader(data))
+	if errDecode != nil {
+		return dbusImage{}, errDecode
+	}
+	bounds := img.Bounds()
+	rgba := image.NewNRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), img, bounds.Min, draw.Src)
+	return dbusImage{
+		Width:         int32(bounds.Dx()),
+		Height:        int32(bounds.Dy()),
+		RowStride:     int32(rgba.Stride),
+		HasAlpha:      true,
+		BitsPerSample: 8,
+		Channels:      4,
+		Data:          rgba.Pix,
+	}, nil
+}
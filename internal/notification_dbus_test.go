@@ -0,0 +1,167 @@
+package internal
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// fakeNotificationBus stands in for org.freedesktop.Notifications on the session bus.
+type fakeNotificationBus struct {
+	mutex   sync.Mutex
+	nextId  uint32
+	calls   []fakeNotifyCall
+	signalC chan *dbus.Signal
+
+	// how long sending a notification takes
+	delay time.Duration
+}
+
+type fakeNotifyCall struct {
+	replacesId uint32
+	icon       string
+	summary    string
+	actions    []string
+	hints      map[string]dbus.Variant
+}
+
+func (bus *fakeNotificationBus) notify(replacesId uint32, icon, summary, body string, actions []string, hints map[string]dbus.Variant) (uint32, error) {
+	time.Sleep(bus.delay)
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+	bus.calls = append(bus.calls, fakeNotifyCall{replacesId, icon, summary, actions, hints})
+	if replacesId != 0 {
+		return replacesId, nil
+	}
+	bus.nextId++
+	return bus.nextId, nil
+}
+
+func (bus *fakeNotificationBus) signals() <-chan *dbus.Signal {
+	return bus.signalC
+}
+
+func (bus *fakeNotificationBus) lastCall() fakeNotifyCall {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+	return bus.calls[len(bus.calls)-1]
+}
+
+func TestDBusNotifier(t *testing.T) {
+	bus := &fakeNotificationBus{signalC: make(chan *dbus.Signal)}
+	defer close(bus.signalC)
+	notifier := newDBusNotifier(bus)
+
+	actions := make(chan string, 1)
+	closed := make(chan bool, 1)
+	notify := func(tag string) fakeNotifyCall {
+		err := notifier.Notify(Notification{
+			Summary:  tag,
+			Icon:     "logo",
+			Urgency:  UrgencyCritical,
+			Tag:      tag,
+			Actions:  []Action{{Key: "open", Label: "Open"}},
+			OnAction: func(key string) { actions <- key },
+			OnClosed: func() { closed <- true },
+		})
+		if err != nil {
+			t.Fatalf("Notify() error = %v", err)
+		}
+		return bus.lastCall()
+	}
+
+	// notifications of the same tag replace each other
+	if call := notify("g1"); call.replacesId != 0 || call.icon != "logo" || len(call.actions) != 2 {
+		t.Errorf("Notify() called %+v", call)
+	}
+	if call := notify("g1"); call.replacesId != 1 {
+		t.Errorf("Notify() replaces %d, want 1", call.replacesId)
+	}
+	if call := notify("g2"); call.replacesId != 0 || call.hints["urgency"].Value() != byte(UrgencyCritical) {
+		t.Errorf("Notify() called %+v", call)
+	}
+
+	// actions are dispatched to the notification
+	bus.signalC <- &dbus.Signal{Name: notificationsName + ".ActionInvoked", Body: []interface{}{uint32(1), "open"}}
+	select {
+	case key := <-actions:
+		if key != "open" {
+			t.Errorf("OnAction(%s), want open", key)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("OnAction not called")
+	}
+
+	// once closed, a notification is no longer replaced
+	bus.signalC <- &dbus.Signal{Name: notificationsName + ".NotificationClosed", Body: []interface{}{uint32(1), uint32(2)}}
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("OnClosed not called")
+	}
+	if call := notify("g1"); call.replacesId != 0 {
+		t.Errorf("Notify() replaces %d after close, want 0", call.replacesId)
+	}
+}
+
+func TestDBusNotifier_concurrent(t *testing.T) {
+	bus := &fakeNotificationBus{signalC: make(chan *dbus.Signal), delay: 10 * time.Millisecond}
+	defer close(bus.signalC)
+	notifier := newDBusNotifier(bus)
+
+	// notifications of the same tag sent at once replace each other in turn (i.e. only the first one is new)
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := notifier.Notify(Notification{Summary: "g1", Tag: "g1"}); err != nil {
+				t.Errorf("Notify() error = %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+	shown := 0
+	for _, call := range bus.calls {
+		if call.replacesId == 0 {
+			shown++
+		}
+	}
+	if shown != 1 || len(bus.calls) != 5 {
+		t.Errorf("Notify() showed %d of %d notifications as new, want 1 of 5", shown, len(bus.calls))
+	}
+}
+
+func TestDBusNotifier_image(t *testing.T) {
+	bus := &fakeNotificationBus{signalC: make(chan *dbus.Signal)}
+	defer close(bus.signalC)
+	notifier := newDBusNotifier(bus)
+
+	var buffer bytes.Buffer
+	if err := png.Encode(&buffer, image.NewNRGBA(image.Rect(0, 0, 3, 2))); err != nil {
+		t.Fatal(err)
+	}
+	if err := notifier.Notify(Notification{Summary: "mug", Icon: "logo", Image: buffer.Bytes()}); err != nil {
+		t.Fatalf("Notify() error = %v", err)
+	}
+
+	call := bus.lastCall()
+	img, ok := call.hints["image-data"].Value().(dbusImage)
+	if !ok || call.icon != "" {
+		t.Fatalf("Notify() called %+v, want image-data and no icon", call)
+	}
+	if img.Width != 3 || img.Height != 2 || img.RowStride != 12 || len(img.Data) != 24 {
+		t.Errorf("image-data = %dx%d (stride %d, %d bytes)", img.Width, img.Height, img.RowStride, len(img.Data))
+	}
+	if !CapabilitiesOf(notifier).Images || CapabilitiesOf(noneNotifier{}).Images {
+		t.Error("CapabilitiesOf() mismatch")
+	}
+}
@@ -0,0 +1,30 @@
+//go:build libnotify
+// +build libnotify
+
+package internal
+
+import (
+	"sync"
+
+	"github.com/mqu/go-notify"
+)
+
+// libnotifyInit makes sure libnotify is initialized once.
+var libnotifyInit sync.Once
+
+// libnotifyNotifier sends notifications via libnotify.
+type libnotifyNotifier struct{}
+
+func newLibnotifyNotifier() (Notifier, error) {
+	libnotifyInit.Do(func() {
+		notify.Init("goyammer")
+	})
+	return libnotifyNotifier{}, nil
+}
+
+func (libnotifyNotifier) Notify(notification Notification) error {
+	n := notify.NotificationNew(notification.Summary, notification.Body, notification.Icon)
+	n.SetUrgency(notify.NotifyUrgency(notification.Urgency))
+	n.Show()
+	return nil
+}
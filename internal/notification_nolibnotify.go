@@ -0,0 +1,12 @@
+//go:build !libnotify
+// +build !libnotify
+
+package internal
+
+import "fmt"
+
+// newLibnotifyNotifier fails as libnotify support (which requires cgo, libnotify and gtk) is only built with the
+// "libnotify" build tag.
+func newLibnotifyNotifier() (Notifier, error) {
+	return nil, fmt.Errorf("libnotify support is not built in (build with '-tags libnotify'), use the dbus notifier instead")
+}
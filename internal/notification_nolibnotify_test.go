@@ -0,0 +1,12 @@
+//go:build !libnotify
+// +build !libnotify
+
+package internal
+
+import "testing"
+
+func TestNewNotifier_libnotify(t *testing.T) {
+	if _, err := NewNotifier(NotifierLibnotify); err == nil {
+		t.Error("NewNotifier(libnotify) without libnotify support expected error")
+	}
+}
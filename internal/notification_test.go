@@ -0,0 +1,39 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestJSONNotifier_Notify(t *testing.T) {
+	var buffer bytes.Buffer
+	notifier := NewJSONNotifier(&buffer)
+	for _, summary := range []string{"first", "second"} {
+		err := notifier.Notify(Notification{Summary: summary, Body: "hello\nworld", Urgency: UrgencyCritical})
+		if err != nil {
+			t.Fatalf("Notify() error = %v", err)
+		}
+	}
+
+	decoder := json.NewDecoder(&buffer)
+	for _, want := range []string{"first", "second"} {
+		var got map[string]interface{}
+		if err := decoder.Decode(&got); err != nil {
+			t.Fatalf("failed to decode notification: %v", err)
+		}
+		if got["summary"] != want || got["body"] != "hello\nworld" || got["urgency"] != "critical" {
+			t.Errorf("Notify() wrote %v", got)
+		}
+	}
+}
+
+func TestNewNotifier(t *testing.T) {
+	if _, err := NewNotifier("carrier-pigeon"); err == nil {
+		t.Error("NewNotifier() expected error for unknown backend")
+	}
+	notifier, err := NewNotifier(NotifierNone)
+	if err != nil || notifier.Notify(Notification{Summary: "ignored"}) != nil {
+		t.Errorf("NewNotifier(none) = %v, %v", notifier, err)
+	}
+}
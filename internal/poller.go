@@ -0,0 +1,1257 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/seboghpub/goyammer/icon"
+)
+
+// PollOptions are the options of 'poll' (after applying the configuration file).
+type PollOptions struct {
+	Settings       Settings
+	Accounts       []string
+	Interval       time.Duration
+	Bounds         IntervalBounds
+	GroupIntervals map[string]IntervalBounds
+	Includes       []string
+	Excludes       []string
+	Filter         *GroupFilter
+	Backlog        uint
+	Concurrency    uint
+	RateLimit      uint
+	Timeout        time.Duration
+	MaxPages       uint
+	RefreshGroups  time.Duration
+	Notifier       string
+	TokenStore     string
+	Output         string
+	ClientID       string
+	Notify         bool
+	NotifyEach     bool
+}
+
+// PollerEnv is what the pollers of all accounts polled by the process share.
+type PollerEnv struct {
+
+	// the store holding the tokens
+	Store CredentialStore
+
+	Notifier Notifier
+
+	// the application logo (a file or an icon name)
+	Logo string
+
+	// whether running in the background (logging messages in full rather than abbreviated)
+	Background bool
+
+	// reported to by the poll loops (nil if the systemd watchdog is not enabled)
+	Watchdog *Watchdog
+}
+
+// Poller polls the groups of an account and notifies about new messages.
+type Poller struct {
+
+	// the account polled and its logger (prefixing lines with the account if there are several)
+	account string
+	log     zerolog.Logger
+	multi   bool
+
+	// the network of the account (known once the current user has been fetched)
+	network string
+
+	// the submenu of the account
+	tray *SystrayAccount
+
+	// the client (whose token is replaced when logging in again), the store holding the token and the client ID to log
+	// in with (if configured)
+	client   *Client
+	store    CredentialStore
+	clientId string
+
+	// whether polling stopped because the token has been rejected and whether logging in again is in progress
+	loginRequired bool
+	loggingIn     bool
+
+	notifier   Notifier
+	users      *Users
+	messages   *Messages
+	state      *State
+	logo       string
+	background bool
+
+	// the initial interval between requests for a group (and between attempts to get the current user)
+	interval time.Duration
+
+	// maximum number of missed messages to show per group after a restart
+	backlog uint
+
+	// groups whose latest id has been restored from the state and not been polled since
+	away map[int64]bool
+
+	// when to poll which group
+	scheduler *Scheduler
+
+	// poll interval bounds by group ID or name
+	groupIntervals map[string]IntervalBounds
+
+	// number of groups to fetch in parallel
+	concurrency uint
+
+	// whether to send notifications at all and whether to send one for every message (instead of one per poll)
+	notify     bool
+	notifyEach bool
+
+	// the configuration (after applying the profile)
+	settings Settings
+
+	// selects the groups to poll
+	filter *GroupFilter
+
+	// the polled groups by id
+	groups map[int64]YammerGroup
+
+	// interval between refreshes of the group membership (0 disables refreshing), the ticker triggering them, the
+	// channel receiving the refreshed group membership and whether a refresh is in progress
+	refreshGroups time.Duration
+	refreshTicker *time.Ticker
+	groupUpdates  chan groupUpdate
+	refreshing    bool
+
+	// the current user (known once fetched)
+	user *User
+
+	// the maximum number of requests per 30 seconds
+	rateLimit uint
+
+	// functions to run on the poll loop (e.g. reactions to notification actions)
+	events chan func()
+
+	// number of messages in the (still shown) notification by group id
+	unread map[int64]int
+
+	// groups muted at runtime
+	muted map[int64]bool
+
+	// what is reported by 'goyammer status'
+	status *Status
+
+	// the control socket (see 'goyammer ctl') and whether polling has been paused through it
+	control *ControlServer
+	paused  bool
+
+	// the latest messages received (for the "messages" command)
+	recent []ControlMessage
+
+	// the systemd watchdog and when to report to it (while polling)
+	watchdog  *Watchdog
+	keepalive <-chan time.Time
+
+	// cancelled on shutdown (stopping outstanding requests), done is closed once polling stopped
+	ctx  context.Context
+	done chan struct{}
+}
+
+// the number of messages kept for the "messages" command
+const recentMessages = 100
+
+// NewPoller returns the poller of the given account (with the given token), keeping its mug shots in the given
+// directory. It resumes from the poll progress of previous runs and stops polling once the given context is done.
+func NewPoller(ctx context.Context, env *PollerEnv, account string, token string, mugdir string, options *PollOptions) (*Poller, error) {
+
+	// load the poll progress of previous runs
+	state, errState := LoadState(StatePath(account))
+	if errState != nil {
+		return nil, fmt.Errorf("failed to load state of account %s: %v", account, errState)
+	}
+
+	client := NewClient(token)
+	messages := NewMessages(client)
+	away := make(map[int64]bool)
+	for gid, latest := range state.Latest {
+		messages.SetLatest(gid, latest)
+		away[gid] = true
+	}
+
+	// prefix log lines with the account if there are several
+	multi := len(options.Accounts) > 1
+	logger := log.Logger
+	if multi {
+		logger = log.With().Str("account", account).Logger()
+	}
+
+	poller := &Poller{
+		account:    account,
+		client:     client,
+		store:      env.Store,
+		log:        logger,
+		multi:      multi,
+		notifier:   env.Notifier,
+		users:      NewUsers(client, mugdir),
+		messages:   messages,
+		state:      state,
+		status:     NewStatus(account),
+		logo:       env.Logo,
+		background: env.Background,
+		watchdog:   env.Watchdog,
+		away:       away,
+
+		scheduler:   NewScheduler(options.Interval, options.Bounds),
+		concurrency: options.Concurrency,
+
+		groups:       make(map[int64]YammerGroup),
+		groupUpdates: make(chan groupUpdate),
+
+		events: make(chan func(), 16),
+		unread: make(map[int64]int),
+		muted:  make(map[int64]bool),
+
+		ctx:  ctx,
+		done: make(chan struct{}),
+	}
+	poller.configure(options)
+	return poller, nil
+}
+
+// Account returns the name of the polled account.
+func (poller *Poller) Account() string {
+	return poller.account
+}
+
+// Done returns a channel which is closed once polling stopped.
+func (poller *Poller) Done() <-chan struct{} {
+	return poller.done
+}
+
+// SetTray sets the submenu of the account (to be called before Poll).
+func (poller *Poller) SetTray(tray *SystrayAccount) {
+	poller.tray = tray
+}
+
+// Serve serves the commands of 'goyammer ctl' on the control socket of the account.
+func (poller *Poller) Serve() error {
+	control, errControl := ListenControl(ControlPath(poller.account), poller.controlHandlers())
+	if errControl != nil {
+		return errControl
+	}
+	poller.control = control
+	return nil
+}
+
+// CleanUp closes the control socket and removes the status file (once polling stopped).
+func (poller *Poller) CleanUp() {
+	if poller.control != nil {
+		errControl := poller.control.Close()
+		if errControl != nil {
+			poller.log.Warn().Err(errControl).Msg("failed to close control socket")
+		}
+	}
+	errStatus := poller.status.Remove()
+	if errStatus != nil {
+		poller.log.Warn().Err(errStatus).Msg("failed to remove status")
+	}
+}
+
+// Poll polls until the context of the poller is done.
+func (poller *Poller) Poll() {
+	defer close(poller.done)
+
+	// report to the watchdog from every loop below (showing that none of them is stuck)
+	if interval := poller.watchdog.Interval(); interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		poller.keepalive = ticker.C
+	}
+
+	poller.log.Info().Msg(fmt.Sprint("goyammer started"))
+
+	sleepTime := poller.interval
+	poller.log.Info().Msg(fmt.Sprintf("* polling: every %s initially (adapting to activity)", sleepTime.String()))
+	poller.saveStatus()
+
+	// get the current user
+	var currentUser *User
+	for {
+		user, errUser := poller.getCurrentUser()
+		currentUser = user
+		if errUser == nil {
+			break
+		}
+		if poller.ctx.Err() != nil {
+			poller.log.Info().Msg("stopped polling")
+			return
+		}
+		poller.log.Warn().Err(errUser).Msg("failed to get current user")
+		poller.status.Failed(errUser)
+		poller.saveStatus()
+		if poller.client.AuthFailed() {
+			poller.waitForLogin()
+			continue
+		}
+		poller.sleep(sleepTime)
+	}
+	poller.user = currentUser
+	poller.network = currentUser.NetworkName
+	poller.tray.SetNetwork(fmt.Sprintf("%s (%s)", poller.account, poller.network))
+	poller.log.Info().Msg(fmt.Sprintf("* user: %s", currentUser.FullName))
+	poller.log.Info().Msg(fmt.Sprintf("* network: %s", currentUser.NetworkName))
+	poller.log.Info().Msg(fmt.Sprint("* groups:"))
+	for _, group := range *currentUser.Groups {
+		if selected, reason := poller.filter.Select(group); !selected {
+			poller.log.Info().Msg(fmt.Sprintf("  - %s (skipped: %s)", group.FullName, reason))
+			continue
+		}
+		poller.log.Info().Msg(fmt.Sprintf("  - %s", group.FullName))
+		poller.addGroup(group)
+	}
+
+	poller.notifyf(UrgencyNormal, "Listening on %d groups for user %s.", len(poller.groups), currentUser.FullName)
+	poller.setStatus(fmt.Sprintf("listening on %d groups", len(poller.groups)))
+	poller.saveStatus()
+
+	// start the workers fetching groups
+	jobs := make(chan int64)
+	results := make(chan pollResult)
+	for i := uint(0); i < poller.concurrency; i++ {
+		go poller.pollWorker(jobs, results)
+	}
+
+	// POLL messages
+	polling := uint(0)
+	for {
+
+		// periodically refresh the group membership
+		var refresh <-chan time.Time
+		if poller.refreshTicker != nil {
+			refresh = poller.refreshTicker.C
+		}
+
+		// wait for the next group to become due (if there is a free worker) or for a worker to finish
+		var dispatch chan<- int64
+		var timer <-chan time.Time
+		gid, due, ok := poller.scheduler.Next()
+		if ok && polling < poller.concurrency && !poller.loginRequired && !poller.paused {
+			if wait := time.Until(due); wait > 0 {
+				timer = time.After(wait)
+			} else {
+				dispatch = jobs
+			}
+		}
+
+		select {
+		case dispatch <- gid:
+			poller.scheduler.Start(gid)
+			Systray_poll()
+			polling++
+		case <-timer:
+		case result := <-results:
+			polling--
+			Systray_done()
+			if group, ok := poller.groups[result.gid]; ok {
+				poller.handleResult(group, result, currentUser)
+			}
+			poller.status.Polled(result.err)
+			if poller.client.AuthFailed() && !poller.loginRequired {
+				poller.requireLogin()
+			}
+			poller.saveStatus()
+		case <-refresh:
+			poller.refreshGroupList()
+		case update := <-poller.groupUpdates:
+			poller.refreshing = false
+			poller.updateGroups(update)
+			poller.setStatus(fmt.Sprintf("listening on %d groups", len(poller.groups)))
+			poller.saveStatus()
+		case <-poller.tray.OpenCh():
+			OpenBrowser(currentUser.WebURL)
+		case <-poller.tray.ReadCh():
+			poller.markAllRead()
+		case <-poller.tray.LoginCh():
+			poller.loginInBackground()
+		case event := <-poller.events:
+			event()
+		case <-poller.keepalive:
+			poller.alive()
+		case <-poller.ctx.Done():
+			poller.stopPolling(jobs, results, polling)
+			return
+		}
+	}
+}
+
+// getCurrentUser fetches the current user (reporting to the watchdog while the request is retried).
+func (poller *Poller) getCurrentUser() (*User, error) {
+	type outcome struct {
+		user *User
+		err  error
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		user, errUser := poller.users.GetUser(poller.ctx, -1)
+		done <- outcome{user: user, err: errUser}
+	}()
+	for {
+		select {
+		case o := <-done:
+			return o.user, o.err
+		case <-poller.keepalive:
+			poller.alive()
+		}
+	}
+}
+
+// sleep waits for the given duration or until the context is done (reporting to the watchdog meanwhile).
+func (poller *Poller) sleep(duration time.Duration) {
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+			return
+		case <-poller.keepalive:
+			poller.alive()
+		case <-poller.ctx.Done():
+			return
+		}
+	}
+}
+
+// stopPolling stops the workers, waits for the given number of polls in flight (failing as the context is done) and
+// saves the state.
+func (poller *Poller) stopPolling(jobs chan<- int64, results <-chan pollResult, polling uint) {
+	close(jobs)
+	for ; polling > 0; polling-- {
+		<-results
+		Systray_done()
+	}
+	errSave := poller.state.Save()
+	if errSave != nil {
+		poller.log.Warn().Err(errSave).Msg("failed to save state")
+	}
+	poller.log.Info().Msg("stopped polling")
+}
+
+// requireLogin stops polling because the token has been rejected and asks the user to log in again.
+func (poller *Poller) requireLogin() {
+	poller.loginRequired = true
+	poller.log.Error().Msg("the token has been rejected, stopped polling until logged in again")
+	poller.setStatus("login required")
+	poller.tray.ShowLogin(true)
+	poller.saveStatus()
+	if poller.clientId == "" {
+		poller.notifyf(UrgencyCritical, "goyammer needs you to log in again: run 'goyammer login --account %s' and choose 'log in again' in the tray menu.", poller.account)
+	} else {
+		poller.notifyf(UrgencyCritical, "goyammer needs you to log in again: choose 'log in again' in the tray menu.")
+	}
+}
+
+// waitForLogin asks the user to log in again and blocks until logged in.
+func (poller *Poller) waitForLogin() {
+	poller.requireLogin()
+	for poller.loginRequired {
+		select {
+		case <-poller.tray.LoginCh():
+			poller.loginInBackground()
+		case event := <-poller.events:
+			event()
+		case <-poller.keepalive:
+			poller.alive()
+		case <-poller.ctx.Done():
+			return
+		}
+	}
+}
+
+// loginInBackground logs in again without blocking the poll loop (unless already logging in).
+func (poller *Poller) loginInBackground() {
+	if poller.loggingIn {
+		return
+	}
+	poller.loggingIn = true
+	clientId := poller.clientId
+	go func() {
+		errLogin := poller.login(clientId)
+		select {
+		case poller.events <- func() {
+			poller.loggingIn = false
+			poller.loggedIn(errLogin)
+		}:
+		case <-poller.ctx.Done():
+		}
+	}()
+}
+
+// alive reports to the watchdog that the poll loop is live.
+func (poller *Poller) alive() {
+	errWatchdog := poller.watchdog.Alive(poller.account)
+	if errWatchdog != nil {
+		poller.log.Warn().Err(errWatchdog).Msg("failed to notify systemd watchdog")
+	}
+}
+
+// login logs in again with the given client ID (or, without, picks up the token of a login on the command line) and
+// replaces the token of the client.
+func (poller *Poller) login(clientId string) error {
+	var token string
+	if clientId == "" {
+		stored, errGet := poller.store.Get(poller.account)
+		if errGet != nil {
+			return fmt.Errorf("failed to read token: %v", errGet)
+		}
+		if stored == poller.client.token() {
+			return fmt.Errorf("no new token, run 'goyammer login --account %s' first (or configure 'client_id')", poller.account)
+		}
+		token = stored
+	} else {
+		auth := NewAuthenticator(clientId)
+		auth.Browse = OpenBrowser
+		authenticated, errAuth := auth.Authenticate()
+		if errAuth != nil {
+			return fmt.Errorf("failed to authenticate: %v", errAuth)
+		}
+		errSet := poller.store.Set(poller.account, authenticated)
+		if errSet != nil {
+			return fmt.Errorf("failed to store token: %v", errSet)
+		}
+		token = authenticated
+	}
+	poller.client.SetToken(token)
+	return nil
+}
+
+// loggedIn resumes polling after logging in again (unless that failed).
+func (poller *Poller) loggedIn(errLogin error) {
+	if errLogin != nil {
+		poller.log.Warn().Err(errLogin).Msg("failed to log in again")
+		poller.notifyf(UrgencyCritical, "Failed to log in again: %v", errLogin)
+		return
+	}
+	poller.loginRequired = false
+	poller.tray.ShowLogin(false)
+	poller.setStatus(fmt.Sprintf("listening on %d groups", len(poller.groups)))
+	poller.saveStatus()
+	poller.log.Info().Msg("logged in again, resuming polling")
+	poller.notifyf(UrgencyNormal, "Logged in again, resuming polling.")
+}
+
+// setStatus shows what goyammer is doing for the account in the tray menu (and tells systemd if running as a service).
+func (poller *Poller) setStatus(status string) {
+	poller.tray.SetStatus(status)
+	if poller.multi {
+		status = fmt.Sprintf("%s: %s", poller.account, status)
+	}
+	_, errNotify := SdNotify("STATUS=" + status)
+	if errNotify != nil {
+		poller.log.Debug().Err(errNotify).Msg("failed to notify systemd")
+	}
+}
+
+// saveStatus reports the network, the polled groups and whether logging in again is required along with the outcome of
+// the last poll (for 'goyammer status').
+func (poller *Poller) saveStatus() {
+	groups := make([]string, 0, len(poller.groups))
+	for _, group := range poller.groups {
+		groups = append(groups, group.FullName)
+	}
+	sort.Strings(groups)
+	poller.status.Network = poller.network
+	poller.status.Groups = groups
+	poller.status.LoginRequired = poller.loginRequired
+	poller.status.Paused = poller.paused
+	errSave := poller.status.Save()
+	if errSave != nil {
+		poller.log.Warn().Err(errSave).Msg("failed to save status")
+	}
+}
+
+// controlHandlers returns the commands served on the control socket (see 'goyammer ctl').
+func (poller *Poller) controlHandlers() map[string]ControlHandler {
+
+	// the parameters of the commands
+	type messagesParams struct {
+		Count int `json:"count"`
+	}
+	type logLevelParams struct {
+		Level string `json:"level"`
+	}
+
+	return map[string]ControlHandler{
+		"pause": func(params json.RawMessage) (interface{}, error) {
+			return poller.onLoop(func() (interface{}, error) {
+				if !poller.paused {
+					poller.paused = true
+					poller.log.Info().Msg("paused polling")
+					poller.setStatus("paused")
+					poller.saveStatus()
+				}
+				return nil, nil
+			})
+		},
+		"resume": func(params json.RawMessage) (interface{}, error) {
+			return poller.onLoop(func() (interface{}, error) {
+				if poller.paused {
+					poller.paused = false
+					poller.log.Info().Msg("resumed polling")
+					poller.setStatus(fmt.Sprintf("listening on %d groups", len(poller.groups)))
+					poller.saveStatus()
+				}
+				return nil, nil
+			})
+		},
+		"poll-now": func(params json.RawMessage) (interface{}, error) {
+			var p groupParams
+			if errParams := DecodeParams(params, &p); errParams != nil {
+				return nil, errParams
+			}
+			return poller.onLoop(func() (interface{}, error) {
+				if poller.paused || poller.loginRequired {
+					return nil, fmt.Errorf("polling is paused or waiting for login")
+				}
+				if p.Group == "" {
+					for gid := range poller.groups {
+						poller.scheduler.PollNow(gid)
+					}
+					return nil, nil
+				}
+				group, ok := poller.findGroup(p.Group)
+				if !ok {
+					return nil, fmt.Errorf("no polled group '%s'", p.Group)
+				}
+				poller.scheduler.PollNow(group.ID)
+				return nil, nil
+			})
+		},
+		"mute": func(params json.RawMessage) (interface{}, error) {
+			return poller.setMuted(params, true)
+		},
+		"unmute": func(params json.RawMessage) (interface{}, error) {
+			return poller.setMuted(params, false)
+		},
+		"groups": func(params json.RawMessage) (interface{}, error) {
+			return poller.onLoop(func() (interface{}, error) {
+				groups := make([]ControlGroup, 0, len(poller.groups))
+				for gid, group := range poller.groups {
+					latest, _ := poller.messages.GetLatest(gid)
+					groups = append(groups, ControlGroup{
+						ID:       gid,
+						Name:     group.FullName,
+						Latest:   latest,
+						Interval: int64(poller.scheduler.Interval(gid) / time.Second),
+						Muted:    poller.groupSettings(group).Mute || poller.muted[gid],
+					})
+				}
+				sort.Slice(groups, func(i, j int) bool {
+					return groups[i].Name < groups[j].Name
+				})
+				return groups, nil
+			})
+		},
+		"messages": func(params json.RawMessage) (interface{}, error) {
+			p := messagesParams{Count: 10}
+			if errParams := DecodeParams(params, &p); errParams != nil {
+				return nil, errParams
+			}
+			return poller.onLoop(func() (interface{}, error) {
+				count := p.Count
+				if count < 0 || count > len(poller.recent) {
+					count = len(poller.recent)
+				}
+				return append([]ControlMessage{}, poller.recent[:count]...), nil
+			})
+		},
+		"log-level": func(params json.RawMessage) (interface{}, error) {
+			var p logLevelParams
+			if errParams := DecodeParams(params, &p); errParams != nil {
+				return nil, errParams
+			}
+			level, errLevel := zerolog.ParseLevel(p.Level)
+			if errLevel != nil || p.Level == "" {
+				return nil, fmt.Errorf("unknown log level '%s'", p.Level)
+			}
+
+			// the level is global (i.e. affects all accounts polled by the process)
+			previous := zerolog.GlobalLevel()
+			zerolog.SetGlobalLevel(level)
+			log.Info().Msg(fmt.Sprintf("log level changed from %s to %s", previous, level))
+			return previous.String(), nil
+		},
+	}
+}
+
+// groupParams are the parameters of commands concerning a group.
+type groupParams struct {
+	Group string `json:"group"`
+}
+
+// onLoop runs the given function on the poll loop and returns its outcome (or an error if the poll loop is busy, e.g.
+// waiting for the user to log in again).
+func (poller *Poller) onLoop(f func() (interface{}, error)) (interface{}, error) {
+	type outcome struct {
+		result interface{}
+		err    error
+	}
+	done := make(chan outcome, 1)
+	timeout := time.After(10 * time.Second)
+	select {
+	case poller.events <- func() {
+		result, err := f()
+		done <- outcome{result: result, err: err}
+	}:
+	case <-timeout:
+		return nil, fmt.Errorf("the poller is busy, try again later")
+	}
+	select {
+	case o := <-done:
+		return o.result, o.err
+	case <-timeout:
+		return nil, fmt.Errorf("the poller is busy, try again later")
+	}
+}
+
+// setMuted mutes or unmutes the group given in the parameters of a command.
+func (poller *Poller) setMuted(params json.RawMessage, muted bool) (interface{}, error) {
+	var p groupParams
+	if errParams := DecodeParams(params, &p); errParams != nil {
+		return nil, errParams
+	}
+	return poller.onLoop(func() (interface{}, error) {
+		group, ok := poller.findGroup(p.Group)
+		if !ok {
+			return nil, fmt.Errorf("no polled group '%s'", p.Group)
+		}
+		if muted {
+			poller.muted[group.ID] = true
+			poller.log.Info().Msg(fmt.Sprintf("muted group %s", group.FullName))
+			return nil, nil
+		}
+		if poller.groupSettings(group).Mute {
+			return nil, fmt.Errorf("group '%s' is muted in the configuration file", group.FullName)
+		}
+		delete(poller.muted, group.ID)
+		poller.log.Info().Msg(fmt.Sprintf("unmuted group %s", group.FullName))
+		return nil, nil
+	})
+}
+
+// findGroup returns the polled group with the given ID or name.
+func (poller *Poller) findGroup(name string) (YammerGroup, bool) {
+	for gid, group := range poller.groups {
+		if strconv.FormatInt(gid, 10) == name || group.FullName == name {
+			return group, true
+		}
+	}
+	return YammerGroup{}, false
+}
+
+// groupUpdate is the outcome of refreshing the group membership.
+type groupUpdate struct {
+	groups *YammerGroupResponse
+	err    error
+}
+
+// addGroup starts polling the given group.
+func (poller *Poller) addGroup(group YammerGroup) {
+	poller.groups[group.ID] = group
+	poller.scheduler.Add(group.ID)
+	poller.applyBounds(group)
+}
+
+// applyBounds applies the poll interval bounds configured for the given group (or the default bounds).
+func (poller *Poller) applyBounds(group YammerGroup) {
+	if bounds, ok := poller.groupIntervals[strconv.FormatInt(group.ID, 10)]; ok {
+		poller.scheduler.SetBounds(group.ID, bounds)
+	} else if bounds, ok := poller.groupIntervals[group.FullName]; ok {
+		poller.scheduler.SetBounds(group.ID, bounds)
+	} else {
+		poller.scheduler.ClearBounds(group.ID)
+	}
+}
+
+// stopGroup stops polling the given group, but keeps its latest message id (to resume if it is polled again).
+func (poller *Poller) stopGroup(group YammerGroup) {
+	delete(poller.groups, group.ID)
+	delete(poller.away, group.ID)
+	poller.scheduler.Remove(group.ID)
+}
+
+// removeGroup stops polling the given group and forgets about it.
+func (poller *Poller) removeGroup(group YammerGroup) {
+	poller.stopGroup(group)
+	poller.messages.Forget(group.ID)
+	if _, ok := poller.state.Latest[group.ID]; ok {
+		delete(poller.state.Latest, group.ID)
+		errSave := poller.state.Save()
+		if errSave != nil {
+			poller.log.Warn().Err(errSave).Msg("failed to save state")
+		}
+	}
+}
+
+// updateGroups starts polling groups the user joined (or which are selected by the group filter now) and stops polling
+// groups the user left (or which are no longer selected).
+func (poller *Poller) updateGroups(update groupUpdate) {
+	if update.err != nil {
+		poller.log.Warn().Err(update.err).Msg("failed to refresh groups")
+		poller.status.Failed(update.err)
+		return
+	}
+
+	member := make(map[int64]bool)
+	current := make(map[int64]bool)
+	for _, group := range *update.groups {
+		member[group.ID] = true
+		if selected, _ := poller.filter.Select(group); !selected {
+			continue
+		}
+		current[group.ID] = true
+		if _, ok := poller.groups[group.ID]; ok {
+			poller.groups[group.ID] = group
+			continue
+		}
+
+		// a new group is seeded with its latest message (rather than flooding with its history), a group which has been
+		// polled before resumes like after a restart
+		poller.log.Info().Msg(fmt.Sprintf("joined group %s", group.FullName))
+		if _, known := poller.messages.GetLatest(group.ID); known {
+			poller.away[group.ID] = true
+		} else {
+			poller.messages.Forget(group.ID)
+		}
+		poller.addGroup(group)
+		poller.notifyf(UrgencyNormal, "Now also listening on group %s.", group.FullName)
+	}
+
+	for gid, group := range poller.groups {
+		if current[gid] {
+			continue
+		}
+		if member[gid] {
+			poller.log.Info().Msg(fmt.Sprintf("stopped polling group %s (no longer selected)", group.FullName))
+			poller.stopGroup(group)
+		} else {
+			poller.log.Info().Msg(fmt.Sprintf("left group %s", group.FullName))
+			poller.removeGroup(group)
+		}
+		poller.notifyf(UrgencyNormal, "No longer listening on group %s.", group.FullName)
+	}
+}
+
+// refreshGroupList fetches the group membership in the background (see updateGroups) unless a refresh is in progress.
+func (poller *Poller) refreshGroupList() {
+	if poller.user == nil || poller.loginRequired || poller.paused || poller.refreshing {
+		return
+	}
+	poller.refreshing = true
+	user := poller.user
+	go func() {
+		groups, errGroups := poller.users.RefreshGroups(poller.ctx, user)
+		select {
+		case poller.groupUpdates <- groupUpdate{groups: groups, err: errGroups}:
+		case <-poller.ctx.Done():
+		case <-poller.done:
+		}
+	}()
+}
+
+// configure applies the given options (keeping what is known about the groups) and re-applies the group filter.
+func (poller *Poller) configure(options *PollOptions) {
+	poller.settings = options.Settings
+	poller.clientId = options.ClientID
+	poller.backlog = options.Backlog
+	poller.notify = options.Notify
+	poller.notifyEach = options.NotifyEach
+	poller.filter = options.Filter
+
+	poller.interval = options.Interval
+	poller.groupIntervals = options.GroupIntervals
+	poller.scheduler.SetDefaults(options.Interval, options.Bounds)
+	for _, group := range poller.groups {
+		poller.applyBounds(group)
+	}
+
+	poller.messages.SetMaxPages(int(options.MaxPages))
+	poller.client.SetTimeout(options.Timeout)
+	if options.RateLimit != poller.rateLimit {
+		poller.rateLimit = options.RateLimit
+		poller.client.SetRateLimit(int(options.RateLimit), DefaultRatePeriod)
+	}
+	if options.RefreshGroups != poller.refreshGroups {
+		poller.refreshGroups = options.RefreshGroups
+		if poller.refreshTicker != nil {
+			poller.refreshTicker.Stop()
+			poller.refreshTicker = nil
+		}
+		if poller.refreshGroups > 0 {
+			poller.refreshTicker = time.NewTicker(poller.refreshGroups)
+		}
+	}
+
+	poller.refreshGroupList()
+}
+
+// Reload applies the given options and picks up a new token (e.g. stored by 'goyammer login') unless polling stopped.
+func (poller *Poller) Reload(options *PollOptions) {
+	token, errToken := poller.store.Get(poller.account)
+	if errToken != nil {
+		poller.log.Warn().Err(errToken).Msg("failed to reload token")
+	}
+	reload := func() {
+		poller.configure(options)
+		if errToken != nil || token == poller.client.token() {
+			return
+		}
+		poller.client.SetToken(token)
+		poller.log.Info().Msg("token reloaded")
+		if poller.loginRequired {
+			poller.loggedIn(nil)
+		}
+	}
+
+	// there is nothing to reload once polling stopped (or is stopping)
+	select {
+	case poller.events <- reload:
+	case <-poller.ctx.Done():
+	case <-poller.done:
+	}
+}
+
+// pollResult is the outcome of polling a single group.
+type pollResult struct {
+	gid         int64
+	newMessages []*Message
+
+	// the senders of the new messages by user id (nil if they could not be fetched)
+	senders map[int64]*User
+
+	err error
+}
+
+// pollWorker fetches new messages (and their senders) for the groups received from jobs and sends the outcome to
+// results.
+func (poller *Poller) pollWorker(jobs <-chan int64, results chan<- pollResult) {
+	for gid := range jobs {
+		newMessages, errNM := poller.messages.GetNewMessages(poller.ctx, gid)
+		results <- pollResult{gid: gid, newMessages: newMessages, senders: poller.getSenders(newMessages), err: errNM}
+	}
+}
+
+// getSenders fetches the senders of the given messages by user id (nil for those which could not be fetched).
+func (poller *Poller) getSenders(messages []*Message) map[int64]*User {
+	senders := make(map[int64]*User)
+	for _, message := range messages {
+		senderId := message.SenderID
+		if _, ok := senders[senderId]; ok {
+			continue
+		}
+		user, errUser := poller.users.GetUser(poller.ctx, senderId)
+		if errUser != nil {
+			poller.log.Warn().Err(errUser).Msg(fmt.Sprintf("failed to get user: %d", senderId))
+		}
+		senders[senderId] = user
+	}
+	return senders
+}
+
+// handleResult handles the outcome of polling a group and reschedules the group.
+func (poller *Poller) handleResult(group YammerGroup, result pollResult, currentUser *User) {
+	gid := result.gid
+	if result.err != nil {
+		poller.log.Warn().Err(result.err).Msg(fmt.Sprintf("failed to get new messages for group %s", group.FullName))
+		poller.scheduler.Done(gid, 0, 0)
+		return
+	}
+
+	away := poller.away[gid]
+	delete(poller.away, gid)
+	if len(result.newMessages) > 0 {
+		poller.handleMessages(group, result.newMessages, result.senders, currentUser, away)
+	}
+	poller.saveLatest(gid)
+	poller.scheduler.Done(gid, len(result.newMessages), poller.messages.GetRequestedPollInterval(gid))
+	poller.log.Debug().Msg(fmt.Sprintf("next poll of group %s in %s", group.FullName, poller.scheduler.Interval(gid).String()))
+}
+
+// groupSettings returns the configured settings of the given group (by ID or name).
+func (poller *Poller) groupSettings(group YammerGroup) GroupSettings {
+	if settings, ok := poller.settings.Groups[strconv.FormatInt(group.ID, 10)]; ok {
+		return settings
+	}
+	return poller.settings.Groups[group.FullName]
+}
+
+// notifyf sends a notification with the application logo.
+func (poller *Poller) notifyf(urgency Urgency, format string, a ...interface{}) {
+	notification := Notification{
+		Summary: poller.label("goyammer"),
+		Body:    fmt.Sprintf(format, a...),
+		Icon:    poller.logo,
+		Urgency: urgency,
+	}
+	if CapabilitiesOf(poller.notifier).Images {
+		notification.Image = icon.Main
+	}
+	poller.send(notification)
+}
+
+// label appends the network to the given summary if several accounts are polled.
+func (poller *Poller) label(summary string) string {
+	if !poller.multi || poller.network == "" {
+		return summary
+	}
+	return fmt.Sprintf("%s (%s)", summary, poller.network)
+}
+
+// send sends a notification (unless notifications are disabled).
+func (poller *Poller) send(notification Notification) {
+	if !poller.notify {
+		return
+	}
+	errNotify := poller.notifier.Notify(notification)
+	if errNotify != nil {
+		poller.log.Warn().Err(errNotify).Msg("failed to notify")
+	}
+}
+
+// notifyMessage sends a notification about the given message (and the given number of other new messages) which
+// replaces the previous notification of the group (if the notifier supports that and not every message is notified).
+func (poller *Poller) notifyMessage(group YammerGroup, message *Message, user *User, urgency Urgency, others int) {
+	capabilities := CapabilitiesOf(poller.notifier)
+	gid := group.ID
+
+	// every message gets its own notification or the notification of the group keeps counting while it is shown
+	tag := fmt.Sprintf("%s-group-%d", poller.account, gid)
+	if poller.notifyEach {
+		tag = fmt.Sprintf("%s-message-%d", poller.account, message.ID)
+	} else if capabilities.Tags {
+		others += poller.unread[gid]
+		poller.unread[gid] = others + 1
+	}
+
+	body := fmt.Sprintf("%s\n\n%s", message.Body.Plain, message.WebUrl)
+	if others > 0 {
+		body = fmt.Sprintf("%s\n\n... and %d more", body, others)
+	}
+	notification := Notification{
+		Summary: poller.label(user.FullName),
+		Body:    body,
+		Icon:    poller.logo,
+		Urgency: urgency,
+		Tag:     tag,
+	}
+
+	// set icon (either mugshot or default logo)
+	if capabilities.Images {
+		notification.Image = user.MugShot()
+	} else {
+		file, errMug := poller.users.GetMugFile(user)
+		if errMug == nil {
+			notification.Icon = file.Name()
+		}
+	}
+
+	// offer actions (handled on the poll loop, clicking the notification opens the message)
+	if capabilities.Actions {
+		notification.Actions = []Action{
+			{Key: "default", Label: "Open"},
+			{Key: "like", Label: "Like"},
+			{Key: "read", Label: "Mark read"},
+			{Key: "mute", Label: "Mute group"},
+		}
+		notification.OnAction = func(key string) {
+			poller.onEvent(func() {
+				poller.handleAction(group, message, key)
+			})
+		}
+		notification.OnClosed = func() {
+			poller.onEvent(func() {
+				delete(poller.unread, gid)
+			})
+		}
+	}
+
+	poller.send(notification)
+}
+
+// onEvent runs the given function on the poll loop. It never blocks the caller (e.g. the signal handling of the
+// notifier) but drops the function if the poll loop is busy or stopped.
+func (poller *Poller) onEvent(event func()) {
+	select {
+	case poller.events <- event:
+	default:
+		poller.log.Warn().Msg("the poller is busy, dropped notification event")
+	}
+}
+
+// handleAction reacts to the action invoked on the notification of the given group (about the given message).
+func (poller *Poller) handleAction(group YammerGroup, message *Message, key string) {
+	switch key {
+	case "default":
+		OpenBrowser(message.WebUrl)
+		delete(poller.unread, group.ID)
+	case "like":
+		poller.react(fmt.Sprintf("liked message %d", message.ID), func(ctx context.Context) error {
+			return poller.client.LikeMessage(ctx, message.ID)
+		})
+	case "read":
+		delete(poller.unread, group.ID)
+		poller.react(fmt.Sprintf("marked message %d as seen", message.ID), func(ctx context.Context) error {
+			return poller.client.MarkSeen(ctx, message.ID)
+		})
+	case "mute":
+		poller.log.Info().Msg(fmt.Sprintf("muted group %s", group.FullName))
+		poller.muted[group.ID] = true
+		delete(poller.unread, group.ID)
+	}
+}
+
+// markAllRead marks the latest message received in each group (and thereby the ones before) as seen.
+func (poller *Poller) markAllRead() {
+	poller.unread = make(map[int64]int)
+	marked := make(map[string]bool)
+	for _, message := range poller.recent {
+		if marked[message.Group] {
+			continue
+		}
+		marked[message.Group] = true
+		id := message.ID
+		poller.react(fmt.Sprintf("marked message %d as seen", id), func(ctx context.Context) error {
+			return poller.client.MarkSeen(ctx, id)
+		})
+	}
+}
+
+// react calls the API in the background (not to block the poll loop) and logs the outcome (the given description if it
+// succeeded).
+func (poller *Poller) react(done string, call func(ctx context.Context) error) {
+	go func() {
+		errCall := call(poller.ctx)
+		if errCall != nil {
+			poller.log.Warn().Msg(errCall.Error())
+			return
+		}
+		poller.log.Info().Msg(done)
+	}()
+}
+
+// remember keeps the given message for the "messages" command (dropping the oldest one if there are too many).
+func (poller *Poller) remember(groupName string, message *Message, user *User) {
+	poller.recent = append(poller.recent, ControlMessage{
+		ID:        message.ID,
+		Group:     groupName,
+		Sender:    user.FullName,
+		Body:      message.Body.Plain,
+		WebUrl:    message.WebUrl,
+		CreatedAt: message.CreatedAt,
+	})
+	sort.Slice(poller.recent, func(i, j int) bool {
+		return poller.recent[i].ID > poller.recent[j].ID
+	})
+	if len(poller.recent) > recentMessages {
+		poller.recent = poller.recent[:recentMessages]
+	}
+}
+
+// saveLatest persists the latest message id of the given group (if it changed).
+func (poller *Poller) saveLatest(gid int64) {
+	latest, ok := poller.messages.GetLatest(gid)
+	if !ok || poller.state.Latest[gid] == latest {
+		return
+	}
+	poller.state.Latest[gid] = latest
+	errSave := poller.state.Save()
+	if errSave != nil {
+		poller.log.Warn().Err(errSave).Msg("failed to save state")
+	}
+}
+
+func (poller *Poller) handleMessages(group YammerGroup, messages []*Message, senders map[int64]*User, currentUser *User, away bool) {
+
+	// regex matching newline newlines
+	re := regexp.MustCompile(`\r?\n`)
+
+	groupName := group.FullName
+	groupSettings := poller.groupSettings(group)
+	urgency := groupSettings.Urgency()
+
+	// muted groups are logged but never notified
+	notified := groupSettings.Mute || poller.muted[group.ID]
+
+	// if the messages have been missed while not running, only show the latest ones and notify once
+	if away {
+
+		// the own messages are not worth a notification
+		fromOthers := 0
+		for _, message := range messages {
+			if message.SenderID != currentUser.ID {
+				fromOthers++
+			}
+		}
+
+		missed := len(messages)
+		if uint(missed) > poller.backlog {
+			messages = messages[uint(missed)-poller.backlog:]
+			poller.log.Info().Msg(fmt.Sprintf("%d messages in %s while you were away, showing the latest %d", missed, groupName, len(messages)))
+		}
+		if !notified && fromOthers > 0 {
+			poller.notifyf(urgency, "%d messages in %s while you were away.", fromOthers, groupName)
+		}
+		notified = true
+	}
+
+	// go through all messages from newest to oldest
+	for i := len(messages) - 1; i >= 0; i-- {
+
+		message := messages[i]
+
+		// get the sender (skipping the message if it could not be fetched)
+		user := senders[message.SenderID]
+		if user == nil {
+			continue
+		}
+		poller.remember(groupName, message, user)
+
+		// if there is plain text in the message and we have a full name
+		if message.Body.Plain != "" && user.FullName != "" {
+
+			// replace newlines
+			simpleMessage := re.ReplaceAllString(message.Body.Plain, " ")
+
+			// log for background or foreground
+			if poller.background {
+
+				// construct and format the logMsg
+				logMsg := fmt.Sprintf("%s -- %s", simpleMessage, message.WebUrl)
+				poller.log.Info().Str("group", groupName).Str("user", user.FullName).Msg(logMsg)
+			} else {
+
+				// construct and format the logMsg
+				logMsg := fmt.Sprintf("%s - %s | %s",
+					ElipseMe(groupName, 6, true),
+					ElipseMe(user.FullName, 6, true),
+					ElipseMe(simpleMessage, 50, false))
+				poller.log.Info().Msg(logMsg)
+			}
+
+			// only if no message from the batch has been notified and message was not send by current user
+			if !notified && message.SenderID != currentUser.ID {
+
+				others := 0
+				if !poller.notifyEach {
+					others = len(messages) - 1
+				}
+				poller.notifyMessage(group, message, user, urgency, others)
+
+				// unless every message is to be notified
+				notified = !poller.notifyEach
+			}
+		}
+
+	}
+}
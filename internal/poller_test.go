@@ -0,0 +1,514 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// testPollOptions returns the options of a poller with the given settings.
+func testPollOptions(settings Settings) *PollOptions {
+	return &PollOptions{
+		Settings:    settings,
+		Accounts:    []string{DefaultAccount},
+		Interval:    10 * time.Second,
+		Bounds:      IntervalBounds{Min: 10 * time.Second, Max: 300 * time.Second},
+		Concurrency: 1,
+		RateLimit:   10,
+		Timeout:     10 * time.Second,
+		MaxPages:    1,
+	}
+}
+
+// withPoller runs the given test with a poller of the default account (with the given settings) polling the groups
+// "Team Alpha" (1) and "Team Beta" (2), serving its control socket and running events as its poll loop would.
+func withPoller(t *testing.T, settings Settings, test func(poller *Poller)) {
+	withRuntimeDir(t, func() {
+		withStateDir(t, func(dir string) {
+			withEnv(map[string]string{"XDG_STATE_HOME": dir, "XDG_CONFIG_HOME": dir}, func() {
+				lock, errLock := LockAccount(DefaultAccount)
+				if errLock != nil {
+					t.Fatalf("LockAccount() failed: %v", errLock)
+				}
+				defer func() {
+					_ = lock.Release()
+				}()
+
+				ctx, cancel := context.WithCancel(context.Background())
+				defer cancel()
+
+				env := &PollerEnv{Store: NewFileStore()}
+				poller, errPoller := NewPoller(ctx, env, DefaultAccount, "token", dir, testPollOptions(settings))
+				if errPoller != nil {
+					t.Fatalf("NewPoller() failed: %v", errPoller)
+				}
+
+				// the groups are polled and not due
+				for _, group := range []YammerGroup{{ID: 1, FullName: "Team Alpha"}, {ID: 2, FullName: "Team Beta"}} {
+					poller.addGroup(group)
+					poller.scheduler.Start(group.ID)
+					poller.scheduler.Done(group.ID, 0, 0)
+				}
+
+				if err := poller.Serve(); err != nil {
+					t.Fatalf("Serve() failed: %v", err)
+				}
+				defer poller.CleanUp()
+
+				go func() {
+					for {
+						select {
+						case event := <-poller.events:
+							event()
+						case <-ctx.Done():
+							return
+						}
+					}
+				}()
+
+				test(poller)
+			})
+		})
+	})
+}
+
+func TestPoller_control(t *testing.T) {
+	settings := Settings{Groups: map[string]GroupSettings{"Team Beta": {Mute: true}}}
+	withPoller(t, settings, func(poller *Poller) {
+
+		// what the commands change (as seen on the poll loop)
+		type snapshot struct {
+			paused bool
+			muted  map[int64]bool
+			due    []int64
+		}
+		current := func() snapshot {
+			result, _ := poller.onLoop(func() (interface{}, error) {
+				s := snapshot{paused: poller.paused, muted: make(map[int64]bool)}
+				for gid := range poller.muted {
+					s.muted[gid] = true
+				}
+				if gid, due, ok := poller.scheduler.Next(); ok && !due.After(time.Now()) {
+					s.due = append(s.due, gid)
+				}
+				return s, nil
+			})
+			return result.(snapshot)
+		}
+
+		tests := []struct {
+			name    string
+			method  string
+			params  interface{}
+			want    snapshot
+			wantErr bool
+		}{
+			{name: "pause", method: "pause", want: snapshot{paused: true, muted: map[int64]bool{}}},
+			{name: "poll-now paused", method: "poll-now", want: snapshot{paused: true, muted: map[int64]bool{}}, wantErr: true},
+			{name: "resume", method: "resume", want: snapshot{muted: map[int64]bool{}}},
+			{name: "poll-now group", method: "poll-now", params: groupParams{Group: "Team Beta"}, want: snapshot{muted: map[int64]bool{}, due: []int64{2}}},
+			{name: "poll-now unknown group", method: "poll-now", params: groupParams{Group: "Team Gamma"}, want: snapshot{muted: map[int64]bool{}, due: []int64{2}}, wantErr: true},
+			{name: "mute by id", method: "mute", params: groupParams{Group: "1"}, want: snapshot{muted: map[int64]bool{1: true}, due: []int64{2}}},
+			{name: "unmute by name", method: "unmute", params: groupParams{Group: "Team Alpha"}, want: snapshot{muted: map[int64]bool{}, due: []int64{2}}},
+			{name: "unmute muted in configuration", method: "unmute", params: groupParams{Group: "Team Beta"}, want: snapshot{muted: map[int64]bool{}, due: []int64{2}}, wantErr: true},
+			{name: "mute unknown group", method: "mute", params: groupParams{Group: "Team Gamma"}, want: snapshot{muted: map[int64]bool{}, due: []int64{2}}, wantErr: true},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				err := CallControl(ControlPath(DefaultAccount), tt.method, tt.params, nil)
+				if (err != nil) != tt.wantErr {
+					t.Errorf("%s error = %v, wantErr %v", tt.method, err, tt.wantErr)
+				}
+				if got := current(); !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("%s = %+v, want %+v", tt.method, got, tt.want)
+				}
+			})
+		}
+
+		// groups are listed by name along with whether they are muted (at runtime or in the configuration)
+		if err := CallControl(ControlPath(DefaultAccount), "mute", groupParams{Group: "Team Alpha"}, nil); err != nil {
+			t.Fatalf("mute error = %v", err)
+		}
+		var groups []ControlGroup
+		if err := CallControl(ControlPath(DefaultAccount), "groups", nil, &groups); err != nil {
+			t.Fatalf("groups error = %v", err)
+		}
+		wantGroups := []ControlGroup{
+			{ID: 1, Name: "Team Alpha", Interval: 15, Muted: true},
+			{ID: 2, Name: "Team Beta", Interval: 15, Muted: true},
+		}
+		if !reflect.DeepEqual(groups, wantGroups) {
+			t.Errorf("groups = %+v, want %+v", groups, wantGroups)
+		}
+	})
+}
+
+func TestPoller_control_messages(t *testing.T) {
+	withPoller(t, Settings{}, func(poller *Poller) {
+		_, _ = poller.onLoop(func() (interface{}, error) {
+			user := &User{YammerUserResponse: YammerUserResponse{FullName: "Jane Doe"}}
+			for _, id := range []int64{11, 13, 12} {
+				poller.remember("Team Alpha", &Message{YammerMessage{ID: id}}, user)
+			}
+			return nil, nil
+		})
+
+		tests := []struct {
+			name   string
+			params interface{}
+			want   []int64
+		}{
+			{name: "default count", want: []int64{13, 12, 11}},
+			{name: "count", params: map[string]int{"count": 2}, want: []int64{13, 12}},
+			{name: "all", params: map[string]int{"count": -1}, want: []int64{13, 12, 11}},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				var messages []ControlMessage
+				if err := CallControl(ControlPath(DefaultAccount), "messages", tt.params, &messages); err != nil {
+					t.Fatalf("messages error = %v", err)
+				}
+				var got []int64
+				for _, message := range messages {
+					if message.Sender != "Jane Doe" || message.Group != "Team Alpha" {
+						t.Errorf("messages = %+v, want sender and group", message)
+					}
+					got = append(got, message.ID)
+				}
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("messages = %v, want %v", got, tt.want)
+				}
+			})
+		}
+	})
+}
+
+func TestPoller_control_logLevel(t *testing.T) {
+	previous := zerolog.GlobalLevel()
+	defer zerolog.SetGlobalLevel(previous)
+	zerolog.SetGlobalLevel(zerolog.InfoLevel)
+
+	withPoller(t, Settings{}, func(poller *Poller) {
+		var got string
+		if err := CallControl(ControlPath(DefaultAccount), "log-level", map[string]string{"level": "debug"}, &got); err != nil || got != "info" {
+			t.Errorf("log-level = %s, %v, want info", got, err)
+		}
+		if zerolog.GlobalLevel() != zerolog.DebugLevel {
+			t.Errorf("log-level set level %s, want debug", zerolog.GlobalLevel())
+		}
+		if err := CallControl(ControlPath(DefaultAccount), "log-level", map[string]string{"level": "loud"}, &got); err == nil {
+			t.Errorf("log-level with unknown level succeeded, want error")
+		}
+		if err := CallControl(ControlPath(DefaultAccount), "log-level", nil, &got); err == nil {
+			t.Errorf("log-level without level succeeded, want error")
+		}
+	})
+}
+
+func TestPoller_Reload(t *testing.T) {
+	withPoller(t, Settings{}, func(poller *Poller) {
+		if err := poller.store.Set(DefaultAccount, "new token"); err != nil {
+			t.Fatal(err)
+		}
+		options := testPollOptions(Settings{})
+		options.RateLimit = 20
+		options.Backlog = 5
+		poller.Reload(options)
+
+		result, _ := poller.onLoop(func() (interface{}, error) {
+			return []interface{}{poller.client.Token, poller.rateLimit, poller.backlog}, nil
+		})
+		if want := []interface{}{"new token", uint(20), uint(5)}; !reflect.DeepEqual(result, want) {
+			t.Errorf("Reload() = %v, want %v", result, want)
+		}
+
+		// reloading a poller which stopped returns immediately
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		stopped, errPoller := NewPoller(ctx, &PollerEnv{Store: poller.store}, DefaultAccount, "token", os.TempDir(), options)
+		if errPoller != nil {
+			t.Fatalf("NewPoller() failed: %v", errPoller)
+		}
+		for i := 0; i < cap(stopped.events)+1; i++ {
+			stopped.Reload(options)
+		}
+	})
+}
+
+// recordingNotifier records the notifications sent (supporting all optional features).
+type recordingNotifier struct {
+	notifications []Notification
+}
+
+func (notifier *recordingNotifier) Notify(notification Notification) error {
+	notifier.notifications = append(notifier.notifications, notification)
+	return nil
+}
+
+func (notifier *recordingNotifier) capabilities() Capabilities {
+	return Capabilities{Images: true, Actions: true, Tags: true}
+}
+
+func TestPoller_notifyMessage(t *testing.T) {
+	group := YammerGroup{ID: 1, FullName: "Team Alpha"}
+	user := &User{YammerUserResponse: YammerUserResponse{FullName: "Jane Doe"}}
+
+	tests := []struct {
+		name       string
+		notifyEach bool
+		wantTags   []string
+		wantMore   []bool
+	}{
+		{name: "per group", wantTags: []string{"default-group-1", "default-group-1"}, wantMore: []bool{false, true}},
+		{name: "each", notifyEach: true, wantTags: []string{"default-message-11", "default-message-12"}, wantMore: []bool{false, false}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withStateDir(t, func(dir string) {
+				withEnv(map[string]string{"XDG_STATE_HOME": dir}, func() {
+
+					// the poller stopped (i.e. nothing runs its events)
+					ctx, cancel := context.WithCancel(context.Background())
+					cancel()
+					notifier := &recordingNotifier{}
+					options := testPollOptions(Settings{})
+					options.Notify = true
+					options.NotifyEach = tt.notifyEach
+					poller, errPoller := NewPoller(ctx, &PollerEnv{Notifier: notifier}, DefaultAccount, "token", dir, options)
+					if errPoller != nil {
+						t.Fatalf("NewPoller() failed: %v", errPoller)
+					}
+
+					for _, id := range []int64{11, 12} {
+						poller.notifyMessage(group, &Message{YammerMessage{ID: id}}, user, UrgencyNormal, 0)
+					}
+					if len(notifier.notifications) != 2 {
+						t.Fatalf("notifyMessage() sent %d notifications, want 2", len(notifier.notifications))
+					}
+					for i, notification := range notifier.notifications {
+						if notification.Tag != tt.wantTags[i] {
+							t.Errorf("notifyMessage() tag = %s, want %s", notification.Tag, tt.wantTags[i])
+						}
+						if more := strings.Contains(notification.Body, "... and 1 more"); more != tt.wantMore[i] {
+							t.Errorf("notifyMessage() body = %q, want more %v", notification.Body, tt.wantMore[i])
+						}
+						var keys []string
+						for _, action := range notification.Actions {
+							keys = append(keys, action.Key)
+						}
+						if want := []string{"default", "like", "read", "mute"}; !reflect.DeepEqual(keys, want) {
+							t.Errorf("notifyMessage() actions = %v, want %v", keys, want)
+						}
+					}
+
+					// clicks do not block while nothing runs the events (they are dropped once the queue is full)
+					notification := notifier.notifications[0]
+					for i := 0; i < cap(poller.events)+1; i++ {
+						notification.OnAction("default")
+						notification.OnClosed()
+					}
+				})
+			})
+		})
+	}
+}
+
+func TestPoller_pollWorker(t *testing.T) {
+	var mutex sync.Mutex
+	requests := make(map[string]int)
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		requests[r.URL.Path]++
+		mutex.Unlock()
+
+		switch r.URL.Path {
+		case "/messages/in_group/42.json":
+			ymr := YammerMessageResponse{Messages: []YammerMessage{{ID: 4, SenderID: 7}, {ID: 3, SenderID: 8}, {ID: 2, SenderID: 7}}}
+			_ = json.NewEncoder(w).Encode(ymr)
+		case "/users/7.json":
+			_, _ = fmt.Fprintf(w, `{"id": 7, "full_name": "Jane Doe", "mugshot_url": "%s/mugshot/7.jpg"}`, server.URL)
+		case "/mugshot/7.jpg":
+			_, _ = fmt.Fprint(w, "jpeg")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	withStateDir(t, func(dir string) {
+		withEnv(map[string]string{"XDG_STATE_HOME": dir}, func() {
+			poller, errPoller := NewPoller(context.Background(), &PollerEnv{}, DefaultAccount, "token", dir, testPollOptions(Settings{}))
+			if errPoller != nil {
+				t.Fatalf("NewPoller() failed: %v", errPoller)
+			}
+			poller.client.BaseURL, _ = url.Parse(server.URL + "/")
+			poller.client.SetRateLimit(100, time.Second)
+			poller.messages.SetLatest(42, 1)
+
+			jobs := make(chan int64)
+			results := make(chan pollResult)
+			go poller.pollWorker(jobs, results)
+			jobs <- 42
+			result := <-results
+			close(jobs)
+
+			if result.err != nil || len(result.newMessages) != 3 {
+				t.Fatalf("pollWorker() = %d messages, %v, want 3", len(result.newMessages), result.err)
+			}
+
+			// the senders are fetched once each, those which could not be fetched are nil
+			if len(result.senders) != 2 || result.senders[7] == nil || result.senders[7].FullName != "Jane Doe" || result.senders[8] != nil {
+				t.Errorf("pollWorker() senders = %v, want Jane Doe and nil", result.senders)
+			}
+			mutex.Lock()
+			defer mutex.Unlock()
+			if requests["/users/7.json"] != 1 || requests["/users/8.json"] != 1 {
+				t.Errorf("pollWorker() requested users %v, want each once", requests)
+			}
+		})
+	})
+}
+
+func TestPoller_Reload_login(t *testing.T) {
+	withPoller(t, Settings{}, func(poller *Poller) {
+		if err := poller.store.Set(DefaultAccount, "new token"); err != nil {
+			t.Fatal(err)
+		}
+
+		// reload while logging in again picks up the token from the store
+		_, _ = poller.onLoop(func() (interface{}, error) {
+			poller.loginInBackground()
+			return nil, nil
+		})
+		poller.Reload(testPollOptions(Settings{}))
+
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			result, _ := poller.onLoop(func() (interface{}, error) {
+				return poller.loggingIn, nil
+			})
+			if result == false {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatal("logging in again did not finish")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		if token := poller.client.token(); token != "new token" {
+			t.Errorf("token after reload and login = %s, want new token", token)
+		}
+	})
+}
+
+func TestPoller_refreshGroupList(t *testing.T) {
+	var mutex sync.Mutex
+	requests := 0
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		requests++
+		mutex.Unlock()
+		<-release
+		_ = json.NewEncoder(w).Encode(YammerGroupResponse{{ID: 1, FullName: "Team Alpha"}})
+	}))
+	defer server.Close()
+
+	withStateDir(t, func(dir string) {
+		withEnv(map[string]string{"XDG_STATE_HOME": dir}, func() {
+			poller, errPoller := NewPoller(context.Background(), &PollerEnv{}, DefaultAccount, "token", dir, testPollOptions(Settings{}))
+			if errPoller != nil {
+				t.Fatalf("NewPoller() failed: %v", errPoller)
+			}
+			poller.client.BaseURL, _ = url.Parse(server.URL + "/")
+			poller.client.SetRateLimit(100, time.Second)
+			poller.user = &User{YammerUserResponse: YammerUserResponse{ID: 5}}
+
+			// a refresh is skipped while another one is in progress
+			poller.refreshGroupList()
+			poller.refreshGroupList()
+			close(release)
+			update := <-poller.groupUpdates
+			if update.err != nil || len(*update.groups) != 2 {
+				t.Errorf("refreshGroupList() = %v, %v, want Team Alpha and Private", update.groups, update.err)
+			}
+			mutex.Lock()
+			if requests != 1 {
+				t.Errorf("refreshGroupList() requested groups %d times, want 1", requests)
+			}
+			mutex.Unlock()
+
+			// once polling stopped, the outcome is dropped
+			poller.refreshing = false
+			close(poller.done)
+			poller.refreshGroupList()
+			deadline := time.Now().Add(5 * time.Second)
+			for {
+				mutex.Lock()
+				n := requests
+				mutex.Unlock()
+				if n == 2 || time.Now().After(deadline) {
+					break
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+		})
+	})
+}
+
+func TestPoller_handleMessages_away(t *testing.T) {
+	group := YammerGroup{ID: 1, FullName: "Team Alpha"}
+	currentUser := &User{YammerUserResponse: YammerUserResponse{ID: 5, FullName: "Me"}}
+	senders := map[int64]*User{5: currentUser, 7: {YammerUserResponse: YammerUserResponse{ID: 7, FullName: "Jane Doe"}}}
+
+	tests := []struct {
+		name    string
+		senders []int64
+		want    []string
+	}{
+		{name: "own messages only", senders: []int64{5, 5}},
+		{name: "others", senders: []int64{7, 5, 7}, want: []string{"2 messages in Team Alpha while you were away."}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withStateDir(t, func(dir string) {
+				withEnv(map[string]string{"XDG_STATE_HOME": dir}, func() {
+					notifier := &recordingNotifier{}
+					options := testPollOptions(Settings{})
+					options.Notify = true
+					options.Backlog = 20
+					poller, errPoller := NewPoller(context.Background(), &PollerEnv{Notifier: notifier}, DefaultAccount, "token", dir, options)
+					if errPoller != nil {
+						t.Fatalf("NewPoller() failed: %v", errPoller)
+					}
+
+					var messages []*Message
+					for i, sender := range tt.senders {
+						messages = append(messages, &Message{YammerMessage{ID: int64(i + 1), SenderID: sender, Body: YammerMessageBody{Plain: "hello"}}})
+					}
+					poller.handleMessages(group, messages, senders, currentUser, true)
+
+					var got []string
+					for _, notification := range notifier.notifications {
+						got = append(got, notification.Body)
+					}
+					if !reflect.DeepEqual(got, tt.want) {
+						t.Errorf("handleMessages() notified %q, want %q", got, tt.want)
+					}
+				})
+			})
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// NewMessage is a message to post (see PostMessage). Without group, reply and recipients, it goes to the "All
+// Company" group.
+type NewMessage struct {
+	Body string
+
+	// the group to post to (0 for none)
+	GroupID int64
+
+	// the message replied to (0 for none)
+	RepliedToID int64
+
+	// the users to send a direct message to
+	DirectToUserIDs []int64
+
+	// the topics to tag the message with
+	Topics []string
+
+	// the ids of attachments uploaded before (see UploadAttachment)
+	PendingAttachments []int64
+}
+
+// MarshalJSON returns the parameters of the message as expected by Yammer (i.e. numbered topics and attachments).
+func (message *NewMessage) MarshalJSON() ([]byte, error) {
+	params := map[string]interface{}{"body": message.Body}
+	if message.GroupID != 0 {
+		params["group_id"] = message.GroupID
+	}
+	if message.RepliedToID != 0 {
+		params["replied_to_id"] = message.RepliedToID
+	}
+	if len(message.DirectToUserIDs) > 0 {
+		ids := make([]string, len(message.DirectToUserIDs))
+		for i, id := range message.DirectToUserIDs {
+			ids[i] = strconv.FormatInt(id, 10)
+		}
+		params["direct_to_user_ids"] = strings.Join(ids, ",")
+	}
+	for i, topic := range message.Topics {
+		params[fmt.Sprintf("topic%d", i+1)] = topic
+	}
+	for i, id := range message.PendingAttachments {
+		params[fmt.Sprintf("pending_attachment%d", i+1)] = id
+	}
+	return json.Marshal(params)
+}
+
+// YammerAttachment is an uploaded attachment not yet attached to a message.
+type YammerAttachment struct {
+	ID          int64  `json:"id"`
+	Name        string `json:"name"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+}
+
+// PostMessage posts the given message and returns it as created.
+func (c *Client) PostMessage(ctx context.Context, message *NewMessage) (*YammerMessage, error) {
+	req, errReq := c.newRequest(ctx, "POST", "messages.json", nil, message)
+	if errReq != nil {
+		return nil, fmt.Errorf("failed to construct message request: %v", errReq)
+	}
+	var ymr YammerMessageResponse
+	_, errDo := c.do(req, &ymr)
+	if errDo != nil {
+		return nil, fmt.Errorf("failed to do message request: %v", errDo)
+	}
+	if len(ymr.Messages) < 1 {
+		return nil, fmt.Errorf("the message request returned no message")
+	}
+	return &ymr.Messages[0], nil
+}
+
+// DumpPost writes the request PostMessage would send (without the token) to the given writer.
+func (c *Client) DumpPost(message *NewMessage, w io.Writer) error {
+	body, errJson := json.MarshalIndent(message, "", "  ")
+	if errJson != nil {
+		return fmt.Errorf("failed to encode message: %v", errJson)
+	}
+	u := c.BaseURL.ResolveReference(&url.URL{Path: "messages.json"})
+	_, errWrite := fmt.Fprintf(w, "POST %s\nContent-Type: application/json\n\n%s\n", u.String(), body)
+	return errWrite
+}
+
+// UploadAttachment uploads a file with the given name and content, which can be attached to a message afterwards (see
+// NewMessage).
+func (c *Client) UploadAttachment(ctx context.Context, name string, content []byte) (*YammerAttachment, error) {
+
+	// construct the multipart body
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, errPart := writer.CreateFormFile("attachment", name)
+	if errPart != nil {
+		return nil, fmt.Errorf("failed to construct upload of %s: %v", name, errPart)
+	}
+	_, _ = part.Write(content)
+	errClose := writer.Close()
+	if errClose != nil {
+		return nil, fmt.Errorf("failed to construct upload of %s: %v", name, errClose)
+	}
+
+	// construct request (rewindable for retries)
+	req, errReq := c.newRequest(ctx, "POST", "pending_attachments", nil, nil)
+	if errReq != nil {
+		return nil, fmt.Errorf("failed to construct upload request: %v", errReq)
+	}
+	data := body.Bytes()
+	req.Body = ioutil.NopCloser(bytes.NewReader(data))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(data)), nil
+	}
+	req.ContentLength = int64(len(data))
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+
+	var attachment YammerAttachment
+	_, errDo := c.do(req, &attachment)
+	if errDo != nil {
+		return nil, fmt.Errorf("failed to upload %s: %v", name, errDo)
+	}
+	return &attachment, nil
+}
+
+// GetUserByEmail returns the user with the given email address.
+func (c *Client) GetUserByEmail(ctx context.Context, email string) (*YammerUserResponse, error) {
+	req, errReq := c.newRequest(ctx, "GET", "users/by_email.json", map[string]string{"email": email}, nil)
+	if errReq != nil {
+		return nil, fmt.Errorf("failed to construct user request for %s: %v", email, errReq)
+	}
+	var users []YammerUserResponse
+	_, errDo := c.do(req, &users)
+	if errDo != nil {
+		if statusErr, ok := errDo.(*StatusError); ok && statusErr.StatusCode == http.StatusNotFound {
+			return nil, fmt.Errorf("no user with email address %s", email)
+		}
+		return nil, fmt.Errorf("failed to do user request for %s: %v", email, errDo)
+	}
+	if len(users) < 1 {
+		return nil, fmt.Errorf("no user with email address %s", email)
+	}
+	return &users[0], nil
+}
@@ -0,0 +1,141 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNewMessage_MarshalJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		message NewMessage
+		want    map[string]interface{}
+	}{
+		{name: "all company", message: NewMessage{Body: "hello"}, want: map[string]interface{}{"body": "hello"}},
+		{
+			name:    "group with topics",
+			message: NewMessage{Body: "hello", GroupID: 42, Topics: []string{"go", "yammer"}},
+			want:    map[string]interface{}{"body": "hello", "group_id": 42.0, "topic1": "go", "topic2": "yammer"},
+		},
+		{
+			name:    "reply with attachments",
+			message: NewMessage{Body: "hello", RepliedToID: 7, PendingAttachments: []int64{1, 2}},
+			want:    map[string]interface{}{"body": "hello", "replied_to_id": 7.0, "pending_attachment1": 1.0, "pending_attachment2": 2.0},
+		},
+		{
+			name:    "direct message",
+			message: NewMessage{Body: "hello", DirectToUserIDs: []int64{3, 4}},
+			want:    map[string]interface{}{"body": "hello", "direct_to_user_ids": "3,4"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, errJson := json.Marshal(&tt.message)
+			if errJson != nil {
+				t.Fatalf("MarshalJSON() error = %v", errJson)
+			}
+			var got map[string]interface{}
+			_ = json.Unmarshal(data, &got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("MarshalJSON() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// fakeApi is a local fake of the parts of the Yammer API used for posting.
+type fakeApi struct {
+	posted   map[string]interface{}
+	uploaded map[string][]byte
+}
+
+func (api *fakeApi) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Bearer secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	switch {
+	case r.Method == "POST" && r.URL.Path == "/messages.json":
+		if r.Header.Get("Content-Type") != "application/json" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		_ = json.NewDecoder(r.Body).Decode(&api.posted)
+		_, _ = fmt.Fprintf(w, `{"messages": [{"id": 99, "body": {"plain": %q}, "web_url": "https://www.yammer.com/example/threads/99"}]}`, api.posted["body"])
+	case r.Method == "POST" && r.URL.Path == "/pending_attachments":
+		file, header, errFile := r.FormFile("attachment")
+		if errFile != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		content, _ := ioutil.ReadAll(file)
+		api.uploaded[header.Filename] = content
+		_, _ = fmt.Fprintf(w, `{"id": %d, "name": %q, "size": %d}`, len(api.uploaded), header.Filename, len(content))
+	case r.Method == "GET" && r.URL.Path == "/users/by_email.json":
+		if r.URL.Query().Get("email") != "jane@example.com" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = fmt.Fprint(w, `[{"id": 3, "full_name": "Jane Doe"}]`)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestClient_PostMessage(t *testing.T) {
+	api := &fakeApi{uploaded: make(map[string][]byte)}
+	server := httptest.NewServer(api)
+	defer server.Close()
+	client := newTestClient(server)
+	ctx := context.Background()
+
+	attachment, errUpload := client.UploadAttachment(ctx, "notes.txt", []byte("some notes"))
+	if errUpload != nil {
+		t.Fatalf("UploadAttachment() error = %v", errUpload)
+	}
+	if attachment.ID != 1 || attachment.Size != 10 || string(api.uploaded["notes.txt"]) != "some notes" {
+		t.Errorf("UploadAttachment() = %+v, uploaded %v", attachment, api.uploaded)
+	}
+
+	user, errUser := client.GetUserByEmail(ctx, "jane@example.com")
+	if errUser != nil || user.ID != 3 {
+		t.Errorf("GetUserByEmail() = %v, %v, want user 3", user, errUser)
+	}
+	if _, err := client.GetUserByEmail(ctx, "john@example.com"); err == nil {
+		t.Errorf("GetUserByEmail() of an unknown address succeeded, want error")
+	}
+
+	message := &NewMessage{Body: "hello", DirectToUserIDs: []int64{user.ID}, PendingAttachments: []int64{attachment.ID}}
+	posted, errPost := client.PostMessage(ctx, message)
+	if errPost != nil {
+		t.Fatalf("PostMessage() error = %v", errPost)
+	}
+	if posted.ID != 99 || posted.Body.Plain != "hello" {
+		t.Errorf("PostMessage() = %+v, want message 99", posted)
+	}
+	want := map[string]interface{}{"body": "hello", "direct_to_user_ids": "3", "pending_attachment1": 1.0}
+	if !reflect.DeepEqual(api.posted, want) {
+		t.Errorf("PostMessage() sent %v, want %v", api.posted, want)
+	}
+
+	// nothing is sent when dumping
+	api.posted = nil
+	var dump bytes.Buffer
+	if err := client.DumpPost(&NewMessage{Body: "dry", GroupID: 42}, &dump); err != nil {
+		t.Fatalf("DumpPost() error = %v", err)
+	}
+	if !strings.HasPrefix(dump.String(), "POST "+server.URL+"/messages.json\n") || !strings.Contains(dump.String(), `"group_id": 42`) {
+		t.Errorf("DumpPost() = %s", dump.String())
+	}
+	if strings.Contains(dump.String(), "secret") || api.posted != nil {
+		t.Errorf("DumpPost() leaked the token or sent the message")
+	}
+}
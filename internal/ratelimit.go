@@ -0,0 +1,153 @@
+package internal
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultRateRequests is the default number of requests allowed per DefaultRatePeriod (Yammer allows 10
+	// message requests in 30 seconds per user).
+	DefaultRateRequests = 10
+	DefaultRatePeriod   = 30 * time.Second
+
+	// bounds of the exponential backoff
+	minBackoff = 1 * time.Second
+	maxBackoff = 5 * time.Minute
+)
+
+// tokenBucket is a request budget shared by all users of a client. Tokens are refilled at a constant rate up to the
+// capacity and every request takes one token (blocking until one is available).
+type tokenBucket struct {
+	mutex    sync.Mutex
+	capacity float64
+	tokens   float64
+	rate     float64 // tokens per second
+	last     time.Time
+	hold     time.Time // no tokens are handed out before this time
+	random   *rand.Rand
+}
+
+// newTokenBucket returns a full bucket allowing the given number of requests per period.
+func newTokenBucket(requests int, period time.Duration) *tokenBucket {
+	if requests < 1 {
+		requests = 1
+	}
+	return &tokenBucket{
+		capacity: float64(requests),
+		tokens:   float64(requests),
+		rate:     float64(requests) / period.Seconds(),
+		last:     time.Now(),
+		random:   rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// setRate changes the number of requests allowed per period (keeping the tokens left and any pause).
+func (bucket *tokenBucket) setRate(requests int, period time.Duration) {
+	if requests < 1 {
+		requests = 1
+	}
+
+	bucket.mutex.Lock()
+	defer bucket.mutex.Unlock()
+
+	// refill at the previous rate up to now
+	now := time.Now()
+	bucket.tokens += now.Sub(bucket.last).Seconds() * bucket.rate
+	bucket.last = now
+
+	bucket.capacity = float64(requests)
+	bucket.rate = float64(requests) / period.Seconds()
+	if bucket.tokens > bucket.capacity {
+		bucket.tokens = bucket.capacity
+	}
+}
+
+// wait blocks until a token is available and takes it (or until the given context is done).
+func (bucket *tokenBucket) wait(ctx context.Context) error {
+	for {
+		delay := bucket.take()
+		if delay <= 0 {
+			return nil
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// take takes a token and returns 0 or returns how long to wait before trying again.
+func (bucket *tokenBucket) take() time.Duration {
+	bucket.mutex.Lock()
+	defer bucket.mutex.Unlock()
+
+	now := time.Now()
+	if now.Before(bucket.hold) {
+		return bucket.hold.Sub(now)
+	}
+
+	// refill
+	bucket.tokens += now.Sub(bucket.last).Seconds() * bucket.rate
+	if bucket.tokens > bucket.capacity {
+		bucket.tokens = bucket.capacity
+	}
+	bucket.last = now
+
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return 0
+	}
+	return time.Duration((1 - bucket.tokens) / bucket.rate * float64(time.Second))
+}
+
+// pause stops handing out tokens for the given duration (e.g. after the server asked to slow down).
+func (bucket *tokenBucket) pause(delay time.Duration) {
+	bucket.mutex.Lock()
+	defer bucket.mutex.Unlock()
+
+	hold := time.Now().Add(delay)
+	if hold.After(bucket.hold) {
+		bucket.hold = hold
+	}
+}
+
+// backoff returns the exponential backoff delay (with jitter) for the given (zero based) attempt.
+func (bucket *tokenBucket) backoff(attempt int) time.Duration {
+	delay := maxBackoff
+	if attempt < 16 {
+		delay = minBackoff << uint(attempt)
+	}
+	if delay > maxBackoff {
+		delay = maxBackoff
+	}
+
+	bucket.mutex.Lock()
+	defer bucket.mutex.Unlock()
+
+	// "equal jitter": half of the delay plus a random share of the other half
+	return delay/2 + time.Duration(bucket.random.Int63n(int64(delay/2)+1))
+}
+
+// retryAfter returns the delay requested by the Retry-After header of the given response (either in seconds or as
+// HTTP date) or 0 if there is none.
+func retryAfter(resp *http.Response) time.Duration {
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0
+	}
+	if seconds, errAtoi := strconv.Atoi(value); errAtoi == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, errDate := http.ParseTime(value); errDate == nil {
+		return time.Until(date)
+	}
+	return 0
+}
@@ -0,0 +1,57 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+)
+
+// LikeMessage likes the given message as the current user.
+func (c *Client) LikeMessage(ctx context.Context, messageId int64) error {
+	return c.react(ctx, "POST", "messages/liked_by/current.json", map[string]string{"message_id": strconv.FormatInt(messageId, 10)}, "like message %d", messageId)
+}
+
+// UnlikeMessage takes back the like of the given message by the current user.
+func (c *Client) UnlikeMessage(ctx context.Context, messageId int64) error {
+	return c.react(ctx, "DELETE", "messages/liked_by/current.json", map[string]string{"message_id": strconv.FormatInt(messageId, 10)}, "unlike message %d", messageId)
+}
+
+// FollowThread makes the current user follow the given thread.
+func (c *Client) FollowThread(ctx context.Context, threadId int64) error {
+	return c.react(ctx, "POST", "subscriptions.json", threadTarget(threadId), "follow thread %d", threadId)
+}
+
+// UnfollowThread makes the current user stop following the given thread.
+func (c *Client) UnfollowThread(ctx context.Context, threadId int64) error {
+	return c.react(ctx, "DELETE", "subscriptions.json", threadTarget(threadId), "unfollow thread %d", threadId)
+}
+
+// MarkSeen marks the given message (and the messages before it in its feed) as seen by the current user.
+func (c *Client) MarkSeen(ctx context.Context, messageId int64) error {
+	return c.react(ctx, "POST", "messages/last_seen.json", map[string]string{"message_id": strconv.FormatInt(messageId, 10)}, "mark message %d seen", messageId)
+}
+
+// MarkThreadSeen marks all messages of the given thread as seen by the current user.
+func (c *Client) MarkThreadSeen(ctx context.Context, threadId int64) error {
+	return c.react(ctx, "POST", fmt.Sprintf("threads/%d/mark_seen.json", threadId), nil, "mark thread %d seen", threadId)
+}
+
+// threadTarget returns the parameters addressing the given thread as subscription target.
+func threadTarget(threadId int64) map[string]string {
+	return map[string]string{"target_type": "thread", "target_id": strconv.FormatInt(threadId, 10)}
+}
+
+// react does a request without response (but the status) on behalf of the current user. The description (formatted
+// with the given id) is used in error messages.
+func (c *Client) react(ctx context.Context, method string, path string, query map[string]string, description string, id int64) error {
+	what := fmt.Sprintf(description, id)
+	req, errReq := c.newRequest(ctx, method, path, query, nil)
+	if errReq != nil {
+		return fmt.Errorf("failed to construct request to %s: %v", what, errReq)
+	}
+	errCall := c.call(req)
+	if errCall != nil {
+		return fmt.Errorf("failed to %s: %v", what, errCall)
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package internal
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClient_react(t *testing.T) {
+	var got string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Method + " " + r.URL.Path
+		if r.URL.RawQuery != "" {
+			got += "?" + r.URL.RawQuery
+		}
+		switch r.Method {
+		case "POST":
+			w.WriteHeader(http.StatusCreated)
+		case "DELETE":
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer server.Close()
+	client := newTestClient(server)
+	ctx := context.Background()
+
+	tests := []struct {
+		name  string
+		react func() error
+		want  string
+	}{
+		{name: "like", react: func() error { return client.LikeMessage(ctx, 42) }, want: "POST /messages/liked_by/current.json?message_id=42"},
+		{name: "unlike", react: func() error { return client.UnlikeMessage(ctx, 42) }, want: "DELETE /messages/liked_by/current.json?message_id=42"},
+		{name: "follow", react: func() error { return client.FollowThread(ctx, 7) }, want: "POST /subscriptions.json?target_id=7&target_type=thread"},
+		{name: "unfollow", react: func() error { return client.UnfollowThread(ctx, 7) }, want: "DELETE /subscriptions.json?target_id=7&target_type=thread"},
+		{name: "seen", react: func() error { return client.MarkSeen(ctx, 42) }, want: "POST /messages/last_seen.json?message_id=42"},
+		{name: "thread seen", react: func() error { return client.MarkThreadSeen(ctx, 7) }, want: "POST /threads/7/mark_seen.json"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.react(); err != nil {
+				t.Errorf("error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("sent %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClient_reactFailed(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	err := newTestClient(server).LikeMessage(context.Background(), 42)
+	if err == nil || err.Error() != "failed to like message 42: response status 404" {
+		t.Errorf("LikeMessage() error = %v, want status error", err)
+	}
+}
@@ -0,0 +1,80 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// DiffOptions returns the changes between the given options (e.g. after reloading the configuration) which can be
+// applied while polling (as "<name>: <old> -> <new>") and the names of the options which changed but require a restart.
+func DiffOptions(old *PollOptions, new *PollOptions) ([]string, []string) {
+	var changes []string
+	changed := func(name string, before interface{}, after interface{}) {
+		beforeText, afterText := describeOption(before), describeOption(after)
+		if beforeText != afterText {
+			changes = append(changes, fmt.Sprintf("%s: %s -> %s", name, beforeText, afterText))
+		}
+	}
+	changed("interval", old.Interval, new.Interval)
+	changed("min-interval", old.Bounds.Min, new.Bounds.Min)
+	changed("max-interval", old.Bounds.Max, new.Bounds.Max)
+	changed("group intervals", describeIntervals(old.GroupIntervals), describeIntervals(new.GroupIntervals))
+	changed("included groups", old.Includes, new.Includes)
+	changed("excluded groups", old.Excludes, new.Excludes)
+	changed("backlog", old.Backlog, new.Backlog)
+	changed("rate-limit", old.RateLimit, new.RateLimit)
+	changed("timeout", old.Timeout, new.Timeout)
+	changed("max-pages", old.MaxPages, new.MaxPages)
+	changed("refresh-groups", old.RefreshGroups, new.RefreshGroups)
+	changed("output", old.Output, new.Output)
+	changed("client_id", old.ClientID, new.ClientID)
+	changed("notifications", old.Notify, new.Notify)
+	changed("notification per message", old.NotifyEach, new.NotifyEach)
+	changed("group settings", old.Settings.Groups, new.Settings.Groups)
+
+	var restart []string
+	for name, same := range map[string]bool{
+		"accounts":    describeOption(old.Accounts) == describeOption(new.Accounts),
+		"concurrency": old.Concurrency == new.Concurrency,
+		"notifier":    old.Notifier == new.Notifier,
+		"token-store": old.TokenStore == new.TokenStore,
+	} {
+		if !same {
+			restart = append(restart, name)
+		}
+	}
+	sort.Strings(restart)
+	return changes, restart
+}
+
+// KeepRestartOptions sets the options which require a restart (see DiffOptions) to the values of the given options in
+// effect.
+func (options *PollOptions) KeepRestartOptions(current *PollOptions) {
+	options.Accounts = current.Accounts
+	options.Concurrency = current.Concurrency
+	options.Notifier = current.Notifier
+	options.TokenStore = current.TokenStore
+}
+
+// describeOption returns the given option value as text (maps and slices as JSON).
+func describeOption(value interface{}) string {
+	switch value.(type) {
+	case []string, map[string]GroupSettings:
+		data, _ := json.Marshal(value)
+		return string(data)
+	default:
+		return fmt.Sprint(value)
+	}
+}
+
+// describeIntervals returns the given interval bounds by group as text (sorted by group).
+func describeIntervals(intervals map[string]IntervalBounds) string {
+	var specs []string
+	for group, bounds := range intervals {
+		specs = append(specs, fmt.Sprintf("%s=%s:%s", group, bounds.Min, bounds.Max))
+	}
+	sort.Strings(specs)
+	return "[" + strings.Join(specs, ", ") + "]"
+}
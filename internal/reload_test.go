@@ -0,0 +1,83 @@
+package internal
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDiffOptions(t *testing.T) {
+	tests := []struct {
+		name        string
+		change      func(options *PollOptions)
+		wantChanges []string
+		wantRestart []string
+	}{
+		{name: "unchanged", change: func(options *PollOptions) {}},
+		{
+			name: "applied while polling",
+			change: func(options *PollOptions) {
+				options.Interval = 20 * time.Second
+				options.Bounds.Max = 10 * time.Minute
+				options.GroupIntervals = map[string]IntervalBounds{"Team Beta": {Min: time.Minute, Max: time.Hour}, "1": {Min: time.Second, Max: time.Minute}}
+				options.Includes = []string{"/^Team/"}
+				options.RateLimit = 5
+				options.NotifyEach = true
+				options.Settings.Groups = map[string]GroupSettings{"Team Alpha": {Mute: true}}
+			},
+			wantChanges: []string{
+				"interval: 10s -> 20s",
+				"max-interval: 5m0s -> 10m0s",
+				"group intervals: [] -> [1=1s:1m0s, Team Beta=1m0s:1h0m0s]",
+				"included groups: null -> [\"/^Team/\"]",
+				"rate-limit: 10 -> 5",
+				"notification per message: false -> true",
+				"group settings: null -> {\"Team Alpha\":{\"Mute\":true,\"Priority\":\"\",\"MinInterval\":null,\"MaxInterval\":null}}",
+			},
+		},
+		{
+			name: "requiring a restart",
+			change: func(options *PollOptions) {
+				options.Accounts = []string{DefaultAccount, "work"}
+				options.Concurrency = 2
+				options.Notifier = NotifierJSON
+				options.TokenStore = StoreFile
+			},
+			wantRestart: []string{"accounts", "concurrency", "notifier", "token-store"},
+		},
+		{
+			name: "both",
+			change: func(options *PollOptions) {
+				options.Timeout = time.Minute
+				options.Concurrency = 8
+			},
+			wantChanges: []string{"timeout: 10s -> 1m0s"},
+			wantRestart: []string{"concurrency"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			old := testPollOptions(Settings{})
+			old.Bounds.Max = 5 * time.Minute
+			old.Notifier = NotifierDBus
+			old.TokenStore = StoreAuto
+			new := *old
+			tt.change(&new)
+
+			changes, restart := DiffOptions(old, &new)
+			if !reflect.DeepEqual(changes, tt.wantChanges) {
+				t.Errorf("DiffOptions() changes = %q, want %q", changes, tt.wantChanges)
+			}
+			if !reflect.DeepEqual(restart, tt.wantRestart) {
+				t.Errorf("DiffOptions() restart = %q, want %q", restart, tt.wantRestart)
+			}
+
+			// after keeping the options which require a restart, only the others differ
+			new.KeepRestartOptions(old)
+			changes, restart = DiffOptions(old, &new)
+			if !reflect.DeepEqual(changes, tt.wantChanges) || restart != nil {
+				t.Errorf("DiffOptions() after KeepRestartOptions() = %q, %q, want %q, none", changes, restart, tt.wantChanges)
+			}
+		})
+	}
+}
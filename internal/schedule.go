@@ -0,0 +1,195 @@
+package internal
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// IntervalBounds are the lower and upper bound of a poll interval.
+type IntervalBounds struct {
+	Min time.Duration
+	Max time.Duration
+}
+
+// ParseGroupInterval parses a per-group interval specification of the form "<group>=<min>:<max>" (in seconds), where
+// group is a group ID or name.
+func ParseGroupInterval(spec string) (string, IntervalBounds, error) {
+	index := strings.LastIndex(spec, "=")
+	if index < 1 {
+		return "", IntervalBounds{}, fmt.Errorf("invalid group interval '%s', expected <group>=<min>:<max>", spec)
+	}
+	group := spec[:index]
+	parts := strings.Split(spec[index+1:], ":")
+	if len(parts) != 2 {
+		return "", IntervalBounds{}, fmt.Errorf("invalid group interval '%s', expected <group>=<min>:<max>", spec)
+	}
+	min, errMin := strconv.ParseUint(parts[0], 10, 32)
+	max, errMax := strconv.ParseUint(parts[1], 10, 32)
+	if errMin != nil || errMax != nil || min < 1 || min > max {
+		return "", IntervalBounds{}, fmt.Errorf("invalid group interval '%s', expected 0 < min <= max seconds", spec)
+	}
+	bounds := IntervalBounds{
+		Min: time.Duration(min) * time.Second,
+		Max: time.Duration(max) * time.Second,
+	}
+	return group, bounds, nil
+}
+
+// schedule is the poll schedule of a single group.
+type schedule struct {
+	interval time.Duration
+	due      time.Time
+	bounds   *IntervalBounds
+	polling  bool
+}
+
+// Scheduler keeps track of when each group is due to be polled next. The poll interval of a group adapts to its
+// activity: it is halved whenever new messages arrived and grows by half whenever there were none, always staying
+// within the bounds of the group and above the poll interval requested by the server. It is not safe for concurrent use.
+type Scheduler struct {
+	interval time.Duration
+	bounds   IntervalBounds
+	groups   map[int64]*schedule
+}
+
+// NewScheduler returns a new Scheduler polling groups initially every interval and within the given default bounds.
+func NewScheduler(interval time.Duration, bounds IntervalBounds) *Scheduler {
+	return &Scheduler{
+		interval: interval,
+		bounds:   bounds,
+		groups:   make(map[int64]*schedule),
+	}
+}
+
+// Add adds a group which is due immediately.
+func (scheduler *Scheduler) Add(groupId int64) {
+	if _, ok := scheduler.groups[groupId]; ok {
+		return
+	}
+	scheduler.groups[groupId] = &schedule{
+		interval: clampInterval(scheduler.interval, scheduler.bounds, 0),
+		due:      time.Now(),
+	}
+}
+
+// Remove removes a group.
+func (scheduler *Scheduler) Remove(groupId int64) {
+	delete(scheduler.groups, groupId)
+}
+
+// SetDefaults changes the initial interval of new groups and the default bounds (applying them to the groups without
+// bounds of their own).
+func (scheduler *Scheduler) SetDefaults(interval time.Duration, bounds IntervalBounds) {
+	scheduler.interval = interval
+	scheduler.bounds = bounds
+	for _, group := range scheduler.groups {
+		if group.bounds == nil {
+			group.clamp(bounds)
+		}
+	}
+}
+
+// ClearBounds makes the given group use the default bounds again.
+func (scheduler *Scheduler) ClearBounds(groupId int64) {
+	if group, ok := scheduler.groups[groupId]; ok && group.bounds != nil {
+		group.bounds = nil
+		group.clamp(scheduler.bounds)
+	}
+}
+
+// SetBounds sets the bounds of the poll interval of the given group.
+func (scheduler *Scheduler) SetBounds(groupId int64, bounds IntervalBounds) {
+	if group, ok := scheduler.groups[groupId]; ok {
+		group.bounds = &bounds
+		group.clamp(bounds)
+	}
+}
+
+// Next returns the group due next and when it is due (false if there are no groups not being polled).
+func (scheduler *Scheduler) Next() (int64, time.Time, bool) {
+	var next int64
+	var due time.Time
+	found := false
+	for groupId, group := range scheduler.groups {
+		if group.polling {
+			continue
+		}
+		if !found || group.due.Before(due) || (group.due.Equal(due) && groupId < next) {
+			next = groupId
+			due = group.due
+			found = true
+		}
+	}
+	return next, due, found
+}
+
+// Start marks the given group as being polled (excluding it from Next until Done is called).
+func (scheduler *Scheduler) Start(groupId int64) {
+	if group, ok := scheduler.groups[groupId]; ok {
+		group.polling = true
+	}
+}
+
+// Done reschedules the given group after it has been polled, given the number of new messages and the poll interval
+// requested by the server (0 if none).
+func (scheduler *Scheduler) Done(groupId int64, newMessages int, requested time.Duration) {
+	group, ok := scheduler.groups[groupId]
+	if !ok {
+		return
+	}
+
+	// busy groups are polled more often, quiet ones less
+	interval := group.interval
+	if newMessages > 0 {
+		interval = interval / 2
+	} else {
+		interval = interval + interval/2
+	}
+
+	bounds := scheduler.bounds
+	if group.bounds != nil {
+		bounds = *group.bounds
+	}
+	group.interval = clampInterval(interval, bounds, requested)
+	group.due = time.Now().Add(group.interval)
+	group.polling = false
+}
+
+// PollNow makes the given group (unless being polled) due immediately.
+func (scheduler *Scheduler) PollNow(groupId int64) {
+	if group, ok := scheduler.groups[groupId]; ok && !group.polling {
+		group.due = time.Now()
+	}
+}
+
+// Interval returns the current poll interval of the given group.
+func (scheduler *Scheduler) Interval(groupId int64) time.Duration {
+	if group, ok := scheduler.groups[groupId]; ok {
+		return group.interval
+	}
+	return 0
+}
+
+// clamp restricts the interval of the group to the given bounds (making the group due earlier if its interval shrank).
+func (group *schedule) clamp(bounds IntervalBounds) {
+	group.interval = clampInterval(group.interval, bounds, 0)
+	if latest := time.Now().Add(group.interval); !group.polling && group.due.After(latest) {
+		group.due = latest
+	}
+}
+
+// clamp restricts the interval to the bounds, never going below the interval requested by the server.
+func clampInterval(interval time.Duration, bounds IntervalBounds, requested time.Duration) time.Duration {
+	if interval > bounds.Max {
+		interval = bounds.Max
+	}
+	if interval < bounds.Min {
+		interval = bounds.Min
+	}
+	if interval < requested {
+		interval = requested
+	}
+	return interval
+}
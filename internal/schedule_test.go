@@ -0,0 +1,86 @@
+package internal
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScheduler_Done(t *testing.T) {
+	scheduler := NewScheduler(20*time.Second, IntervalBounds{Min: 10 * time.Second, Max: 60 * time.Second})
+	scheduler.Add(1)
+	scheduler.Add(2)
+	scheduler.SetBounds(2, IntervalBounds{Min: 30 * time.Second, Max: 40 * time.Second})
+
+	tests := []struct {
+		name        string
+		groupId     int64
+		newMessages int
+		requested   time.Duration
+		want        time.Duration
+	}{
+		{name: "busy", groupId: 1, newMessages: 3, want: 10 * time.Second},
+		{name: "busy at min", groupId: 1, newMessages: 1, want: 10 * time.Second},
+		{name: "quiet", groupId: 1, want: 15 * time.Second},
+		{name: "requested", groupId: 1, requested: 50 * time.Second, want: 50 * time.Second},
+		{name: "quiet at max", groupId: 1, want: 60 * time.Second},
+		{name: "group bounds", groupId: 2, newMessages: 1, want: 30 * time.Second},
+		{name: "group bounds max", groupId: 2, want: 40 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scheduler.Done(tt.groupId, tt.newMessages, tt.requested)
+			if got := scheduler.Interval(tt.groupId); got != tt.want {
+				t.Errorf("Interval() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	// group 2 (40s) is due before group 1 (60s)
+	if next, _, ok := scheduler.Next(); !ok || next != 2 {
+		t.Errorf("Next() = %d, want 2", next)
+	}
+
+	// unless group 1 is to be polled now
+	scheduler.PollNow(1)
+	if next, due, ok := scheduler.Next(); !ok || next != 1 || due.After(time.Now()) {
+		t.Errorf("Next() after PollNow() = %d at %s, want 1 now", next, due)
+	}
+}
+
+func TestScheduler_SetDefaults(t *testing.T) {
+	scheduler := NewScheduler(60*time.Second, IntervalBounds{Min: 10 * time.Second, Max: 60 * time.Second})
+	scheduler.Add(1)
+	scheduler.Add(2)
+	scheduler.SetBounds(2, IntervalBounds{Min: 50 * time.Second, Max: 90 * time.Second})
+
+	// only groups without bounds of their own follow the defaults
+	scheduler.SetDefaults(20*time.Second, IntervalBounds{Min: 10 * time.Second, Max: 30 * time.Second})
+	if got := scheduler.Interval(1); got != 30*time.Second {
+		t.Errorf("Interval() of group 1 = %s, want 30s", got)
+	}
+	if got := scheduler.Interval(2); got != 60*time.Second {
+		t.Errorf("Interval() of group 2 = %s, want 60s", got)
+	}
+
+	// the shrunk interval applies right away
+	if next, due, ok := scheduler.Next(); !ok || next != 1 || due.After(time.Now().Add(30*time.Second)) {
+		t.Errorf("Next() = %d at %s, want 1 within 30s", next, due)
+	}
+
+	scheduler.ClearBounds(2)
+	if got := scheduler.Interval(2); got != 30*time.Second {
+		t.Errorf("Interval() of group 2 after ClearBounds() = %s, want 30s", got)
+	}
+}
+
+func TestParseGroupInterval(t *testing.T) {
+	group, bounds, err := ParseGroupInterval("All Company=60:600")
+	if err != nil || group != "All Company" || bounds.Min != time.Minute || bounds.Max != 10*time.Minute {
+		t.Errorf("ParseGroupInterval() = %s %v %v", group, bounds, err)
+	}
+	for _, spec := range []string{"42", "=1:2", "42=1", "42=3:2", "42=0:2", "42=a:b"} {
+		if _, _, err := ParseGroupInterval(spec); err == nil {
+			t.Errorf("ParseGroupInterval(%s) expected error", spec)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+)
+
+const stateFile = "state.json"
+
+// State is the data structure to persist the poll progress across restarts.
+type State struct {
+	path string
+
+	// id of the latest seen message by group id (-1 indicates private messages)
+	Latest map[int64]int64 `json:"latest"`
+}
+
+// StateDir returns the directory to store state in (following the XDG base directory specification).
+func StateDir() string {
+	dir := os.Getenv("XDG_STATE_HOME")
+	if dir == "" {
+		home, _ := os.UserHomeDir()
+		dir = path.Join(home, ".local", "state")
+	}
+	return path.Join(dir, "goyammer")
+}
+
+// StatePath returns the path of the state file of the given account.
+func StatePath(account string) string {
+	if account == DefaultAccount {
+		return path.Join(StateDir(), stateFile)
+	}
+	return path.Join(StateDir(), fmt.Sprintf("state-%s.json", account))
+}
+
+// LoadState reads the state from the given file. A missing file results in an empty state.
+func LoadState(statePath string) (*State, error) {
+
+	state := &State{
+		path:   statePath,
+		Latest: make(map[int64]int64),
+	}
+
+	data, errRead := ioutil.ReadFile(statePath)
+	if os.IsNotExist(errRead) {
+		return state, nil
+	}
+	if errRead != nil {
+		return nil, fmt.Errorf("failed to read state from %s: %v", statePath, errRead)
+	}
+
+	errJson := json.Unmarshal(data, state)
+	if errJson != nil {
+		return nil, fmt.Errorf("failed to parse state from %s: %v", statePath, errJson)
+	}
+	if state.Latest == nil {
+		state.Latest = make(map[int64]int64)
+	}
+
+	return state, nil
+}
+
+// Save writes the state to its file (atomically, by writing to a temp file first and renaming it).
+func (state *State) Save() error {
+
+	errDir := os.MkdirAll(path.Dir(state.path), 0700)
+	if errDir != nil {
+		return fmt.Errorf("failed to create state directory: %v", errDir)
+	}
+
+	data, errJson := json.Marshal(state)
+	if errJson != nil {
+		return fmt.Errorf("failed to serialize state: %v", errJson)
+	}
+
+	file, errTmp := ioutil.TempFile(path.Dir(state.path), stateFile+".*")
+	if errTmp != nil {
+		return fmt.Errorf("couldn't create temp state file: %v", errTmp)
+	}
+	_, errWrite := file.Write(data)
+	if errWrite != nil {
+		_ = file.Close()
+		_ = os.Remove(file.Name())
+		return fmt.Errorf("couldn't write state to %s: %v", file.Name(), errWrite)
+	}
+	errClose := file.Close()
+	if errClose != nil {
+		_ = os.Remove(file.Name())
+		return fmt.Errorf("couldn't close state file %s: %v", file.Name(), errClose)
+	}
+
+	errRename := os.Rename(file.Name(), state.path)
+	if errRename != nil {
+		_ = os.Remove(file.Name())
+		return fmt.Errorf("failed to move state to %s: %v", state.path, errRename)
+	}
+
+	return nil
+}
@@ -0,0 +1,109 @@
+package internal
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+// withStateDir runs the given test with a temporary directory to hold state files.
+func withStateDir(t *testing.T, test func(dir string)) {
+	dir, errDir := ioutil.TempDir("", "goyammer-test")
+	if errDir != nil {
+		t.Fatal(errDir)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	test(dir)
+}
+
+func TestLoadState(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    map[int64]int64
+		wantErr bool
+	}{
+		{name: "missing", want: map[int64]int64{}},
+		{name: "empty object", content: "{}", want: map[int64]int64{}},
+		{name: "null latest", content: `{"latest":null}`, want: map[int64]int64{}},
+		{name: "latest", content: `{"latest":{"1":10,"-1":20}}`, want: map[int64]int64{1: 10, -1: 20}},
+		{name: "empty", content: " ", wantErr: true},
+		{name: "corrupt", content: `{"latest":{"1":`, wantErr: true},
+		{name: "wrong type", content: `{"latest":[1,2]}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withStateDir(t, func(dir string) {
+				statePath := path.Join(dir, stateFile)
+				if tt.content != "" {
+					if err := ioutil.WriteFile(statePath, []byte(tt.content), 0600); err != nil {
+						t.Fatal(err)
+					}
+				}
+				state, err := LoadState(statePath)
+				if (err != nil) != tt.wantErr {
+					t.Fatalf("LoadState() error = %v, wantErr %v", err, tt.wantErr)
+				}
+				if err != nil {
+					return
+				}
+				if !reflect.DeepEqual(state.Latest, tt.want) {
+					t.Errorf("LoadState() = %v, want %v", state.Latest, tt.want)
+				}
+			})
+		})
+	}
+}
+
+func TestState_Save(t *testing.T) {
+	withStateDir(t, func(dir string) {
+
+		// the directory is created on first save
+		statePath := path.Join(dir, "sub", stateFile)
+		state, errLoad := LoadState(statePath)
+		if errLoad != nil {
+			t.Fatalf("LoadState() error = %v", errLoad)
+		}
+		state.Latest[1] = 10
+		state.Latest[-1] = 20
+		if err := state.Save(); err != nil {
+			t.Fatalf("Save() error = %v", err)
+		}
+
+		// overwriting works and leaves no temp files behind
+		state.Latest[1] = 11
+		if err := state.Save(); err != nil {
+			t.Fatalf("Save() error = %v", err)
+		}
+		files, errDir := ioutil.ReadDir(path.Dir(statePath))
+		if errDir != nil {
+			t.Fatal(errDir)
+		}
+		if len(files) != 1 || files[0].Name() != stateFile {
+			t.Errorf("Save() left %d files, want only %s", len(files), stateFile)
+		}
+
+		loaded, errReload := LoadState(statePath)
+		if errReload != nil {
+			t.Fatalf("LoadState() error = %v", errReload)
+		}
+		if want := map[int64]int64{1: 11, -1: 20}; !reflect.DeepEqual(loaded.Latest, want) {
+			t.Errorf("LoadState() after Save() = %v, want %v", loaded.Latest, want)
+		}
+	})
+}
+
+func TestStatePath(t *testing.T) {
+	withEnv(map[string]string{"XDG_STATE_HOME": "/state"}, func() {
+		if got, want := StatePath(DefaultAccount), "/state/goyammer/state.json"; got != want {
+			t.Errorf("StatePath() = %s, want %s", got, want)
+		}
+		if got, want := StatePath("work"), "/state/goyammer/state-work.json"; got != want {
+			t.Errorf("StatePath() = %s, want %s", got, want)
+		}
+	})
+}
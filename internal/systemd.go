@@ -0,0 +1,160 @@
+package internal
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ServiceName is the name of the systemd user unit polling in the background.
+const ServiceName = "goyammer.service"
+
+// UnitPath returns the path of the systemd user unit (following the XDG base directory specification).
+func UnitPath() string {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, _ := os.UserHomeDir()
+		dir = path.Join(home, ".config")
+	}
+	return path.Join(dir, "systemd", "user", ServiceName)
+}
+
+// Unit returns the systemd user unit running the given command line (which must poll in the foreground).
+func Unit(command []string) string {
+	quoted := make([]string, len(command))
+	for i, arg := range command {
+		quoted[i] = quoteUnitArg(arg)
+	}
+	return fmt.Sprintf(`# generated by 'goyammer service install'
+[Unit]
+Description=Yammer notifications (goyammer)
+Documentation=man:goyammer-poll(1) man:goyammer-service(1)
+PartOf=graphical-session.target
+After=graphical-session.target
+
+[Service]
+Type=notify
+ExecStart=%s
+ExecReload=/bin/kill -HUP $MAINPID
+Restart=on-failure
+RestartSec=30
+WatchdogSec=60
+StandardOutput=journal
+StandardError=journal
+
+[Install]
+WantedBy=graphical-session.target
+`, strings.Join(quoted, " "))
+}
+
+// quoteUnitArg quotes the given argument for a command line in a unit (escaping specifiers and variables).
+func quoteUnitArg(arg string) string {
+	arg = strings.Replace(arg, "%", "%%", -1)
+	arg = strings.Replace(arg, "$", "$$", -1)
+	if arg != "" && !strings.ContainsAny(arg, " \t\n\"'\\;") {
+		return arg
+	}
+	arg = strings.Replace(arg, `\`, `\\`, -1)
+	arg = strings.Replace(arg, `"`, `\"`, -1)
+	arg = strings.Replace(arg, "\n", `\n`, -1)
+	return `"` + arg + `"`
+}
+
+// SdNotify sends the given state (e.g. "READY=1") to systemd if running as a service with Type=notify (i.e.
+// NOTIFY_SOCKET is set). It returns whether the state has been sent.
+func SdNotify(state string) (bool, error) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return false, nil
+	}
+
+	// a leading @ denotes an abstract socket
+	if strings.HasPrefix(socket, "@") {
+		socket = "\x00" + socket[1:]
+	}
+	conn, errDial := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if errDial != nil {
+		return false, fmt.Errorf("failed to connect to %s: %v", socket, errDial)
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	_, errWrite := conn.Write([]byte(state))
+	if errWrite != nil {
+		return false, fmt.Errorf("failed to notify systemd: %v", errWrite)
+	}
+	return true, nil
+}
+
+// SdWatchdog returns the interval within which systemd expects a keepalive ("WATCHDOG=1") from this process (or 0 if
+// the watchdog is not enabled).
+func SdWatchdog() time.Duration {
+	usec, errUsec := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if errUsec != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+// Watchdog keeps the systemd watchdog happy as long as all poll loops of the process are live: each loop reports
+// through Alive every Interval and a keepalive is sent once all of them reported since the previous one. A nil
+// Watchdog (i.e. the watchdog is not enabled) ignores reports.
+type Watchdog struct {
+	interval time.Duration
+
+	// whether the loops reported since the previous keepalive by name
+	mutex sync.Mutex
+	alive map[string]bool
+}
+
+// NewWatchdog returns the watchdog of the given loops or nil if the systemd watchdog is not enabled (see SdWatchdog).
+func NewWatchdog(loops []string) *Watchdog {
+	timeout := SdWatchdog()
+	if timeout == 0 {
+		return nil
+	}
+	alive := make(map[string]bool)
+	for _, loop := range loops {
+		alive[loop] = false
+	}
+
+	// several reports of each loop fit into the timeout of systemd
+	return &Watchdog{interval: timeout / 4, alive: alive}
+}
+
+// Interval returns how often the loops are to report (0 if not at all).
+func (watchdog *Watchdog) Interval() time.Duration {
+	if watchdog == nil {
+		return 0
+	}
+	return watchdog.interval
+}
+
+// Alive reports that the given loop is live and sends a keepalive if all loops did so.
+func (watchdog *Watchdog) Alive(loop string) error {
+	if watchdog == nil {
+		return nil
+	}
+	watchdog.mutex.Lock()
+	defer watchdog.mutex.Unlock()
+
+	watchdog.alive[loop] = true
+	for _, alive := range watchdog.alive {
+		if !alive {
+			return nil
+		}
+	}
+	for name := range watchdog.alive {
+		watchdog.alive[name] = false
+	}
+	_, errNotify := SdNotify("WATCHDOG=1")
+	return errNotify
+}
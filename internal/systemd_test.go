@@ -0,0 +1,158 @@
+package internal
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUnit(t *testing.T) {
+	unit := Unit([]string{"/usr/bin/goyammer", "poll", "--foreground", "--group", "All Company", "--exclude-group", "/^100%$/"})
+	want := `ExecStart=/usr/bin/goyammer poll --foreground --group "All Company" --exclude-group /^100%%$$/` + "\n"
+	if !strings.Contains(unit, want) {
+		t.Errorf("Unit() = %s, want it to contain %s", unit, want)
+	}
+	if !strings.Contains(unit, "Type=notify\n") {
+		t.Errorf("Unit() = %s, want Type=notify", unit)
+	}
+}
+
+// withEnv runs the given test with the given environment variables set.
+func withEnv(env map[string]string, test func()) {
+	for name, value := range env {
+		previous, set := os.LookupEnv(name)
+		_ = os.Setenv(name, value)
+		defer func(name string) {
+			if set {
+				_ = os.Setenv(name, previous)
+			} else {
+				_ = os.Unsetenv(name)
+			}
+		}(name)
+	}
+	test()
+}
+
+func TestSdNotify(t *testing.T) {
+	withEnv(map[string]string{"NOTIFY_SOCKET": ""}, func() {
+		if sent, err := SdNotify("READY=1"); sent || err != nil {
+			t.Errorf("SdNotify() without NOTIFY_SOCKET = %v, %v, want false", sent, err)
+		}
+	})
+
+	dir, errDir := ioutil.TempDir("", "goyammer-test")
+	if errDir != nil {
+		t.Fatal(errDir)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	socketPath := path.Join(dir, "notify")
+	conn, errListen := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socketPath, Net: "unixgram"})
+	if errListen != nil {
+		t.Fatal(errListen)
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	withEnv(map[string]string{"NOTIFY_SOCKET": socketPath}, func() {
+		if sent, err := SdNotify("STATUS=polling"); !sent || err != nil {
+			t.Errorf("SdNotify() = %v, %v, want true", sent, err)
+		}
+	})
+	buffer := make([]byte, 64)
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, errRead := conn.Read(buffer)
+	if errRead != nil || string(buffer[:n]) != "STATUS=polling" {
+		t.Errorf("received %s, %v, want STATUS=polling", buffer[:n], errRead)
+	}
+}
+
+func TestSdWatchdog(t *testing.T) {
+	tests := []struct {
+		name string
+		usec string
+		pid  string
+		want time.Duration
+	}{
+		{name: "disabled", want: 0},
+		{name: "enabled", usec: "60000000", want: time.Minute},
+		{name: "this process", usec: "60000000", pid: strconv.Itoa(os.Getpid()), want: time.Minute},
+		{name: "other process", usec: "60000000", pid: "1", want: 0},
+		{name: "invalid", usec: "soon", want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withEnv(map[string]string{"WATCHDOG_USEC": tt.usec, "WATCHDOG_PID": tt.pid}, func() {
+				if got := SdWatchdog(); got != tt.want {
+					t.Errorf("SdWatchdog() = %s, want %s", got, tt.want)
+				}
+			})
+		})
+	}
+}
+
+func TestWatchdog(t *testing.T) {
+	withEnv(map[string]string{"WATCHDOG_USEC": ""}, func() {
+		if watchdog := NewWatchdog([]string{DefaultAccount}); watchdog != nil || watchdog.Interval() != 0 || watchdog.Alive(DefaultAccount) != nil {
+			t.Errorf("NewWatchdog() without WATCHDOG_USEC = %v, want nil ignoring reports", watchdog)
+		}
+	})
+
+	dir, errDir := ioutil.TempDir("", "goyammer-test")
+	if errDir != nil {
+		t.Fatal(errDir)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	socketPath := path.Join(dir, "notify")
+	conn, errListen := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socketPath, Net: "unixgram"})
+	if errListen != nil {
+		t.Fatal(errListen)
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	// received returns what has been sent to systemd (nothing if the watchdog has not been notified)
+	received := func() string {
+		buffer := make([]byte, 64)
+		_ = conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		n, _ := conn.Read(buffer)
+		return string(buffer[:n])
+	}
+
+	env := map[string]string{"NOTIFY_SOCKET": socketPath, "WATCHDOG_USEC": "60000000", "WATCHDOG_PID": ""}
+	withEnv(env, func() {
+		watchdog := NewWatchdog([]string{DefaultAccount, "work"})
+		if got := watchdog.Interval(); got != 15*time.Second {
+			t.Errorf("Interval() = %s, want 15s", got)
+		}
+
+		tests := []struct {
+			loop string
+			want string
+		}{
+			{loop: DefaultAccount},
+			{loop: DefaultAccount},
+			{loop: "work", want: "WATCHDOG=1"},
+			{loop: "work"},
+			{loop: DefaultAccount, want: "WATCHDOG=1"},
+		}
+		for i, tt := range tests {
+			if err := watchdog.Alive(tt.loop); err != nil {
+				t.Fatalf("Alive() failed: %v", err)
+			}
+			if got := received(); got != tt.want {
+				t.Errorf("%d: Alive(%s) sent %q, want %q", i, tt.loop, got, tt.want)
+			}
+		}
+	})
+}
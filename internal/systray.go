@@ -1,6 +1,9 @@
 package internal
 
 import (
+	"fmt"
+	"sync"
+
 	"github.com/getlantern/systray"
 	"github.com/seboghpub/goyammer/icon"
 )
@@ -15,10 +18,109 @@ func Systray_init() {
 	Systray_reset()
 }
 
+// the number of polls in progress (of all accounts)
+var systrayPolls struct {
+	sync.Mutex
+	count int
+}
+
+// Systray_poll shows the poll icon (until all polls in progress are done).
 func Systray_poll() {
+	systrayPolls.Lock()
+	defer systrayPolls.Unlock()
+	systrayPolls.count++
 	systray.SetIcon(icon.Poll)
 }
 
+// Systray_done shows the main icon if no other poll is in progress.
+func Systray_done() {
+	systrayPolls.Lock()
+	defer systrayPolls.Unlock()
+	if systrayPolls.count > 0 {
+		systrayPolls.count--
+	}
+	if systrayPolls.count == 0 {
+		Systray_reset()
+	}
+}
+
 func Systray_reset() {
 	systray.SetIcon(icon.Main)
 }
+
+// SystrayAccount is the submenu of an account. A nil submenu (e.g. when polling without tray) ignores all updates and
+// never receives clicks.
+type SystrayAccount struct {
+	item   *systray.MenuItem
+	status *systray.MenuItem
+	open   *systray.MenuItem
+	read   *systray.MenuItem
+	login  *systray.MenuItem
+}
+
+// Systray_account adds the submenu of the given account (to be called before Systray_init).
+func Systray_account(name string) *SystrayAccount {
+	item := systray.AddMenuItem(name, fmt.Sprintf("account %s", name))
+	account := &SystrayAccount{
+		item:   item,
+		status: item.AddSubMenuItem("starting", "what goyammer is doing"),
+		open:   item.AddSubMenuItem("open Yammer", "open Yammer in the browser"),
+		read:   item.AddSubMenuItem("mark all read", "mark the messages received as seen"),
+		login:  item.AddSubMenuItem("log in again", "log in again to resume polling"),
+	}
+	account.status.Disable()
+	account.login.Hide()
+	return account
+}
+
+// SetNetwork shows the network of the account.
+func (account *SystrayAccount) SetNetwork(title string) {
+	if account == nil {
+		return
+	}
+	account.item.SetTitle(title)
+}
+
+// SetStatus shows what goyammer is doing for the account.
+func (account *SystrayAccount) SetStatus(status string) {
+	if account == nil {
+		return
+	}
+	account.status.SetTitle(status)
+}
+
+// OpenCh returns the channel receiving clicks on "open Yammer".
+func (account *SystrayAccount) OpenCh() <-chan struct{} {
+	if account == nil {
+		return nil
+	}
+	return account.open.ClickedCh
+}
+
+// ReadCh returns the channel receiving clicks on "mark all read".
+func (account *SystrayAccount) ReadCh() <-chan struct{} {
+	if account == nil {
+		return nil
+	}
+	return account.read.ClickedCh
+}
+
+// ShowLogin shows (or hides) "log in again".
+func (account *SystrayAccount) ShowLogin(show bool) {
+	if account == nil {
+		return
+	}
+	if show {
+		account.login.Show()
+	} else {
+		account.login.Hide()
+	}
+}
+
+// LoginCh returns the channel receiving clicks on "log in again".
+func (account *SystrayAccount) LoginCh() <-chan struct{} {
+	if account == nil {
+		return nil
+	}
+	return account.login.ClickedCh
+}
@@ -0,0 +1,127 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// createdAtLayout is the layout of the creation time of messages.
+const createdAtLayout = "2006/01/02 15:04:05 -0700"
+
+// ParseCreatedAt parses the creation time of a message (see YammerMessage).
+func ParseCreatedAt(createdAt string) (time.Time, error) {
+	return time.Parse(createdAtLayout, createdAt)
+}
+
+// GetMessage returns the message with the given id.
+func (c *Client) GetMessage(ctx context.Context, messageId int64) (*YammerMessage, error) {
+	req, errReq := c.newRequest(ctx, "GET", fmt.Sprintf("messages/%d.json", messageId), nil, nil)
+	if errReq != nil {
+		return nil, fmt.Errorf("failed to construct request for message %d: %v", messageId, errReq)
+	}
+	var message YammerMessage
+	_, errDo := c.do(req, &message)
+	if errDo != nil {
+		return nil, fmt.Errorf("failed to do request for message %d: %v", messageId, errDo)
+	}
+	return &message, nil
+}
+
+// GetThread returns all messages of the given thread (in chronological order).
+func (c *Client) GetThread(ctx context.Context, threadId int64) ([]YammerMessage, error) {
+
+	// walk the pages from newest to oldest
+	var messages []YammerMessage
+	var olderThan int64
+	for {
+		var params map[string]string
+		if olderThan != 0 {
+			params = map[string]string{"older_than": strconv.FormatInt(olderThan, 10)}
+		}
+		req, errReq := c.newRequest(ctx, "GET", fmt.Sprintf("messages/in_thread/%d.json", threadId), params, nil)
+		if errReq != nil {
+			return nil, fmt.Errorf("failed to construct request for thread %d: %v", threadId, errReq)
+		}
+		var ymr YammerMessageResponse
+		_, errDo := c.do(req, &ymr)
+		if errDo != nil {
+			return nil, fmt.Errorf("failed to do request for thread %d: %v", threadId, errDo)
+		}
+		messages = append(messages, ymr.Messages...)
+		if !ymr.Meta.OlderAvailable || len(ymr.Messages) < 1 {
+			break
+		}
+		olderThan = ymr.Messages[len(ymr.Messages)-1].ID
+	}
+
+	sort.Slice(messages, func(i, j int) bool {
+		return messages[i].ID < messages[j].ID
+	})
+	return messages, nil
+}
+
+// ThreadNode is a message of a thread along with the replies to it.
+type ThreadNode struct {
+	Message YammerMessage
+	Replies []*ThreadNode
+}
+
+// BuildThread arranges the given messages of a thread as reply tree (replies in the order given). Messages replying to
+// messages not given become roots as well.
+func BuildThread(messages []YammerMessage) []*ThreadNode {
+	nodes := make(map[int64]*ThreadNode, len(messages))
+	for _, message := range messages {
+		nodes[message.ID] = &ThreadNode{Message: message}
+	}
+	var roots []*ThreadNode
+	for _, message := range messages {
+		node := nodes[message.ID]
+		if parent, ok := nodes[message.RepliedToID]; ok && message.RepliedToID != message.ID {
+			parent.Replies = append(parent.Replies, node)
+		} else {
+			roots = append(roots, node)
+		}
+	}
+	return roots
+}
+
+// ParseThreadRef parses a thread given as ID or as URL of a thread (".../threads/<id>" or "...?threadId=<id>") or of
+// a message (".../messages/<id>"). It returns the ID and whether it is the ID of a message rather than of a thread.
+func ParseThreadRef(ref string) (int64, bool, error) {
+	if id, errParse := strconv.ParseInt(ref, 10, 64); errParse == nil && id > 0 {
+		return id, false, nil
+	}
+	u, errUrl := url.Parse(ref)
+	if errUrl != nil || u.Host == "" {
+		return 0, false, fmt.Errorf("invalid thread '%s', expected an ID or a URL", ref)
+	}
+
+	// the web UI keeps its route (and query) in the fragment
+	query := u.Query()
+	if fragment, errFragment := url.Parse(u.Fragment); u.Fragment != "" && errFragment == nil && query.Get("threadId") == "" {
+		query = fragment.Query()
+	}
+	if id, errParse := strconv.ParseInt(query.Get("threadId"), 10, 64); errParse == nil && id > 0 {
+		return id, false, nil
+	}
+
+	segments := strings.Split(strings.Trim(u.Path, "/"), "/")
+	for i := len(segments) - 2; i >= 0; i-- {
+		id, errParse := strconv.ParseInt(segments[i+1], 10, 64)
+		if errParse != nil || id < 1 {
+			continue
+		}
+		switch segments[i] {
+		case "threads":
+			return id, false, nil
+		case "messages":
+			return id, true, nil
+		}
+	}
+	return 0, false, fmt.Errorf("no thread or message ID in '%s'", ref)
+}
@@ -0,0 +1,113 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestClient_GetThread(t *testing.T) {
+
+	// a thread of 5 messages served 2 per page (newest first)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/messages/in_thread/1.json" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		ids := []int64{5, 4, 3, 2, 1}
+		var response YammerMessageResponse
+		for _, id := range ids {
+			if olderThan, _ := strconv.ParseInt(r.URL.Query().Get("older_than"), 10, 64); olderThan != 0 && id >= olderThan {
+				continue
+			}
+			if len(response.Messages) == 2 {
+				response.Meta.OlderAvailable = true
+				break
+			}
+			response.Messages = append(response.Messages, YammerMessage{ID: id, ThreadID: 1})
+		}
+		_ = json.NewEncoder(w).Encode(response)
+	}))
+	defer server.Close()
+
+	messages, err := newTestClient(server).GetThread(context.Background(), 1)
+	if err != nil {
+		t.Fatalf("GetThread() error = %v", err)
+	}
+	var ids []int64
+	for _, message := range messages {
+		ids = append(ids, message.ID)
+	}
+	if want := []int64{1, 2, 3, 4, 5}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("GetThread() = %v, want %v", ids, want)
+	}
+}
+
+func TestBuildThread(t *testing.T) {
+	roots := BuildThread([]YammerMessage{
+		{ID: 1},
+		{ID: 2, RepliedToID: 1},
+		{ID: 3, RepliedToID: 2},
+		{ID: 4, RepliedToID: 1},
+		{ID: 5, RepliedToID: 99},
+	})
+
+	// render as "id(replies...)"
+	var render func(nodes []*ThreadNode) string
+	render = func(nodes []*ThreadNode) string {
+		text := ""
+		for _, node := range nodes {
+			text += string(rune('0' + node.Message.ID))
+			if len(node.Replies) > 0 {
+				text += "(" + render(node.Replies) + ")"
+			}
+		}
+		return text
+	}
+	if got, want := render(roots), "1(2(3)4)5"; got != want {
+		t.Errorf("BuildThread() = %s, want %s", got, want)
+	}
+}
+
+func TestParseThreadRef(t *testing.T) {
+	tests := []struct {
+		ref         string
+		wantId      int64
+		wantMessage bool
+		wantErr     bool
+	}{
+		{ref: "123", wantId: 123},
+		{ref: "https://www.yammer.com/example.com/threads/123", wantId: 123},
+		{ref: "https://www.yammer.com/example.com/#/Threads/show?threadId=123", wantId: 123},
+		{ref: "https://www.yammer.com/example.com/messages/456", wantId: 456, wantMessage: true},
+		{ref: "https://www.yammer.com/example.com/groups/team", wantErr: true},
+		{ref: "team", wantErr: true},
+		{ref: "-1", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ref, func(t *testing.T) {
+			id, message, err := ParseThreadRef(tt.ref)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseThreadRef() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if id != tt.wantId || message != tt.wantMessage {
+				t.Errorf("ParseThreadRef() = %d, %v, want %d, %v", id, message, tt.wantId, tt.wantMessage)
+			}
+		})
+	}
+}
+
+func TestParseCreatedAt(t *testing.T) {
+	got, err := ParseCreatedAt("2020/04/01 12:34:56 +0000")
+	if want := time.Date(2020, 4, 1, 12, 34, 56, 0, time.UTC); err != nil || !got.Equal(want) {
+		t.Errorf("ParseCreatedAt() = %s, %v, want %s", got, err, want)
+	}
+	if _, err := ParseCreatedAt("yesterday"); err == nil {
+		t.Errorf("ParseCreatedAt() of garbage succeeded, want error")
+	}
+}
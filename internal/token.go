@@ -3,55 +3,121 @@ package internal
 import (
 	"fmt"
 	"github.com/rs/zerolog/log"
-	"io/ioutil"
 	"os"
 	"path"
+	"path/filepath"
 )
 
 const tokeFile = ".goyammer-token"
 
-// SetToken acquires an access token and store it to a file.
-func SetToken(clientId string) {
+// MugshotDirPattern is the pattern of the temporary directories mug shots are stored in while polling.
+const MugshotDirPattern = "goyammer-mugshots"
 
-	// tokenPath for the token
-	home, _ := os.UserHomeDir()
-	tokenPath := path.Join(home, tokeFile)
+// SetToken acquires an access token and stores it in the given credential store.
+func SetToken(auth *Authenticator, store CredentialStore, account string) {
 
 	// authenticate
-	token := Authenticate(clientId)
+	token, errAuth := auth.Authenticate()
+	if errAuth != nil {
+		log.Fatal().Err(errAuth).Msg("failed to authenticate")
+	}
 
 	// save the token
-	err := ioutil.WriteFile(tokenPath, []byte(token), 0600)
+	err := store.Set(account, token)
 	if err != nil {
-		log.Fatal().Err(err).Msg(fmt.Sprintf("failed to write token to %s", tokenPath))
+		log.Fatal().Err(err).Msg("failed to store token")
 	}
-	log.Info().Msg(fmt.Sprintf("token written to %s", tokenPath))
+	log.Info().Msg(fmt.Sprintf("token stored in %s", store.String()))
 }
 
-// GetToken read an access token from a file and returns it.
-func GetToken() string {
+// GetToken reads an access token from the given credential store and returns it.
+func GetToken(store CredentialStore, account string) string {
+	token, errGet := store.Get(account)
+	if errGet == ErrTokenNotFound {
+		log.Fatal().Msg(fmt.Sprintf("no token for account '%s' in %s, use 'login'", account, store.String()))
+	}
+	if errGet != nil {
+		log.Fatal().Err(errGet).Msg("failed to read token")
+	}
+	return token
+}
 
-	// tokenPath for the token
-	home, _ := os.UserHomeDir()
-	tokenPath := path.Join(home, tokeFile)
+// MigrateToken moves the token of the given account from the plain text file into the given credential store.
+func MigrateToken(store CredentialStore, account string) error {
+	if _, ok := store.(*fileStore); ok {
+		return fmt.Errorf("the token is already stored in a plain text file, choose another store")
+	}
+	files := NewFileStore()
+	token, errGet := files.Get(account)
+	if errGet == ErrTokenNotFound {
+		return fmt.Errorf("no token to migrate in %s", files.String())
+	}
+	if errGet != nil {
+		return errGet
+	}
+	errSet := store.Set(account, token)
+	if errSet != nil {
+		return fmt.Errorf("failed to store token: %v", errSet)
+	}
 
-	// stat the tokenPath
-	_, errStat := os.Stat(tokenPath)
+	// only remove the file once the token has been read back successfully
+	stored, errCheck := store.Get(account)
+	if errCheck != nil || stored != token {
+		return fmt.Errorf("failed to read back the migrated token, keeping the plain text file: %v", errCheck)
+	}
+	return files.Delete(account)
+}
 
-	if os.IsNotExist(errStat) {
-		log.Fatal().Msg(fmt.Sprintf("%s missing, use 'login'", tokenPath))
+// CredentialStores returns all credential stores which may hold tokens: the Secret Service (if available), the
+// encrypted file (if it exists) and the plain text files.
+func CredentialStores() []CredentialStore {
+	var stores []CredentialStore
+	if secretStore, errSecret := NewSecretServiceStore(); errSecret == nil {
+		stores = append(stores, secretStore)
 	}
-	if errStat != nil {
-		log.Fatal().Err(errStat)
+	encryptedPath := path.Join(ConfigDir(), encryptedTokenFile)
+	if FileExists(encryptedPath) {
+		stores = append(stores, NewEncryptedFileStore(encryptedPath, ReadPassphrase))
 	}
+	return append(stores, NewFileStore())
+}
 
-	// read the file
-	token, errRead := ioutil.ReadFile(tokenPath)
-	if errRead != nil {
-		log.Fatal().Err(errRead).Msg(fmt.Sprintf("failed to read token from %s", tokenPath))
+// DeleteToken deletes the token of the given account from all of the given stores and returns the stores which held
+// one.
+func DeleteToken(stores []CredentialStore, account string) ([]CredentialStore, error) {
+	var deleted []CredentialStore
+	for _, store := range stores {
+		errDelete := store.Delete(account)
+		if errDelete == ErrTokenNotFound {
+			continue
+		}
+		if errDelete != nil {
+			return deleted, fmt.Errorf("failed to delete token from %s: %v", store.String(), errDelete)
+		}
+		deleted = append(deleted, store)
 	}
+	return deleted, nil
+}
 
-	// return the token
-	return string(token)
+// ClearAccountData removes the poll state and cached mug shots of the given account and returns the removed paths.
+func ClearAccountData(account string) ([]string, error) {
+	var removed []string
+	statePath := StatePath(account)
+	errState := os.Remove(statePath)
+	if errState == nil {
+		removed = append(removed, statePath)
+	} else if !os.IsNotExist(errState) {
+		return removed, fmt.Errorf("failed to remove %s: %v", statePath, errState)
+	}
 
+	// mug shots of pollers which did not clean up (the directories are named like in poll)
+	mugdirs, _ := filepath.Glob(path.Join(os.TempDir(), MugshotDirPattern+"*", account))
+	for _, mugdir := range mugdirs {
+		errMug := os.RemoveAll(mugdir)
+		if errMug != nil {
+			return removed, fmt.Errorf("failed to remove %s: %v", mugdir, errMug)
+		}
+		removed = append(removed, mugdir)
+	}
+	return removed, nil
 }
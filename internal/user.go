@@ -1,10 +1,12 @@
 package internal
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"strconv"
+	"sync"
 )
 
 // User is the data structure to represent a single user.
@@ -15,13 +17,55 @@ type User struct {
 	Groups  *YammerGroupResponse
 }
 
-// Users is the data structure to represent all users.
+// Users is the data structure to represent all users. It is safe for concurrent use.
 type Users struct {
 	client *Client
+	mutex  sync.Mutex
 	cache  map[int64]*User
+	flight userFlight
 	tmpdir string
 }
 
+// userFlight de-duplicates concurrent lookups: while a user is being fetched, further lookups of the same user wait
+// for and share the result.
+type userFlight struct {
+	mutex sync.Mutex
+	calls map[int64]*userCall
+}
+
+// userCall is a user lookup in flight.
+type userCall struct {
+	done sync.WaitGroup
+	user *User
+	err  error
+}
+
+// do calls fetch for the given user id unless a call for the same id is in flight already.
+func (flight *userFlight) do(uid int64, fetch func() (*User, error)) (*User, error) {
+	flight.mutex.Lock()
+	if flight.calls == nil {
+		flight.calls = make(map[int64]*userCall)
+	}
+	if call, ok := flight.calls[uid]; ok {
+		flight.mutex.Unlock()
+		call.done.Wait()
+		return call.user, call.err
+	}
+	call := &userCall{}
+	call.done.Add(1)
+	flight.calls[uid] = call
+	flight.mutex.Unlock()
+
+	call.user, call.err = fetch()
+	call.done.Done()
+
+	flight.mutex.Lock()
+	delete(flight.calls, uid)
+	flight.mutex.Unlock()
+
+	return call.user, call.err
+}
+
 // NewUsers returns a new Users object.
 func NewUsers(client *Client, tmpdir string) *Users {
 
@@ -33,13 +77,24 @@ func NewUsers(client *Client, tmpdir string) *Users {
 }
 
 // GetUser returns the user by id.
-func (users *Users) GetUser(uid int64) (*User, error) {
+func (users *Users) GetUser(ctx context.Context, uid int64) (*User, error) {
 
 	// get user from cache
-	if user, ok := users.cache[uid]; ok {
+	users.mutex.Lock()
+	user, ok := users.cache[uid]
+	users.mutex.Unlock()
+	if ok {
 		return user, nil
 	}
 
+	return users.flight.do(uid, func() (*User, error) {
+		return users.fetchUser(ctx, uid)
+	})
+}
+
+// fetchUser queries the user by id and adds it to the cache.
+func (users *Users) fetchUser(ctx context.Context, uid int64) (*User, error) {
+
 	// construct path (current by default, for a particular group if uid is !-1)
 	pathUser := "users/current.json"
 	if uid != -1 {
@@ -47,7 +102,7 @@ func (users *Users) GetUser(uid int64) (*User, error) {
 	}
 
 	// construct request
-	reqUser, errReq := users.client.newRequest("GET", pathUser, nil, nil)
+	reqUser, errReq := users.client.newRequest(ctx, "GET", pathUser, nil, nil)
 	if errReq != nil {
 		return nil, fmt.Errorf("failed to construct user request for user %d: %v", uid, errReq)
 	}
@@ -60,7 +115,7 @@ func (users *Users) GetUser(uid int64) (*User, error) {
 	}
 
 	// query yammer mug shot
-	mug, errMug := users.client.GetImage(yur.MugshotURL)
+	mug, errMug := users.client.GetImage(ctx, yur.MugshotURL)
 	if errMug != nil {
 		return nil, fmt.Errorf("failed to get mug shot for user: %d: %v", uid, errMug)
 	}
@@ -68,45 +123,76 @@ func (users *Users) GetUser(uid int64) (*User, error) {
 	// if user is current user query groups
 	var groups *YammerGroupResponse
 	if uid == -1 {
-
-		// construct path
-		pathGroups := fmt.Sprintf("groups/for_user/%d.json", yur.ID)
-
-		// construct request
-		reqGrp, errGrp := users.client.newRequest("GET", pathGroups, nil, nil)
-		if errGrp != nil {
-			return nil, fmt.Errorf("failed to construct groups request for user %d: %v", uid, errGrp)
-		}
-
-		// do request and parse response
-		var ygr YammerGroupResponse
-		_, errGrpDo := users.client.do(reqGrp, &ygr)
-		if errGrpDo != nil {
-			return nil, fmt.Errorf("failed to do groups request for user %d: %v", uid, errGrpDo)
-		}
-
-		// append the -1-group for private messages
-		privateGroup := YammerGroup{
-			ID:       -1,
-			FullName: "Private",
+		ygr, errGroups := users.getGroups(ctx, yur.ID)
+		if errGroups != nil {
+			return nil, errGroups
 		}
-		ygr = append(ygr, privateGroup)
-
-		groups = &ygr
+		groups = ygr
 	}
 
 	// construct our user
 	user := User{yur, mug, nil, groups}
 
 	// update cache
+	users.mutex.Lock()
 	users.cache[uid] = &user
+	users.mutex.Unlock()
 
 	// return user
 	return &user, nil
 }
 
+// MugShot returns the raw mug shot image of the given user (nil if there is none).
+func (user *User) MugShot() []byte {
+	return user.mugShot
+}
+
+// RefreshGroups queries the groups of the given (current) user again, updates them and returns them.
+func (users *Users) RefreshGroups(ctx context.Context, user *User) (*YammerGroupResponse, error) {
+	groups, errGroups := users.getGroups(ctx, user.ID)
+	if errGroups != nil {
+		return nil, errGroups
+	}
+	users.mutex.Lock()
+	user.Groups = groups
+	users.mutex.Unlock()
+	return groups, nil
+}
+
+// getGroups queries the groups of the given user (including the -1-group for private messages).
+func (users *Users) getGroups(ctx context.Context, uid int64) (*YammerGroupResponse, error) {
+
+	// construct path
+	pathGroups := fmt.Sprintf("groups/for_user/%d.json", uid)
+
+	// construct request
+	reqGrp, errGrp := users.client.newRequest(ctx, "GET", pathGroups, nil, nil)
+	if errGrp != nil {
+		return nil, fmt.Errorf("failed to construct groups request for user %d: %v", uid, errGrp)
+	}
+
+	// do request and parse response
+	var ygr YammerGroupResponse
+	_, errGrpDo := users.client.do(reqGrp, &ygr)
+	if errGrpDo != nil {
+		return nil, fmt.Errorf("failed to do groups request for user %d: %v", uid, errGrpDo)
+	}
+
+	// append the -1-group for private messages
+	privateGroup := YammerGroup{
+		ID:       -1,
+		FullName: "Private",
+	}
+	ygr = append(ygr, privateGroup)
+
+	return &ygr, nil
+}
+
 func (users *Users) GetMugFile(user *User) (*os.File, error) {
 
+	users.mutex.Lock()
+	defer users.mutex.Unlock()
+
 	if user.mugFile != nil && FileExists(user.mugFile.Name()) {
 		return user.mugFile, nil
 	}
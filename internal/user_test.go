@@ -0,0 +1,72 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestUsers_GetUser_concurrent(t *testing.T) {
+	var mutex sync.Mutex
+	requests := make(map[string]int)
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		requests[r.URL.Path]++
+		mutex.Unlock()
+
+		// keep lookups in flight for a while so that they overlap
+		time.Sleep(50 * time.Millisecond)
+
+		var uid int64
+		if _, err := fmt.Sscanf(r.URL.Path, "/users/%d.json", &uid); err == nil {
+			_, _ = fmt.Fprintf(w, `{"id": %d, "full_name": "User %d", "mugshot_url": "%s/mugshot/%d.jpg"}`, uid, uid, server.URL, uid)
+			return
+		}
+		_, _ = fmt.Fprint(w, "jpeg")
+	}))
+	defer server.Close()
+
+	tmpdir, err := ioutil.TempDir("", "goyammer-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(tmpdir)
+	}()
+
+	users := NewUsers(newTestClient(server), tmpdir)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(uid int64) {
+			defer wg.Done()
+			user, err := users.GetUser(context.Background(), uid)
+			if err != nil {
+				t.Errorf("GetUser(%d) error = %v", uid, err)
+				return
+			}
+			if user.ID != uid {
+				t.Errorf("GetUser(%d) returned user %d", uid, user.ID)
+			}
+			if _, err := users.GetMugFile(user); err != nil {
+				t.Errorf("GetMugFile(%d) error = %v", uid, err)
+			}
+		}(int64(1 + i%2))
+	}
+	wg.Wait()
+
+	// every user and mug shot has been fetched exactly once
+	for _, path := range []string{"/users/1.json", "/users/2.json", "/mugshot/1.jpg", "/mugshot/2.jpg"} {
+		if requests[path] != 1 {
+			t.Errorf("%d requests for %s, want 1", requests[path], path)
+		}
+	}
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"github.com/seboghpub/goyammer/icon"
@@ -8,12 +10,14 @@ import (
 	"os"
 	"os/exec"
 	"os/signal"
-	"regexp"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 
 	"github.com/getlantern/systray"
-	"github.com/mqu/go-notify"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	"github.com/seboghpub/goyammer/internal"
@@ -23,12 +27,161 @@ import (
 var buildVersion = "to be set by linker"
 var buildGithash = "to be set by linker"
 
-type app struct {
-	users      *internal.Users
-	messages   *internal.Messages
-	tmpdir     string
-	logo       string
-	background bool
+// stringsFlag is a flag that may be given multiple times.
+type stringsFlag []string
+
+func (f *stringsFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *stringsFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+// pollFlags are the command line options of 'poll'.
+type pollFlags struct {
+	interval       *uint
+	minInterval    *uint
+	maxInterval    *uint
+	groupIntervals stringsFlag
+	output         *string
+	foreground     *bool
+	backlog        *uint
+	concurrency    *uint
+	rateLimit      *uint
+	timeout        *uint
+	maxPages       *uint
+	includes       stringsFlag
+	excludes       stringsFlag
+	refreshGroups  *uint
+	notifier       *string
+	tokenStore     *string
+	accounts       stringsFlag
+	config         *string
+	profile        *string
+}
+
+// newPollFlags defines the command line options of 'poll' in the given flag set.
+func newPollFlags(pollCommand *flag.FlagSet) *pollFlags {
+	poll := &pollFlags{}
+	poll.interval = pollCommand.Uint("interval", 10, "The initial number of seconds to wait between requests for a group. (Optional)")
+	poll.minInterval = pollCommand.Uint("min-interval", 10, "The minimum number of seconds to wait between requests for a group. (Optional)")
+	poll.maxInterval = pollCommand.Uint("max-interval", 300, "The maximum number of seconds to wait between requests for a group. (Optional)")
+	pollCommand.Var(&poll.groupIntervals, "group-interval", "Per-group interval bounds as <group>=<min>:<max> (may be repeated). (Optional)")
+	poll.output = pollCommand.String("output", "", "Where to send output to (Optional)")
+	poll.foreground = pollCommand.Bool("foreground", false, "Run in foreground (Optional)")
+	poll.backlog = pollCommand.Uint("backlog", 20, "The maximum number of missed messages to show per group after a restart. (Optional)")
+	poll.concurrency = pollCommand.Uint("concurrency", 4, "The number of groups to fetch in parallel. (Optional)")
+	poll.rateLimit = pollCommand.Uint("rate-limit", internal.DefaultRateRequests, "The maximum number of requests per 30 seconds. (Optional)")
+	poll.timeout = pollCommand.Uint("timeout", uint(internal.DefaultTimeout/time.Second), "The maximum number of seconds a single request may take. (Optional)")
+	poll.maxPages = pollCommand.Uint("max-pages", internal.DefaultMaxPages, "The maximum number of message pages to fetch per group and poll. (Optional)")
+	pollCommand.Var(&poll.includes, "group", "Only poll groups matching the given ID, name or /regex/ (may be repeated). (Optional)")
+	pollCommand.Var(&poll.excludes, "exclude-group", "Do not poll groups matching the given ID, name or /regex/ (may be repeated). (Optional)")
+	poll.refreshGroups = pollCommand.Uint("refresh-groups", 15, "The number of minutes between refreshes of the group membership (0 disables refreshing). (Optional)")
+	poll.notifier = pollCommand.String("notifier", internal.NotifierDBus, "How to send notifications: dbus, libnotify, json or none. (Optional)")
+	poll.tokenStore = pollCommand.String("token-store", internal.StoreAuto, "Where the token is stored: auto, secret-service, encrypted-file or file. (Optional)")
+	pollCommand.Var(&poll.accounts, "account", "The account to poll (may be repeated). (Optional)")
+	poll.config = pollCommand.String("config", internal.ConfigPath(), "The configuration file. (Optional)")
+	poll.profile = pollCommand.String("profile", "", "The configuration profile to use. (Optional)")
+	return poll
+}
+
+// options returns the options given on the command line, falling back to the given settings of the configuration file
+// (see applyConfig).
+func (poll *pollFlags) options(settings internal.Settings) (*internal.PollOptions, error) {
+
+	// the accounts to poll (flags take precedence over the configuration file)
+	accounts := settings.Accounts
+	if len(poll.accounts) > 0 {
+		accounts = poll.accounts
+	}
+	if len(accounts) == 0 {
+		accounts = []string{internal.DefaultAccount}
+	}
+	for _, account := range accounts {
+		if errAccount := internal.ValidateAccount(account); errAccount != nil {
+			return nil, fmt.Errorf("failed to parse '--account' parameter: %v", errAccount)
+		}
+	}
+
+	if *poll.interval < 1 {
+		return nil, fmt.Errorf("'--interval' must be positive")
+	}
+	if *poll.maxInterval < 1 {
+		return nil, fmt.Errorf("'--max-interval' must be positive")
+	}
+	if *poll.concurrency < 1 {
+		return nil, fmt.Errorf("'--concurrency' must be positive")
+	}
+	if *poll.rateLimit < 1 {
+		return nil, fmt.Errorf("'--rate-limit' must be positive")
+	}
+	if *poll.timeout < 1 {
+		return nil, fmt.Errorf("'--timeout' must be positive")
+	}
+	if *poll.maxPages < 1 {
+		return nil, fmt.Errorf("'--max-pages' must be positive")
+	}
+	if *poll.minInterval < 1 || *poll.minInterval > *poll.maxInterval {
+		return nil, fmt.Errorf("'--min-interval' must be positive and not exceed '--max-interval'")
+	}
+
+	// parse per-group interval bounds (flags take precedence over the configuration file)
+	bounds := internal.IntervalBounds{
+		Min: time.Duration(*poll.minInterval) * time.Second,
+		Max: time.Duration(*poll.maxInterval) * time.Second,
+	}
+	groupIntervals := settings.GroupIntervals(bounds)
+	for _, spec := range poll.groupIntervals {
+		group, groupBounds, errSpec := internal.ParseGroupInterval(spec)
+		if errSpec != nil {
+			return nil, fmt.Errorf("failed to parse '--group-interval' parameter: %v", errSpec)
+		}
+		groupIntervals[group] = groupBounds
+	}
+
+	// build the group filter (flags take precedence over the configuration file)
+	includes := settings.Include
+	if len(poll.includes) > 0 {
+		includes = poll.includes
+	}
+	excludes := settings.Exclude
+	if len(poll.excludes) > 0 {
+		excludes = poll.excludes
+	}
+	filter, errFilter := internal.NewGroupFilter(includes, excludes)
+	if errFilter != nil {
+		return nil, fmt.Errorf("failed to parse group filter: %v", errFilter)
+	}
+
+	clientId := ""
+	if settings.ClientID != nil {
+		clientId = *settings.ClientID
+	}
+
+	return &internal.PollOptions{
+		Settings:       settings,
+		Accounts:       accounts,
+		Interval:       time.Duration(*poll.interval) * time.Second,
+		Bounds:         bounds,
+		GroupIntervals: groupIntervals,
+		Includes:       includes,
+		Excludes:       excludes,
+		Filter:         filter,
+		Backlog:        *poll.backlog,
+		Concurrency:    *poll.concurrency,
+		RateLimit:      *poll.rateLimit,
+		Timeout:        time.Duration(*poll.timeout) * time.Second,
+		MaxPages:       *poll.maxPages,
+		RefreshGroups:  time.Duration(*poll.refreshGroups) * time.Minute,
+		Notifier:       *poll.notifier,
+		TokenStore:     *poll.tokenStore,
+		Output:         *poll.output,
+		ClientID:       clientId,
+		Notify:         settings.Notify.Enabled == nil || *settings.Notify.Enabled,
+		NotifyEach:     settings.Notify.Each != nil && *settings.Notify.Each,
+	}, nil
 }
 
 type Command int
@@ -39,6 +192,16 @@ usage: goyammer <command> [<args>]
 commands:
   login      Login to Yammer and get an access token.
   poll       Poll for new messages and notify.  
+  config     Validate the configuration file.
+  token      Manage the stored access token.
+  logout     Delete the access token and the data of an account.
+  stop       Stop running pollers.
+  status     Show the status of running pollers.
+  ctl        Control a running poller.
+  service    Manage the systemd user service polling in the background.
+  post       Post a message or a reply.
+  message    Like a message, follow a thread or mark them as seen.
+  thread     Show a whole conversation.
   version    Display version infos.
   help       Display usage message.
 `
@@ -48,6 +211,16 @@ const (
 	LOGIN   Command = 1
 	VERSION Command = 2
 	HELP    Command = 3
+	CONFIG  Command = 4
+	TOKEN   Command = 5
+	LOGOUT  Command = 6
+	STOP    Command = 7
+	STATUS  Command = 8
+	CTL     Command = 9
+	SERVICE Command = 10
+	POST    Command = 11
+	MESSAGE Command = 12
+	THREAD  Command = 13
 )
 
 func (cmd Command) string() string {
@@ -60,6 +233,26 @@ func (cmd Command) string() string {
 		return "version"
 	case HELP:
 		return "help"
+	case CONFIG:
+		return "config"
+	case TOKEN:
+		return "token"
+	case LOGOUT:
+		return "logout"
+	case STOP:
+		return "stop"
+	case STATUS:
+		return "status"
+	case CTL:
+		return "ctl"
+	case SERVICE:
+		return "service"
+	case POST:
+		return "post"
+	case MESSAGE:
+		return "message"
+	case THREAD:
+		return "thread"
 	default:
 		log.Fatal().Msgf("unknown command %d.\n", cmd)
 	}
@@ -78,22 +271,66 @@ func main() {
 		// a puristic console writer config
 		writer := zerolog.ConsoleWriter{Out: os.Stderr}
 		log.Logger = log.Output(writer)
-	}
+	} else if os.Getenv("JOURNAL_STREAM") != "" {
 
-	// initialize go-notify
-	notify.Init("goyammer")
+		// the journal (of a systemd service) adds timestamps and doesn't do colors
+		writer := zerolog.ConsoleWriter{Out: os.Stderr, NoColor: true}
+		log.Logger = log.Output(writer)
+	}
 
 	// see: https://blog.rapid7.com/2016/08/04/build-a-simple-cli-tool-with-golang/
 
 	// subcommands
 	loginCommand := flag.NewFlagSet("", flag.ExitOnError)
 	pollCommand := flag.NewFlagSet("", flag.ExitOnError)
+	configCommand := flag.NewFlagSet("", flag.ExitOnError)
+	tokenCommand := flag.NewFlagSet("", flag.ExitOnError)
+	logoutCommand := flag.NewFlagSet("", flag.ExitOnError)
+	stopCommand := flag.NewFlagSet("", flag.ExitOnError)
+	statusCommand := flag.NewFlagSet("", flag.ExitOnError)
+	ctlCommand := flag.NewFlagSet("", flag.ExitOnError)
+	postCommand := flag.NewFlagSet("", flag.ExitOnError)
+	messageCommand := flag.NewFlagSet("", flag.ExitOnError)
+	threadCommand := flag.NewFlagSet("", flag.ExitOnError)
 
 	// subcommand flag pointers
 	loginClientId := loginCommand.String("client", "", "The client ID. (Required)")
-	pollInterval := pollCommand.Uint("interval", 10, "The number of seconds to wait between request clientId. (Optional)")
-	pollOutput := pollCommand.String("output", "", "Where to send output to (Optional)")
-	pollForeground := pollCommand.Bool("foreground", false, "Run in foreground (Optional)")
+	loginClientSecret := loginCommand.String("secret", "", "The client secret. (Optional)")
+	loginFlow := loginCommand.String("flow", internal.FlowCode, "The OAuth flow: code (with PKCE) or implicit (legacy). (Optional)")
+	loginAuthUrl := loginCommand.String("auth-url", internal.YammerOAuthUrl, "The authorization endpoint. (Optional)")
+	loginTokenUrl := loginCommand.String("token-url", internal.YammerTokenUrl, "The token endpoint (code flow only). (Optional)")
+	loginNoCallback := loginCommand.Bool("no-browser-callback", false, "Do not wait for the browser but let the user paste the URL redirected to. (Optional)")
+	loginOpen := loginCommand.Bool("open", false, "Open the authorization URL with xdg-open. (Optional)")
+	loginTokenStore := loginCommand.String("token-store", internal.StoreAuto, "Where to store the token: auto, secret-service, encrypted-file or file. (Optional)")
+	loginAccount := loginCommand.String("account", internal.DefaultAccount, "The name of the account to store the token for. (Optional)")
+	loginTimeout := loginCommand.Uint("timeout", uint(internal.DefaultLoginTimeout/time.Second), "The number of seconds to wait for the authorization (0 for no limit). (Optional)")
+	poll := newPollFlags(pollCommand)
+	configConfig := configCommand.String("config", internal.ConfigPath(), "The configuration file. (Optional)")
+	configProfile := configCommand.String("profile", "", "The configuration profile to check. (Optional)")
+	tokenAccount := tokenCommand.String("account", internal.DefaultAccount, "The account whose token to migrate or check. (Optional)")
+	tokenTokenStore := tokenCommand.String("token-store", internal.StoreAuto, "The token store: auto, secret-service, encrypted-file or file. (Optional)")
+	logoutAccount := logoutCommand.String("account", internal.DefaultAccount, "The account to log out. (Optional)")
+	var stopAccounts, statusAccounts stringsFlag
+	stopCommand.Var(&stopAccounts, "account", "Only stop the poller of the given account (may be repeated). (Optional)")
+	statusCommand.Var(&statusAccounts, "account", "Only show the status of the given account (may be repeated). (Optional)")
+	ctlAccount := ctlCommand.String("account", internal.DefaultAccount, "The account whose poller to control. (Optional)")
+	ctlJson := ctlCommand.Bool("json", false, "Print the result as JSON. (Optional)")
+	postAccount := postCommand.String("account", internal.DefaultAccount, "The account to post as. (Optional)")
+	postTokenStore := postCommand.String("token-store", internal.StoreAuto, "The token store: auto, secret-service, encrypted-file or file. (Optional)")
+	postGroup := postCommand.String("group", "", "The group (ID, name or /regex/) to post to. (Optional)")
+	postAllCompany := postCommand.Bool("all-company", false, "Post to the All Company group. (Optional)")
+	postReplyTo := postCommand.Int64("reply-to", 0, "The ID of the message to reply to. (Optional)")
+	postFile := postCommand.String("file", "", "Read the message from the given file ('-' for stdin). (Optional)")
+	postDryRun := postCommand.Bool("dry-run", false, "Print the request instead of posting. (Optional)")
+	var postTopics, postTo, postAttachments stringsFlag
+	postCommand.Var(&postTopics, "topic", "A topic to tag the message with (may be repeated). (Optional)")
+	postCommand.Var(&postTo, "to", "Send a direct message to the given user ID or email address (may be repeated). (Optional)")
+	postCommand.Var(&postAttachments, "attach", "Attach the given file (may be repeated). (Optional)")
+	messageAccount := messageCommand.String("account", internal.DefaultAccount, "The account to act as. (Optional)")
+	messageTokenStore := messageCommand.String("token-store", internal.StoreAuto, "The token store: auto, secret-service, encrypted-file or file. (Optional)")
+	threadAccount := threadCommand.String("account", internal.DefaultAccount, "The account to read the thread as. (Optional)")
+	threadTokenStore := threadCommand.String("token-store", internal.StoreAuto, "The token store: auto, secret-service, encrypted-file or file. (Optional)")
+	threadJson := threadCommand.Bool("json", false, "Print the thread as JSON. (Optional)")
 	//pollDetached := pollCommand.Bool("detached", false, "internal flag")
 
 	// parse the commandline
@@ -110,6 +347,36 @@ func main() {
 			command = VERSION
 		case HELP.string():
 			command = HELP
+		case CONFIG.string():
+			command = CONFIG
+			flagArgs = os.Args[2:]
+		case TOKEN.string():
+			command = TOKEN
+			flagArgs = os.Args[2:]
+		case LOGOUT.string():
+			command = LOGOUT
+			flagArgs = os.Args[2:]
+		case STOP.string():
+			command = STOP
+			flagArgs = os.Args[2:]
+		case STATUS.string():
+			command = STATUS
+			flagArgs = os.Args[2:]
+		case CTL.string():
+			command = CTL
+			flagArgs = os.Args[2:]
+		case SERVICE.string():
+			command = SERVICE
+			flagArgs = os.Args[2:]
+		case POST.string():
+			command = POST
+			flagArgs = os.Args[2:]
+		case MESSAGE.string():
+			command = MESSAGE
+			flagArgs = os.Args[2:]
+		case THREAD.string():
+			command = THREAD
+			flagArgs = os.Args[2:]
 		default:
 			flagArgs = os.Args[1:]
 		}
@@ -125,6 +392,24 @@ func main() {
 
 		fmt.Print(UsageMsg)
 
+	case CONFIG:
+
+		// ensure the subcommand
+		if len(flagArgs) < 1 || flagArgs[0] != "validate" {
+			log.Fatal().Msg("usage: goyammer config validate [--config <path>] [--profile <name>]")
+		}
+
+		// parse flags
+		errFlags := configCommand.Parse(flagArgs[1:])
+		if errFlags != nil {
+			log.Fatal().Err(errFlags).Msgf("failed to parse command line for '%s' subcommand", CONFIG.string())
+		}
+
+		// hand off to business logic
+		if !validateConfig(*configConfig, *configProfile) {
+			os.Exit(1)
+		}
+
 	case LOGIN:
 
 		// parse flags
@@ -133,14 +418,245 @@ func main() {
 			log.Fatal().Err(errFlags).Msgf("failed to parse command line for '%s' subcommand", LOGIN.string())
 		}
 
-		// ensure required flags
+		// ensure required flags (the client ID may be configured)
+		if *loginClientId == "" {
+			config, errConfig := internal.LoadConfig(internal.ConfigPath())
+			if errConfig == nil && config.ClientID != nil {
+				*loginClientId = *config.ClientID
+			}
+		}
 		if *loginClientId == "" {
 			log.Fatal().Msg("missing '--client' parameter")
 			os.Exit(1)
 		}
 
 		// hand off to business logic
-		internal.SetToken(*loginClientId)
+		auth := internal.NewAuthenticator(*loginClientId)
+		auth.ClientSecret = *loginClientSecret
+		auth.Flow = *loginFlow
+		auth.AuthURL = *loginAuthUrl
+		auth.TokenURL = *loginTokenUrl
+		auth.Timeout = time.Duration(*loginTimeout) * time.Second
+		auth.NoCallback = *loginNoCallback
+		if *loginOpen {
+			auth.Browse = func(authUrl string) {
+				fmt.Printf("please authorize at: %s\n", authUrl)
+				internal.OpenBrowser(authUrl)
+			}
+		}
+		store, errStore := internal.NewCredentialStore(*loginTokenStore)
+		if errStore != nil {
+			log.Fatal().Err(errStore).Msg("failed to open token store")
+		}
+		if errAccount := internal.ValidateAccount(*loginAccount); errAccount != nil {
+			log.Fatal().Err(errAccount).Msg("failed to parse '--account' parameter")
+		}
+		internal.SetToken(auth, store, *loginAccount)
+
+	case TOKEN:
+
+		// ensure the subcommand
+		if len(flagArgs) < 1 || (flagArgs[0] != "migrate" && flagArgs[0] != "check") {
+			log.Fatal().Msg("usage: goyammer token migrate|check [--account <name>] [--token-store <store>]")
+		}
+
+		// parse flags
+		errFlags := tokenCommand.Parse(flagArgs[1:])
+		if errFlags != nil {
+			log.Fatal().Err(errFlags).Msgf("failed to parse command line for '%s' subcommand", TOKEN.string())
+		}
+
+		// hand off to business logic
+		store, errStore := internal.NewCredentialStore(*tokenTokenStore)
+		if errStore != nil {
+			log.Fatal().Err(errStore).Msg("failed to open token store")
+		}
+		switch flagArgs[0] {
+		case "migrate":
+			errMigrate := internal.MigrateToken(store, *tokenAccount)
+			if errMigrate != nil {
+				log.Fatal().Err(errMigrate).Msg("failed to migrate token")
+			}
+			log.Info().Msg(fmt.Sprintf("token moved to %s", store.String()))
+		case "check":
+			if !checkToken(store, *tokenAccount) {
+				os.Exit(1)
+			}
+		}
+
+	case LOGOUT:
+
+		// parse flags
+		errFlags := logoutCommand.Parse(flagArgs)
+		if errFlags != nil {
+			log.Fatal().Err(errFlags).Msgf("failed to parse command line for '%s' subcommand", LOGOUT.string())
+		}
+		if errAccount := internal.ValidateAccount(*logoutAccount); errAccount != nil {
+			log.Fatal().Err(errAccount).Msg("failed to parse '--account' parameter")
+		}
+
+		// hand off to business logic
+		if !logout(*logoutAccount) {
+			os.Exit(1)
+		}
+
+	case STOP:
+
+		// parse flags
+		errFlags := stopCommand.Parse(flagArgs)
+		if errFlags != nil {
+			log.Fatal().Err(errFlags).Msgf("failed to parse command line for '%s' subcommand", STOP.string())
+		}
+
+		// hand off to business logic
+		if !stop(stopAccounts) {
+			os.Exit(1)
+		}
+
+	case STATUS:
+
+		// parse flags
+		errFlags := statusCommand.Parse(flagArgs)
+		if errFlags != nil {
+			log.Fatal().Err(errFlags).Msgf("failed to parse command line for '%s' subcommand", STATUS.string())
+		}
+
+		// hand off to business logic
+		if !status(statusAccounts) {
+			os.Exit(1)
+		}
+
+	case CTL:
+
+		// parse flags
+		errFlags := ctlCommand.Parse(flagArgs)
+		if errFlags != nil {
+			log.Fatal().Err(errFlags).Msgf("failed to parse command line for '%s' subcommand", CTL.string())
+		}
+		if errAccount := internal.ValidateAccount(*ctlAccount); errAccount != nil {
+			log.Fatal().Err(errAccount).Msg("failed to parse '--account' parameter")
+		}
+
+		// hand off to business logic
+		if !ctl(*ctlAccount, ctlCommand.Args(), *ctlJson) {
+			os.Exit(1)
+		}
+
+	case SERVICE:
+
+		// ensure the subcommand
+		if len(flagArgs) < 1 || (flagArgs[0] != "install" && flagArgs[0] != "uninstall" && flagArgs[0] != "status") {
+			log.Fatal().Msg("usage: goyammer service install [<poll options>]|uninstall|status")
+		}
+
+		// hand off to business logic
+		success := false
+		switch flagArgs[0] {
+		case "install":
+
+			// check the options of the poller now rather than when the service starts
+			errFlags := pollCommand.Parse(flagArgs[1:])
+			if errFlags != nil {
+				log.Fatal().Err(errFlags).Msgf("failed to parse command line for '%s' subcommand", POLL.string())
+			}
+			if len(pollCommand.Args()) > 0 {
+				log.Fatal().Msg(fmt.Sprintf("unexpected argument '%s'", pollCommand.Arg(0)))
+			}
+			success = installService(flagArgs[1:])
+		case "uninstall":
+			success = uninstallService()
+		case "status":
+			success = systemctl("status", internal.ServiceName)
+		}
+		if !success {
+			os.Exit(1)
+		}
+
+	case POST:
+
+		// parse flags
+		errFlags := postCommand.Parse(flagArgs)
+		if errFlags != nil {
+			log.Fatal().Err(errFlags).Msgf("failed to parse command line for '%s' subcommand", POST.string())
+		}
+		if errAccount := internal.ValidateAccount(*postAccount); errAccount != nil {
+			log.Fatal().Err(errAccount).Msg("failed to parse '--account' parameter")
+		}
+		if *postGroup != "" && (len(postTo) > 0 || *postReplyTo != 0) {
+			log.Fatal().Msg("'--group' can't be combined with '--to' or '--reply-to'")
+		}
+		if *postAllCompany && (*postGroup != "" || len(postTo) > 0 || *postReplyTo != 0) {
+			log.Fatal().Msg("'--all-company' can't be combined with '--group', '--to' or '--reply-to'")
+		}
+		if !*postAllCompany && *postGroup == "" && len(postTo) == 0 && *postReplyTo == 0 {
+			log.Fatal().Msg("one of '--group', '--to', '--reply-to' or '--all-company' is required")
+		}
+		body, errBody := readBody(postCommand.Args(), *postFile)
+		if errBody != nil {
+			log.Fatal().Err(errBody).Msg("failed to read message")
+		}
+
+		// hand off to business logic
+		options := postOptions{
+			body:        body,
+			group:       *postGroup,
+			replyTo:     *postReplyTo,
+			topics:      postTopics,
+			to:          postTo,
+			attachments: postAttachments,
+			dryRun:      *postDryRun,
+		}
+		if !post(*postAccount, *postTokenStore, options) {
+			os.Exit(1)
+		}
+
+	case MESSAGE:
+
+		// ensure the subcommand
+		const usage = "usage: goyammer message like|unlike|follow|unfollow|seen|seen-thread [--account <name>] [--token-store <store>] <id>"
+		if len(flagArgs) < 1 || messageActions[flagArgs[0]] == nil {
+			log.Fatal().Msg(usage)
+		}
+
+		// parse flags
+		errFlags := messageCommand.Parse(flagArgs[1:])
+		if errFlags != nil {
+			log.Fatal().Err(errFlags).Msgf("failed to parse command line for '%s' subcommand", MESSAGE.string())
+		}
+		if errAccount := internal.ValidateAccount(*messageAccount); errAccount != nil {
+			log.Fatal().Err(errAccount).Msg("failed to parse '--account' parameter")
+		}
+		if messageCommand.NArg() != 1 {
+			log.Fatal().Msg(usage)
+		}
+		id, errId := strconv.ParseInt(messageCommand.Arg(0), 10, 64)
+		if errId != nil || id < 1 {
+			log.Fatal().Msg(fmt.Sprintf("invalid id '%s'", messageCommand.Arg(0)))
+		}
+
+		// hand off to business logic
+		if !actOnMessage(*messageAccount, *messageTokenStore, flagArgs[0], id) {
+			os.Exit(1)
+		}
+
+	case THREAD:
+
+		// parse flags
+		errFlags := threadCommand.Parse(flagArgs)
+		if errFlags != nil {
+			log.Fatal().Err(errFlags).Msgf("failed to parse command line for '%s' subcommand", THREAD.string())
+		}
+		if errAccount := internal.ValidateAccount(*threadAccount); errAccount != nil {
+			log.Fatal().Err(errAccount).Msg("failed to parse '--account' parameter")
+		}
+		if threadCommand.NArg() != 1 {
+			log.Fatal().Msg("usage: goyammer thread [--account <name>] [--token-store <store>] [--json] <id|url>")
+		}
+
+		// hand off to business logic
+		if !thread(*threadAccount, *threadTokenStore, threadCommand.Arg(0), *threadJson) {
+			os.Exit(1)
+		}
 
 	case POLL:
 
@@ -150,8 +666,29 @@ func main() {
 			log.Fatal().Err(errFlags).Msgf("failed to parse command line for '%s' subcommand", POLL.string())
 		}
 
+		// read the configuration file (flags given on the command line take precedence)
+		settings := applyConfig(pollCommand, *poll.config, *poll.profile)
+		options, errOptions := poll.options(settings)
+		if errOptions != nil {
+			log.Fatal().Err(errOptions).Msg("invalid options")
+		}
+
 		// unless foreground is set
-		if !*pollForeground {
+		if !*poll.foreground {
+
+			// refuse to start a second poller of an account (the detached child checks again while locking)
+			for _, account := range options.Accounts {
+				pid, errPid := internal.PollerPid(account)
+				if _, starting := errPid.(*internal.StartingError); starting {
+					log.Fatal().Msg(fmt.Sprintf("account '%s' is already polled by a poller which is starting", account))
+				}
+				if errPid != nil {
+					log.Fatal().Err(errPid).Msg("failed to check for a running poller")
+				}
+				if pid != 0 {
+					log.Fatal().Msg(fmt.Sprintf("account '%s' is already polled by process %d, use 'goyammer stop' first", account, pid))
+				}
+			}
 
 			// restart in a detached mode
 
@@ -161,13 +698,13 @@ func main() {
 				log.Fatal().Err(errCwd).Msg("failed to get cwd")
 			}
 
-			// construct a file for connecting STDERR and STDOUT of the child, if pollOutput is given
+			// construct a file for connecting STDERR and STDOUT of the child, if the output is given
 			var file *os.File
-			if *pollOutput != "" {
+			if options.Output != "" {
 
-				f, err := os.OpenFile(*pollOutput, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+				f, err := os.OpenFile(options.Output, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
 				if err != nil {
-					log.Fatal().Err(err).Msgf("couldn't open %s", *pollOutput)
+					log.Fatal().Err(err).Msgf("couldn't open %s", options.Output)
 				}
 				file = f
 				defer func() {
@@ -183,10 +720,33 @@ func main() {
 			cmd.Stdout = file
 			cmd.Stderr = file
 
+			// start a new session, so that the child survives the terminal being closed
+			cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+
+			// the detached child cannot ask for the passphrase of an encrypted token store, it inherits a pipe holding
+			// it as file descriptor 3 (rather than getting it in its environment)
+			var passphraseFile *os.File
+			if options.TokenStore == internal.StoreEncryptedFile {
+				passphrase, errPassphrase := internal.ReadPassphrase()
+				if errPassphrase != nil {
+					log.Fatal().Err(errPassphrase).Msg("failed to read passphrase")
+				}
+				f, errFile := internal.PassphraseFile(passphrase)
+				if errFile != nil {
+					log.Fatal().Err(errFile).Msg("failed to pass passphrase")
+				}
+				passphraseFile = f
+				cmd.ExtraFiles = []*os.File{f}
+				cmd.Env = append(os.Environ(), fmt.Sprintf("%s=3", internal.PassphraseFdEnv))
+			}
+
 			errStart := cmd.Start()
 			if errStart != nil {
 				log.Fatal().Err(errCwd).Msg("failed to restart")
 			}
+			if passphraseFile != nil {
+				_ = passphraseFile.Close()
+			}
 
 			pid := cmd.Process.Pid
 
@@ -195,18 +755,35 @@ func main() {
 				log.Fatal().Err(errRelease).Msg("failed to detach")
 			}
 
-			log.Info().Int("PID", pid).Str("logfile", *pollOutput).Msgf("DETACHED")
+			log.Info().Int("PID", pid).Str("logfile", options.Output).Msgf("DETACHED")
 
 		} else {
 
 			// start in the foreground
 
-			// get token from file
-			token := internal.GetToken()
+			// only one process may poll an account
+			var locks []*internal.PidLock
+			for _, account := range options.Accounts {
+				lock, errLock := internal.LockAccount(account)
+				if errLock != nil {
+					log.Fatal().Err(errLock).Msg("failed to lock account")
+				}
+				locks = append(locks, lock)
+			}
+
+			// get the tokens from the credential store
+			store, errStore := internal.NewCredentialStore(options.TokenStore)
+			if errStore != nil {
+				log.Fatal().Err(errStore).Msg("failed to open token store")
+			}
+			tokens := make(map[string]string)
+			for _, account := range options.Accounts {
+				tokens[account] = internal.GetToken(store, account)
+			}
 
 			// create a tmpdir dir where we store mug shot files and the logo
 			// note: we need a temp-dir as github.com/mqu/go-notify only supports file-based logos/mugshots.
-			tmpdir, errTmp := ioutil.TempDir("", "goyammer-mugshots")
+			tmpdir, errTmp := ioutil.TempDir("", internal.MugshotDirPattern)
 			if errTmp != nil {
 				log.Fatal().Msg(fmt.Sprintf("couldn't create tmpdir directory: %v", errTmp))
 			}
@@ -220,166 +797,951 @@ func main() {
 				logo = logoFile.Name()
 			}
 
-			// collect application assets
-			client := internal.NewClient(token)
-			users := internal.NewUsers(client, tmpdir)
-			messages := internal.NewMessages(client)
-			app := &app{users: users, messages: messages, tmpdir: tmpdir, logo: logo, background: background}
-			app.setupCloseHandler()
+			// set up notifications
+			notifier, errNotifier := internal.NewNotifier(options.Notifier)
+			if errNotifier != nil {
+				log.Fatal().Err(errNotifier).Msg("failed to set up notifications")
+			}
+
+			// one poller per account (all of them stop once the context is cancelled)
+			ctx, cancel := context.WithCancel(context.Background())
+			env := &internal.PollerEnv{
+				Store:      store,
+				Notifier:   notifier,
+				Logo:       logo,
+				Background: background,
+				Watchdog:   internal.NewWatchdog(options.Accounts),
+			}
+			var pollers []*internal.Poller
+			for _, account := range options.Accounts {
+
+				// mug shots of each account go to their own directory
+				mugdir := path.Join(tmpdir, account)
+				errMugdir := os.Mkdir(mugdir, 0700)
+				if errMugdir != nil {
+					log.Fatal().Err(errMugdir).Msg(fmt.Sprintf("couldn't create %s", mugdir))
+				}
+
+				poller, errPoller := internal.NewPoller(ctx, env, account, tokens[account], mugdir, options)
+				if errPoller != nil {
+					log.Fatal().Err(errPoller).Msg("failed to set up poller")
+				}
+				pollers = append(pollers, poller)
+			}
+
+			// serve the control sockets
+			for _, poller := range pollers {
+				errServe := poller.Serve()
+				if errServe != nil {
+					log.Fatal().Err(errServe).Msg("failed to set up control socket")
+				}
+			}
+			var once sync.Once
+			shutdown := func() {
+				once.Do(func() {
+					shutDown(cancel, tmpdir, pollers, locks)
+				})
+			}
+			setupCloseHandler(shutdown)
+			setupReloadHandler(flagArgs, options, pollers, background)
 
 			systray.Run(func() {
+				for _, poller := range pollers {
+					poller.SetTray(internal.Systray_account(poller.Account()))
+				}
 				internal.Systray_init()
-				app.doPoll(*pollInterval)
-			}, func() {})
+				for _, poller := range pollers {
+					go poller.Poll()
+				}
+				notifySystemd()
+			}, shutdown)
 
 		}
 	}
 }
 
-func isBackround() bool {
-	proc, errStat := process.NewProcess(int32(os.Getpid()))
-	if errStat != nil {
-		_, _ = fmt.Fprintf(os.Stderr, "failed to stat current process: %s", errStat)
-		os.Exit(1)
+// validateConfig reports unknown keys and bad values in the given configuration file and returns whether it is
+// valid.
+func validateConfig(configPath string, profile string) bool {
+	config, errConfig := internal.LoadConfig(configPath)
+	if errConfig != nil {
+		log.Error().Err(errConfig).Msg("invalid configuration")
+		return false
 	}
-	background, errBack := proc.Background()
-	if errBack != nil {
-		_, _ = fmt.Fprintf(os.Stderr, "failed to get background info: %s", errBack)
-		os.Exit(1)
+	valid := true
+	for _, key := range config.Unknown() {
+		log.Error().Msg(fmt.Sprintf("%s: unknown key", key))
+		valid = false
 	}
-	return background
+	for _, errValue := range config.Validate() {
+		log.Error().Msg(errValue.Error())
+		valid = false
+	}
+	if _, errProfile := config.Profile(profile); errProfile != nil {
+		log.Error().Err(errProfile).Msg("invalid profile")
+		valid = false
+	}
+	if valid {
+		log.Info().Msg(fmt.Sprintf("%s is valid", configPath))
+	}
+	return valid
 }
 
-// SetupCloseHandler creates a 'listener' on a new goroutine which will notify the
-// program if it receives an interrupt from the OS. We then handle this by calling
-// our clean up procedure and exiting the program.
-func (app *app) setupCloseHandler() {
-	c := make(chan os.Signal)
-	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
-	go func() {
-		<-c
-		//fmt.Printf("\r")
-		log.Info().Msg(fmt.Sprintf("SIGTERM received - cleaning up and shutting down"))
-		errRm := os.RemoveAll(app.tmpdir)
-		if errRm != nil {
-			log.Fatal().Err(errRm).Msg(fmt.Sprintf("failed to remove temp dir %s", app.tmpdir))
+// applyConfig reads the given configuration file and sets all flags of the given flag set not set explicitly to the
+// values of the given profile. It returns the settings of the profile.
+func applyConfig(flags *flag.FlagSet, configPath string, profile string) internal.Settings {
+	settings, errConfig := readConfig(flags, configPath, profile)
+	if errConfig != nil {
+		log.Fatal().Err(errConfig).Msg("failed to load configuration")
+	}
+	return settings
+}
+
+// readConfig is applyConfig returning errors instead of exiting.
+func readConfig(flags *flag.FlagSet, configPath string, profile string) (internal.Settings, error) {
+	config, errConfig := internal.LoadConfig(configPath)
+	if errConfig != nil {
+		return internal.Settings{}, errConfig
+	}
+	for _, key := range config.Unknown() {
+		log.Warn().Msg(fmt.Sprintf("ignoring unknown configuration key '%s'", key))
+	}
+	if errs := config.Validate(); len(errs) > 0 {
+		for _, errValue := range errs {
+			log.Error().Msg(errValue.Error())
 		}
-		os.Exit(0)
-	}()
+		return internal.Settings{}, fmt.Errorf("invalid configuration in %s", configPath)
+	}
+	settings, errProfile := config.Profile(profile)
+	if errProfile != nil {
+		return internal.Settings{}, fmt.Errorf("failed to select profile: %v", errProfile)
+	}
+
+	// flags set explicitly take precedence
+	errFlags := settings.ApplyFlags(flags)
+	if errFlags != nil {
+		return internal.Settings{}, errFlags
+	}
+
+	return settings, nil
 }
 
-func (app *app) doPoll(interval uint) {
+// checkToken reports whether the token of the given account works and to which user and network it belongs.
+func checkToken(store internal.CredentialStore, account string) bool {
+	token, errGet := store.Get(account)
+	if errGet == internal.ErrTokenNotFound {
+		log.Error().Msg(fmt.Sprintf("no token for account '%s' in %s, use 'login'", account, store.String()))
+		return false
+	}
+	if errGet != nil {
+		log.Error().Err(errGet).Msg("failed to read token")
+		return false
+	}
+	user, errUser := internal.NewClient(token).GetCurrentUser(context.Background())
+	if _, ok := errUser.(*internal.AuthError); ok {
+		log.Error().Msg(fmt.Sprintf("the token of account '%s' is invalid or expired, use 'login'", account))
+		return false
+	}
+	if errUser != nil {
+		log.Error().Err(errUser).Msg("failed to check token")
+		return false
+	}
+	log.Info().Msg(fmt.Sprintf("the token of account '%s' works: user %s (%s) in network %s", account, user.FullName, user.Email, user.NetworkName))
+	return true
+}
 
-	log.Info().Msg(fmt.Sprint("goyammer started"))
+// logout stops pollers of the given account, deletes its token and removes its poll state and cached mug shots. It
+// returns whether everything succeeded.
+func logout(account string) bool {
+	success := true
 
-	sleepTime := time.Duration(interval) * time.Second
-	log.Info().Msg(fmt.Sprintf("* polling: every %s", sleepTime.String()))
+	// stop the poller first (so that it doesn't write the state again)
+	if !stop([]string{account}) {
+		success = false
+	}
 
-	// get the current user
-	var currentUser *internal.User
-	for {
-		user, errUser := app.users.GetUser(-1)
-		currentUser = user
-		if errUser == nil {
-			break
+	stores := internal.CredentialStores()
+	deleted, errDelete := internal.DeleteToken(stores, account)
+	for _, store := range deleted {
+		log.Info().Msg(fmt.Sprintf("token deleted from %s", store.String()))
+	}
+	if errDelete != nil {
+		log.Error().Err(errDelete).Msg("failed to delete token")
+		success = false
+	} else if len(deleted) == 0 {
+		log.Warn().Msg(fmt.Sprintf("no token stored for account '%s'", account))
+	}
+
+	removed, errClear := internal.ClearAccountData(account)
+	for _, removedPath := range removed {
+		log.Info().Msg(fmt.Sprintf("removed %s", removedPath))
+	}
+	if errClear != nil {
+		log.Error().Err(errClear).Msg("failed to remove account data")
+		success = false
+	}
+	return success
+}
+
+// stop stops the pollers of the given accounts (or all pollers) and returns whether they stopped.
+func stop(accounts []string) bool {
+	if len(accounts) == 0 {
+		polled, errPolled := internal.PolledAccounts()
+		if errPolled != nil {
+			log.Error().Err(errPolled).Msg("failed to find pollers")
+			return false
 		}
-		log.Warn().Err(errUser).Msg("failed to get current user")
-		time.Sleep(sleepTime)
+		accounts = polled
 	}
-	log.Info().Msg(fmt.Sprintf("* user: %s", currentUser.FullName))
-	log.Info().Msg(fmt.Sprint("* groups:"))
-	for _, group := range *currentUser.Groups {
-		log.Info().Msg(fmt.Sprintf("  - %s", group.FullName))
+
+	// a process may poll several accounts
+	pids := make(map[int][]string)
+	for _, account := range accounts {
+		pid, errPid := internal.PollerPid(account)
+		if errPid != nil {
+			log.Error().Err(errPid).Msg(fmt.Sprintf("failed to find the poller of account '%s'", account))
+			return false
+		}
+		if pid == 0 {
+			log.Info().Msg(fmt.Sprintf("account '%s' is not polled", account))
+			continue
+		}
+		pids[pid] = append(pids[pid], account)
 	}
 
-	internal.Notify(
-		"goyammer",
-		fmt.Sprintf("Listening on %d groups for user %s.", len(*currentUser.Groups), currentUser.FullName),
-		app.logo)
+	success := true
+	for pid, polled := range pids {
+		log.Info().Msg(fmt.Sprintf("stopping poller %d (%s)", pid, strings.Join(polled, ", ")))
+		if !terminate(pid, 10*time.Second) {
+			log.Error().Msg(fmt.Sprintf("poller %d did not stop", pid))
+			success = false
+		}
+	}
+	return success
+}
 
-	// POLL messages
-	for {
-		for _, group := range *currentUser.Groups {
-			internal.Systray_poll()
-			gid := group.ID
+// status prints the status of the pollers of the given accounts (or of all pollers) and returns whether all of them
+// are running.
+func status(accounts []string) bool {
+	if len(accounts) == 0 {
+		polled, errPolled := internal.PolledAccounts()
+		if errPolled != nil {
+			log.Error().Err(errPolled).Msg("failed to find pollers")
+			return false
+		}
+		if len(polled) == 0 {
+			fmt.Println("no poller running")
+			return false
+		}
+		accounts = polled
+	}
 
-			newMessages, errNM := app.messages.GetNewMessages(gid)
-			if errNM != nil {
-				log.Warn().Err(errNM).Msg(fmt.Sprintf("failed to get new messages for group %s", group.FullName))
-			} else {
-				if len(newMessages) > 0 {
-					app.handleMessages(group.FullName, newMessages, currentUser)
-				}
+	running := true
+	for _, account := range accounts {
+		pid, errPid := internal.PollerPid(account)
+		if _, starting := errPid.(*internal.StartingError); starting {
+			fmt.Printf("%s: poller starting\n", account)
+			continue
+		}
+		if errPid != nil {
+			log.Error().Err(errPid).Msg(fmt.Sprintf("failed to find the poller of account '%s'", account))
+			running = false
+			continue
+		}
+		if pid == 0 {
+			fmt.Printf("%s: not polled\n", account)
+			running = false
+			continue
+		}
+		pollerStatus, errStatus := internal.ReadStatus(account)
+		if errStatus != nil || pollerStatus.Pid != pid {
+			fmt.Printf("%s: polled by process %d (no status yet)\n", account, pid)
+			continue
+		}
+		fmt.Printf("%s: polled by process %d, up %s\n", account, pid, time.Since(pollerStatus.Started).Round(time.Second))
+		if pollerStatus.Network != "" {
+			fmt.Printf("  network:    %s\n", pollerStatus.Network)
+		}
+		if pollerStatus.LoginRequired {
+			fmt.Printf("  login required (polling stopped)\n")
+		}
+		if pollerStatus.Paused {
+			fmt.Printf("  paused (see 'goyammer ctl')\n")
+		}
+		fmt.Printf("  groups:     %d\n", len(pollerStatus.Groups))
+		for _, group := range pollerStatus.Groups {
+			fmt.Printf("    - %s\n", group)
+		}
+		if pollerStatus.LastPoll != nil {
+			fmt.Printf("  last poll:  %s\n", formatTime(*pollerStatus.LastPoll))
+		} else {
+			fmt.Printf("  last poll:  none\n")
+		}
+		if pollerStatus.LastErrorTime != nil {
+			fmt.Printf("  last error: %s (%s)\n", pollerStatus.LastError, formatTime(*pollerStatus.LastErrorTime))
+		}
+	}
+	return running
+}
+
+// formatTime formats the given time in the local time zone along with how long ago it was.
+func formatTime(t time.Time) string {
+	return fmt.Sprintf("%s, %s ago", t.Local().Format("2006-01-02 15:04:05"), time.Since(t).Round(time.Second))
+}
+
+// ctl sends the given command (with its argument, if any) to the poller of the given account and prints the result.
+// It returns whether the command succeeded.
+func ctl(account string, args []string, printJson bool) bool {
+	if len(args) < 1 || len(args) > 2 {
+		log.Error().Msg("usage: goyammer ctl [--account <name>] [--json] pause|resume|poll-now [<group>]|mute <group>|unmute <group>|groups|messages [<count>]|log-level <level>")
+		return false
+	}
+	method := args[0]
+	arg := ""
+	if len(args) > 1 {
+		arg = args[1]
+	}
+
+	// the argument of the command
+	params := make(map[string]interface{})
+	switch method {
+	case "poll-now":
+		if arg != "" {
+			params["group"] = arg
+		}
+	case "mute", "unmute":
+		if arg == "" {
+			log.Error().Msg(fmt.Sprintf("usage: goyammer ctl %s <group>", method))
+			return false
+		}
+		params["group"] = arg
+	case "messages":
+		if arg != "" {
+			count, errCount := strconv.ParseUint(arg, 10, 32)
+			if errCount != nil {
+				log.Error().Msg(fmt.Sprintf("invalid number of messages '%s'", arg))
+				return false
 			}
-			internal.Systray_reset()
-			time.Sleep(sleepTime)
+			params["count"] = count
+		}
+	case "log-level":
+		if arg == "" {
+			log.Error().Msg("usage: goyammer ctl log-level trace|debug|info|warn|error")
+			return false
 		}
+		params["level"] = arg
+	}
+
+	var result json.RawMessage
+	errCall := internal.CallControl(internal.ControlPath(account), method, params, &result)
+	if errCall != nil {
+		log.Error().Err(errCall).Msg(fmt.Sprintf("'%s' failed", method))
+		return false
 	}
+	if printJson {
+		fmt.Println(string(result))
+		return true
+	}
+
+	switch method {
+	case "groups":
+		var groups []internal.ControlGroup
+		_ = json.Unmarshal(result, &groups)
+		for _, group := range groups {
+			muted := ""
+			if group.Muted {
+				muted = " (muted)"
+			}
+			fmt.Printf("%12d  %s%s: latest message %d, polled every %ds\n", group.ID, group.Name, muted, group.Latest, group.Interval)
+		}
+	case "messages":
+		var messages []internal.ControlMessage
+		_ = json.Unmarshal(result, &messages)
+		for i := len(messages) - 1; i >= 0; i-- {
+			message := messages[i]
+			fmt.Printf("%s  %s - %s\n%s\n%s\n\n", message.CreatedAt, message.Group, message.Sender, message.Body, message.WebUrl)
+		}
+	case "log-level":
+		var previous string
+		_ = json.Unmarshal(result, &previous)
+		fmt.Printf("log level changed from %s to %s\n", previous, arg)
+	default:
+		fmt.Println("ok")
+	}
+	return true
+}
+
+// postOptions describe the message to post.
+type postOptions struct {
+	body        string
+	group       string
+	replyTo     int64
+	topics      []string
+	to          []string
+	attachments []string
+	dryRun      bool
+}
+
+// readBody returns the message given as the only argument or read from the given file (stdin if the file is "-" or if
+// there is neither argument nor file).
+func readBody(args []string, file string) (string, error) {
+	if len(args) > 1 {
+		return "", fmt.Errorf("expected a single argument (quote the message)")
+	}
+	if len(args) == 1 && file != "" {
+		return "", fmt.Errorf("expected either an argument or '--file'")
+	}
+	var body string
+	switch {
+	case len(args) == 1:
+		body = args[0]
+	case file == "" || file == "-":
+		data, errRead := ioutil.ReadAll(os.Stdin)
+		if errRead != nil {
+			return "", fmt.Errorf("failed to read stdin: %v", errRead)
+		}
+		body = string(data)
+	default:
+		data, errRead := ioutil.ReadFile(file)
+		if errRead != nil {
+			return "", fmt.Errorf("failed to read %s: %v", file, errRead)
+		}
+		body = string(data)
+	}
+	body = strings.TrimSpace(body)
+	if body == "" {
+		return "", fmt.Errorf("the message is empty")
+	}
+	return body, nil
+}
+
+// post posts the given message as the given account (or prints the request if it's a dry run). It returns whether
+// that succeeded.
+func post(account string, backend string, options postOptions) bool {
+	client := newAccountClient(account, backend)
+	if client == nil {
+		return false
+	}
+	ctx := context.Background()
+
+	message := &internal.NewMessage{
+		Body:        options.body,
+		RepliedToID: options.replyTo,
+		Topics:      options.topics,
+	}
+
+	// resolve the group and the recipients
+	if options.group != "" {
+		groupId, errGroup := findGroup(ctx, client, options.group)
+		if errGroup != nil {
+			log.Error().Err(errGroup).Msg("failed to find group")
+			return false
+		}
+		message.GroupID = groupId
+	}
+	for _, recipient := range options.to {
+		userId, errUser := findUser(ctx, client, recipient)
+		if errUser != nil {
+			log.Error().Err(errUser).Msg("failed to find recipient")
+			return false
+		}
+		message.DirectToUserIDs = append(message.DirectToUserIDs, userId)
+	}
+
+	// read all attachments before uploading any
+	contents := make([][]byte, len(options.attachments))
+	for i, attachment := range options.attachments {
+		content, errRead := ioutil.ReadFile(attachment)
+		if errRead != nil {
+			log.Error().Err(errRead).Msg("failed to read attachment")
+			return false
+		}
+		contents[i] = content
+	}
+
+	if options.dryRun {
+		for i, attachment := range options.attachments {
+			log.Info().Msg(fmt.Sprintf("would upload %s (%d bytes)", attachment, len(contents[i])))
+		}
+		errDump := client.DumpPost(message, os.Stdout)
+		if errDump != nil {
+			log.Error().Err(errDump).Msg("failed to print request")
+			return false
+		}
+		return true
+	}
+
+	for i, attachment := range options.attachments {
+		uploaded, errUpload := client.UploadAttachment(ctx, path.Base(attachment), contents[i])
+		if errUpload != nil {
+			log.Error().Err(errUpload).Msg("failed to upload attachment")
+			return false
+		}
+		message.PendingAttachments = append(message.PendingAttachments, uploaded.ID)
+	}
+
+	posted, errPost := client.PostMessage(ctx, message)
+	if errPost != nil {
+		log.Error().Err(errPost).Msg("failed to post message")
+		return false
+	}
+	log.Info().Msg(fmt.Sprintf("posted message %d: %s", posted.ID, posted.WebUrl))
+	return true
+}
+
+// newAccountClient returns a client using the token of the given account stored in the given token store (or nil if
+// there is none).
+func newAccountClient(account string, backend string) *internal.Client {
+	store, errStore := internal.NewCredentialStore(backend)
+	if errStore != nil {
+		log.Error().Err(errStore).Msg("failed to open token store")
+		return nil
+	}
+	token, errGet := store.Get(account)
+	if errGet == internal.ErrTokenNotFound {
+		log.Error().Msg(fmt.Sprintf("no token for account '%s' in %s, use 'login'", account, store.String()))
+		return nil
+	}
+	if errGet != nil {
+		log.Error().Err(errGet).Msg("failed to read token")
+		return nil
+	}
+	return internal.NewClient(token)
+}
+
+// messageAction is an action of 'message' along with what is logged once done (given the id).
+type messageAction struct {
+	do   func(client *internal.Client, ctx context.Context, id int64) error
+	done string
 }
 
-func (app *app) handleMessages(groupName string, messages []*internal.Message, currentUser *internal.User) {
+// messageActions are the actions of 'message' by name.
+var messageActions = map[string]*messageAction{
+	"like":        {do: (*internal.Client).LikeMessage, done: "liked message %d"},
+	"unlike":      {do: (*internal.Client).UnlikeMessage, done: "unliked message %d"},
+	"follow":      {do: (*internal.Client).FollowThread, done: "following thread %d"},
+	"unfollow":    {do: (*internal.Client).UnfollowThread, done: "no longer following thread %d"},
+	"seen":        {do: (*internal.Client).MarkSeen, done: "marked message %d as seen"},
+	"seen-thread": {do: (*internal.Client).MarkThreadSeen, done: "marked thread %d as seen"},
+}
 
-	// regex matching newline newlines
-	re := regexp.MustCompile(`\r?\n`)
+// actOnMessage applies the given action to the message (or thread) with the given id as the given account. It returns
+// whether that succeeded.
+func actOnMessage(account string, backend string, action string, id int64) bool {
+	client := newAccountClient(account, backend)
+	if client == nil {
+		return false
+	}
+	errAction := messageActions[action].do(client, context.Background(), id)
+	if errAction != nil {
+		log.Error().Msg(errAction.Error())
+		return false
+	}
+	log.Info().Msg(fmt.Sprintf(messageActions[action].done, id))
+	return true
+}
 
-	notified := false
+// threadEntry is a message of a thread as printed by 'thread --json'.
+type threadEntry struct {
+	ID          int64          `json:"id"`
+	RepliedToID int64          `json:"replied_to_id,omitempty"`
+	SenderID    int64          `json:"sender_id"`
+	Sender      string         `json:"sender"`
+	CreatedAt   string         `json:"created_at"`
+	Body        string         `json:"body"`
+	WebUrl      string         `json:"web_url"`
+	Replies     []*threadEntry `json:"replies,omitempty"`
+}
 
-	// go through all messages from newest to oldest
-	for i := len(messages) - 1; i >= 0; i-- {
+// thread prints the thread given by ID or URL (or the thread of the message given by URL) as reply tree. It returns
+// whether that succeeded.
+func thread(account string, backend string, ref string, printJson bool) bool {
+	threadId, isMessage, errRef := internal.ParseThreadRef(ref)
+	if errRef != nil {
+		log.Error().Err(errRef).Msg("failed to parse thread")
+		return false
+	}
+	client := newAccountClient(account, backend)
+	if client == nil {
+		return false
+	}
+	ctx := context.Background()
+
+	// a message URL leads to its thread
+	if isMessage {
+		message, errMessage := client.GetMessage(ctx, threadId)
+		if errMessage != nil {
+			log.Error().Err(errMessage).Msg("failed to get message")
+			return false
+		}
+		threadId = message.ThreadID
+	}
 
-		message := messages[i]
+	messages, errThread := client.GetThread(ctx, threadId)
+	if errThread != nil {
+		log.Error().Err(errThread).Msg("failed to get thread")
+		return false
+	}
+	if len(messages) == 0 {
+		log.Error().Msg(fmt.Sprintf("thread %d has no messages", threadId))
+		return false
+	}
 
-		// get the sender
-		senderId := message.SenderID
-		user, errUser := app.users.GetUser(senderId)
+	// resolve the authors (once each)
+	users := internal.NewUsers(client, "")
+	authors := make(map[int64]string)
+	for _, message := range messages {
+		if _, ok := authors[message.SenderID]; ok {
+			continue
+		}
+		authors[message.SenderID] = fmt.Sprintf("%s %d", message.SenderType, message.SenderID)
+		if message.SenderType != "user" {
+			continue
+		}
+		user, errUser := users.GetUser(ctx, message.SenderID)
 		if errUser != nil {
-			log.Warn().Err(errUser).Msg(fmt.Sprintf("failed to get user: %d", senderId))
+			log.Warn().Err(errUser).Msg(fmt.Sprintf("failed to get user: %d", message.SenderID))
 			continue
 		}
+		authors[message.SenderID] = user.FullName
+	}
 
-		// if there is plain text in the message and we have a full name
-		if message.Body.Plain != "" && user.FullName != "" {
+	entries := threadEntries(internal.BuildThread(messages), authors)
+	if printJson {
+		data, errJson := json.MarshalIndent(entries, "", "  ")
+		if errJson != nil {
+			log.Error().Err(errJson).Msg("failed to encode thread")
+			return false
+		}
+		fmt.Println(string(data))
+		return true
+	}
+	printThread(entries, 0)
+	return true
+}
 
-			// replace newlines
-			simpleMessage := re.ReplaceAllString(message.Body.Plain, " ")
+// threadEntries returns the given reply trees with the authors by sender ID and local timestamps.
+func threadEntries(nodes []*internal.ThreadNode, authors map[int64]string) []*threadEntry {
+	entries := make([]*threadEntry, len(nodes))
+	for i, node := range nodes {
+		message := node.Message
+		createdAt := message.CreatedAt
+		if created, errCreated := internal.ParseCreatedAt(createdAt); errCreated == nil {
+			createdAt = created.Local().Format(time.RFC3339)
+		}
+		entries[i] = &threadEntry{
+			ID:          message.ID,
+			RepliedToID: message.RepliedToID,
+			SenderID:    message.SenderID,
+			Sender:      authors[message.SenderID],
+			CreatedAt:   createdAt,
+			Body:        message.Body.Plain,
+			WebUrl:      message.WebUrl,
+			Replies:     threadEntries(node.Replies, authors),
+		}
+	}
+	return entries
+}
 
-			// log for background or foreground
-			if app.background {
+// printThread prints the given messages and (indented) their replies.
+func printThread(entries []*threadEntry, depth int) {
+	indent := strings.Repeat("    ", depth)
+	for _, entry := range entries {
+		createdAt := entry.CreatedAt
+		if created, errCreated := time.Parse(time.RFC3339, createdAt); errCreated == nil {
+			createdAt = created.Format("2006-01-02 15:04")
+		}
+		fmt.Printf("%s%s, %s (%d):\n", indent, entry.Sender, createdAt, entry.ID)
+		for _, line := range strings.Split(entry.Body, "\n") {
+			fmt.Printf("%s  %s\n", indent, line)
+		}
+		fmt.Println()
+		printThread(entry.Replies, depth+1)
+	}
+}
 
-				// construct and format the logMsg
-				logMsg := fmt.Sprintf("%s -- %s", simpleMessage, message.WebUrl)
-				log.Info().Str("group", groupName).Str("user", user.FullName).Msg(logMsg)
-			} else {
+// findGroup returns the ID of the group of the current user matching the given ID, name or /regex/ (which must be
+// unambiguous).
+func findGroup(ctx context.Context, client *internal.Client, spec string) (int64, error) {
+	if groupId, errParse := strconv.ParseInt(spec, 10, 64); errParse == nil && groupId > 0 {
+		return groupId, nil
+	}
+	filter, errFilter := internal.NewGroupFilter([]string{spec}, nil)
+	if errFilter != nil {
+		return 0, errFilter
+	}
+	user, errUser := internal.NewUsers(client, "").GetUser(ctx, -1)
+	if errUser != nil {
+		return 0, errUser
+	}
+	var matches []internal.YammerGroup
+	for _, group := range *user.Groups {
+		if selected, _ := filter.Select(group); selected && group.ID != -1 {
+			matches = append(matches, group)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return 0, fmt.Errorf("no group matching '%s'", spec)
+	case 1:
+		return matches[0].ID, nil
+	default:
+		names := make([]string, len(matches))
+		for i, group := range matches {
+			names[i] = group.FullName
+		}
+		return 0, fmt.Errorf("'%s' matches several groups: %s", spec, strings.Join(names, ", "))
+	}
+}
 
-				// construct and format the logMsg
-				logMsg := fmt.Sprintf("%s - %s | %s",
-					internal.ElipseMe(groupName, 6, true),
-					internal.ElipseMe(user.FullName, 6, true),
-					internal.ElipseMe(simpleMessage, 50, false))
-				log.Info().Msg(logMsg)
-			}
+// findUser returns the ID of the user with the given ID or email address.
+func findUser(ctx context.Context, client *internal.Client, spec string) (int64, error) {
+	if userId, errParse := strconv.ParseInt(spec, 10, 64); errParse == nil && userId > 0 {
+		return userId, nil
+	}
+	if !strings.Contains(spec, "@") {
+		return 0, fmt.Errorf("invalid recipient '%s', expected a user ID or email address", spec)
+	}
+	user, errUser := client.GetUserByEmail(ctx, spec)
+	if errUser != nil {
+		return 0, errUser
+	}
+	return user.ID, nil
+}
 
-			// only if no message from the batch has been notified and message was not send by current user
-			if !notified && message.SenderID != currentUser.ID {
+// installService writes the systemd user unit polling in the foreground with the given options and enables and starts
+// it. It returns whether that succeeded.
+func installService(pollArgs []string) bool {
+	executable, errExecutable := os.Executable()
+	if errExecutable != nil {
+		log.Error().Err(errExecutable).Msg("failed to find the goyammer executable")
+		return false
+	}
+	command := append([]string{executable, POLL.string(), "--foreground"}, pollArgs...)
 
-				// set icon (either mugshot or default logo)
-				myIcon := app.logo
-				file, errMug := app.users.GetMugFile(user)
-				if errMug == nil {
-					myIcon = file.Name()
-				}
+	unitPath := internal.UnitPath()
+	errDir := os.MkdirAll(path.Dir(unitPath), 0700)
+	if errDir != nil {
+		log.Error().Err(errDir).Msg(fmt.Sprintf("failed to create %s", path.Dir(unitPath)))
+		return false
+	}
+	errWrite := ioutil.WriteFile(unitPath, []byte(internal.Unit(command)), 0644)
+	if errWrite != nil {
+		log.Error().Err(errWrite).Msg(fmt.Sprintf("failed to write %s", unitPath))
+		return false
+	}
+	log.Info().Msg(fmt.Sprintf("wrote %s", unitPath))
 
-				summary := user.FullName
-				body := fmt.Sprintf("%s\n\n%s", message.Body.Plain, message.WebUrl)
-				if len(messages) > 1 {
-					body = fmt.Sprintf("%s\n\n... and %d more", body, len(messages)-1)
-				}
+	// a poller started by hand would keep the service from locking the account
+	if accounts, _ := internal.PolledAccounts(); len(accounts) > 0 {
+		log.Warn().Msg(fmt.Sprintf("accounts %s are polled already, use 'goyammer stop' and 'goyammer service install' again if the service fails", strings.Join(accounts, ", ")))
+	}
+	return systemctl("daemon-reload") && systemctl("enable", "--now", internal.ServiceName)
+}
+
+// uninstallService stops and disables the systemd user unit and removes it. It returns whether that succeeded.
+func uninstallService() bool {
+	unitPath := internal.UnitPath()
+	if !internal.FileExists(unitPath) {
+		log.Error().Msg(fmt.Sprintf("%s is not installed", internal.ServiceName))
+		return false
+	}
+	if !systemctl("disable", "--now", internal.ServiceName) {
+		return false
+	}
+	errRemove := os.Remove(unitPath)
+	if errRemove != nil {
+		log.Error().Err(errRemove).Msg(fmt.Sprintf("failed to remove %s", unitPath))
+		return false
+	}
+	log.Info().Msg(fmt.Sprintf("removed %s", unitPath))
+	return systemctl("daemon-reload")
+}
 
-				internal.Notify(summary, body, myIcon)
+// systemctl runs systemctl on the user's service manager and returns whether it succeeded.
+func systemctl(args ...string) bool {
+	cmd := exec.Command("systemctl", append([]string{"--user"}, args...)...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	errRun := cmd.Run()
+	if errRun != nil {
+		if _, ok := errRun.(*exec.ExitError); !ok {
+			log.Error().Err(errRun).Msg("failed to run systemctl")
+		}
+		return false
+	}
+	return true
+}
 
-				notified = true
-			}
+// terminate sends SIGTERM to the given process and waits (up to the given timeout) for it to exit.
+func terminate(pid int, timeout time.Duration) bool {
+	errKill := syscall.Kill(pid, syscall.SIGTERM)
+	if errKill != nil {
+		return errKill == syscall.ESRCH
+	}
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if syscall.Kill(pid, 0) == syscall.ESRCH {
+			return true
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return false
+}
+
+func isBackround() bool {
+	proc, errStat := process.NewProcess(int32(os.Getpid()))
+	if errStat != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "failed to stat current process: %s", errStat)
+		os.Exit(1)
+	}
+	background, errBack := proc.Background()
+	if errBack != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "failed to get background info: %s", errBack)
+		os.Exit(1)
+	}
+	return background
+}
+
+// SetupCloseHandler creates a 'listener' on a new goroutine which will notify the
+// program if it receives an interrupt from the OS. We then handle this by calling
+// our shut down procedure and quitting the systray (which makes the program exit).
+func setupCloseHandler(shutdown func()) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-c
+		//fmt.Printf("\r")
+		log.Info().Msg(fmt.Sprintf("SIGTERM received - cleaning up and shutting down"))
+		shutdown()
+		systray.Quit()
+	}()
+}
+
+// setupReloadHandler reloads the configuration and the tokens (given the command line of 'poll') whenever SIGHUP is
+// received.
+func setupReloadHandler(args []string, options *internal.PollOptions, pollers []*internal.Poller, background bool) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGHUP)
+	go func() {
+		for range c {
+			log.Info().Msg("SIGHUP received - reloading configuration and tokens")
+			_, _ = internal.SdNotify("RELOADING=1")
+			options = reload(args, options, pollers, background)
+			_, _ = internal.SdNotify("READY=1")
+		}
+	}()
+}
+
+// reload reads the configuration again, logs what changed and applies it (and the tokens) to the given pollers. It
+// returns the options in effect afterwards.
+func reload(args []string, current *internal.PollOptions, pollers []*internal.Poller, background bool) *internal.PollOptions {
+	options, errOptions := reloadOptions(args)
+	if errOptions != nil {
+		log.Error().Err(errOptions).Msg("failed to reload configuration, keeping the current one")
+		return current
+	}
+
+	changes, restart := internal.DiffOptions(current, options)
+	for _, change := range changes {
+		log.Info().Msg(fmt.Sprintf("changed %s", change))
+	}
+	for _, name := range restart {
+		log.Warn().Msg(fmt.Sprintf("changing %s requires a restart, keeping the current value", name))
+	}
+	if len(changes) == 0 && len(restart) == 0 {
+		log.Info().Msg("configuration unchanged")
+	}
+
+	options.KeepRestartOptions(current)
+
+	// the output may have been moved away (e.g. by logrotate)
+	if background && options.Output != "" {
+		errOutput := reopenOutput(options.Output)
+		if errOutput != nil {
+			log.Error().Err(errOutput).Msg("failed to reopen output")
+		}
+	}
+
+	for _, poller := range pollers {
+		poller.Reload(options)
+	}
+	return options
+}
+
+// reloadOptions parses the given command line of 'poll' and reads the configuration file again.
+func reloadOptions(args []string) (*internal.PollOptions, error) {
+	flags := flag.NewFlagSet("", flag.ContinueOnError)
+	flags.SetOutput(ioutil.Discard)
+	poll := newPollFlags(flags)
+	errFlags := flags.Parse(args)
+	if errFlags != nil {
+		return nil, fmt.Errorf("failed to parse command line: %v", errFlags)
+	}
+	settings, errConfig := readConfig(flags, *poll.config, *poll.profile)
+	if errConfig != nil {
+		return nil, errConfig
+	}
+	return poll.options(settings)
+}
+
+// reopenOutput connects STDOUT and STDERR to the given file again.
+func reopenOutput(output string) error {
+	file, errOpen := os.OpenFile(output, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if errOpen != nil {
+		return fmt.Errorf("couldn't open %s: %v", output, errOpen)
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+	for _, fd := range []int{int(os.Stdout.Fd()), int(os.Stderr.Fd())} {
+		errDup := syscall.Dup3(int(file.Fd()), fd, 0)
+		if errDup != nil {
+			return fmt.Errorf("failed to redirect output to %s: %v", output, errDup)
+		}
+	}
+	return nil
+}
+
+// notifySystemd tells systemd that polling started (if running as a service, the pollers keep its watchdog happy).
+func notifySystemd() {
+	_, errNotify := internal.SdNotify("READY=1")
+	if errNotify != nil {
+		log.Warn().Err(errNotify).Msg("failed to notify systemd")
+	}
+}
+
+// the time to wait for the pollers to stop on shutdown
+const shutdownTimeout = 10 * time.Second
+
+// shutDown cancels outstanding requests, waits for the pollers to stop (saving their state) and cleans up.
+func shutDown(cancel context.CancelFunc, tmpdir string, pollers []*internal.Poller, locks []*internal.PidLock) {
+	_, _ = internal.SdNotify("STOPPING=1")
+	cancel()
+	deadline := time.Now().Add(shutdownTimeout)
+	for _, poller := range pollers {
+		select {
+		case <-poller.Done():
+		case <-time.After(time.Until(deadline)):
+			log.Warn().Msg(fmt.Sprintf("poller of account '%s' did not stop in time", poller.Account()))
 		}
+	}
+	cleanUp(tmpdir, pollers, locks)
+}
 
+// cleanUp removes the temp dir, the control sockets and the status files and releases the locks of the accounts.
+func cleanUp(tmpdir string, pollers []*internal.Poller, locks []*internal.PidLock) {
+	for _, poller := range pollers {
+		poller.CleanUp()
+	}
+	for _, lock := range locks {
+		errRelease := lock.Release()
+		if errRelease != nil {
+			log.Warn().Err(errRelease).Msg("failed to release lock")
+		}
+	}
+	errRm := os.RemoveAll(tmpdir)
+	if errRm != nil {
+		log.Error().Err(errRm).Msg(fmt.Sprintf("failed to remove temp dir %s", tmpdir))
 	}
 }